@@ -1,6 +1,8 @@
 package config
 
 import (
+	"github.com/schubergphilis/mercury/internal/web"
+	"github.com/schubergphilis/mercury/pkg/audit"
 	"github.com/schubergphilis/mercury/pkg/balancer"
 	"github.com/schubergphilis/mercury/pkg/healthcheck"
 	"github.com/schubergphilis/mercury/pkg/proxy"
@@ -64,3 +66,39 @@ type ClusterPacketClearProxyStatistics struct {
 
 // ClusterPacketConfigRequest is the packet type sent for configuration requests
 type ClusterPacketConfigRequest struct{}
+
+// ClusterPacketHealthCheckTransition contains a node state transition for the healthcheck history of peers
+type ClusterPacketHealthCheckTransition struct {
+	Entry healthcheck.HistoryEntry `json:"entry"`
+}
+
+// ClusterPacketMaintenanceWindowUpdate contains a maintenance window added or removed through the api
+type ClusterPacketMaintenanceWindowUpdate struct {
+	Window MaintenanceWindow `json:"window"`
+	Remove bool              `json:"remove"`
+}
+
+// ClusterPacketSplitWeightUpdate contains a split weight of a backend changed or reset through the api
+type ClusterPacketSplitWeightUpdate struct {
+	PoolName    string `json:"poolname"`
+	BackendName string `json:"backendname"`
+	Weight      int    `json:"weight"`
+	Remove      bool   `json:"remove"`
+}
+
+// ClusterPacketAPITokenUpdate contains an api token created or revoked through the api
+type ClusterPacketAPITokenUpdate struct {
+	Token web.APIToken `json:"token"`
+}
+
+// ClusterPacketAuditEntries contains audit log entries to mirror on peers
+type ClusterPacketAuditEntries struct {
+	Entries []audit.Entry `json:"entries"`
+}
+
+// ClusterPacketLimitCounters contains the rate limit counters of a backend, shared with the cluster nodes
+type ClusterPacketLimitCounters struct {
+	PoolName    string               `json:"poolname"`
+	BackendName string               `json:"backendname"`
+	Counters    []proxy.LimitCounter `json:"counters"`
+}
@@ -13,12 +13,14 @@ import (
 	yaml "gopkg.in/yaml.v2"
 
 	"github.com/schubergphilis/mercury/internal/web"
+	"github.com/schubergphilis/mercury/pkg/audit"
 	"github.com/schubergphilis/mercury/pkg/balancer"
 	"github.com/schubergphilis/mercury/pkg/cluster"
 	"github.com/schubergphilis/mercury/pkg/dns"
 	"github.com/schubergphilis/mercury/pkg/healthcheck"
 	"github.com/schubergphilis/mercury/pkg/logging"
 	"github.com/schubergphilis/mercury/pkg/param"
+	"github.com/schubergphilis/mercury/pkg/proxy"
 	"github.com/schubergphilis/mercury/pkg/tlsconfig"
 
 	"github.com/BurntSushi/toml"
@@ -55,12 +57,14 @@ const (
 
 // Config holds your main config
 type Config struct {
-	Logging      LoggingConfig `toml:"logging" json:"logging"`
-	Cluster      Cluster       `toml:"cluster" json:"cluster"`
-	DNS          dns.Config    `toml:"dns" json:"dns"`
-	Settings     Settings      `toml:"settings" json:"settings"`
-	Loadbalancer Loadbalancer  `toml:"loadbalancer" json:"loadbalancer"`
-	Web          web.Config    `toml:"web" json:"web"`
+	Logging      LoggingConfig       `toml:"logging" json:"logging"`
+	Cluster      Cluster             `toml:"cluster" json:"cluster"`
+	DNS          dns.Config          `toml:"dns" json:"dns"`
+	Settings     Settings            `toml:"settings" json:"settings"`
+	Loadbalancer Loadbalancer        `toml:"loadbalancer" json:"loadbalancer"`
+	Web          web.Config          `toml:"web" json:"web"`
+	Maintenance  []MaintenanceWindow `toml:"maintenance" json:"maintenance"`
+	Audit        audit.Config        `toml:"audit" json:"audit"`
 }
 
 // Cluster contains the cluster settings
@@ -175,11 +179,32 @@ func (c *Config) ParseConfig() error {
 			}
 		}
 
+		if pool.LimitPage.File != "" {
+			if _, err := os.Stat(pool.LimitPage.File); err != nil {
+				return fmt.Errorf("Cannot access limit page for pool:%s file:%s error:%s", poolName, pool.LimitPage.File, err)
+			}
+		}
+
+		if err := pool.StatusPages.Validate(); err != nil {
+			return fmt.Errorf("Invalid status_pages for pool:%s error:%s", poolName, err)
+		}
+
+		if err := pool.WAF.Validate(); err != nil {
+			return fmt.Errorf("Invalid waf for pool:%s error:%s", poolName, err)
+		}
+
+		if err := pool.Compression.Validate(); err != nil {
+			return fmt.Errorf("Invalid compression for pool:%s error:%s", poolName, err)
+		}
+
 		p := c.Loadbalancer.Pools[poolName]
 		if p.ErrorPage.TriggerThreshold == 0 {
 			p.ErrorPage.TriggerThreshold = 500
 		}
 
+		p.Compression = SetCompressionDefault(p.Compression)
+		p.TrafficSplit = SetTrafficSplitDefault(p.TrafficSplit)
+
 		if p.Listener.Mode == "" {
 			p.Listener.Mode = "tcp"
 		}
@@ -198,6 +223,15 @@ func (c *Config) ParseConfig() error {
 				return fmt.Errorf("Duplicate ip/port configuration: both pools are using the same ip/port: %s and %s use %s:%d (%s/%s)", poolName, conflict, p.Listener.IP, p.Listener.Port, protocol, p.Listener.Mode)
 			}
 			ipportmap[portmap] = poolName
+
+			// HTTP/3 uses the same port over udp
+			if p.Listener.HTTP3 {
+				portmap := fmt.Sprintf("%s_%d_udp", p.Listener.IP, p.Listener.Port)
+				if conflict, ok := ipportmap[portmap]; ok {
+					return fmt.Errorf("Duplicate ip/port configuration: both pools are using the same ip/port: %s and %s use %s:%d (udp/http3)", poolName, conflict, p.Listener.IP, p.Listener.Port)
+				}
+				ipportmap[portmap] = poolName
+			}
 		}
 
 		if p.Listener.OCSPStapling == "" {
@@ -212,6 +246,25 @@ func (c *Config) ParseConfig() error {
 			p.Listener.HTTPProto = 2
 		}
 
+		switch p.Listener.HTTPProto {
+		case 1, 2:
+		case 3:
+			return fmt.Errorf("Invalid httpproto for pool %s: HTTP/3 is enabled with http3 = true on an https listener", poolName)
+		default:
+			return fmt.Errorf("Invalid httpproto for pool %s: %d (must be 1 or 2)", poolName, p.Listener.HTTPProto)
+		}
+
+		// QUIC always uses TLS 1.3
+		if p.Listener.HTTP3 {
+			if p.Listener.Mode != "https" {
+				return fmt.Errorf("Invalid http3 for pool %s: HTTP/3 requires an https listener", poolName)
+			}
+
+			if maxVersion := p.Listener.TLSConfig.MaxVersion; maxVersion != "" && !strings.EqualFold(maxVersion, "VersionTLS13") {
+				return fmt.Errorf("Invalid http3 for pool %s: HTTP/3 requires TLS 1.3, but the tls maxversion is %s", poolName, maxVersion)
+			}
+		}
+
 		// Default writetimeout for listener is 0 = unlimited time
 		// Default readtimeout for listener is 10 seconds
 		if p.Listener.ReadTimeout == 0 {
@@ -229,6 +282,10 @@ func (c *Config) ParseConfig() error {
 			c.Loadbalancer.Pools[poolName] = p
 		}
 
+		if err := healthcheck.SetDefaultNames(c.Loadbalancer.Pools[poolName].HealthChecks, nil); err != nil {
+			return fmt.Errorf("Invalid health checks for pool:%s error:%s", poolName, err)
+		}
+
 		for backendName, backend := range c.Loadbalancer.Pools[poolName].Backends {
 			h := backend
 
@@ -267,6 +324,12 @@ func (c *Config) ParseConfig() error {
 				}
 			}
 
+			if backend.LimitPage.File != "" {
+				if _, err := os.Stat(backend.LimitPage.File); err != nil {
+					return fmt.Errorf("Cannot access limit page for pool:%s backend:%s file:%s error:%s", poolName, backendName, backend.LimitPage.File, err)
+				}
+			}
+
 			for hid, check := range c.Loadbalancer.Pools[poolName].Backends[backendName].HealthChecks {
 				h.HealthChecks[hid] = SetHealthCheckDefault(check)
 				if backend.BalanceMode.ActivePassive == YES {
@@ -293,6 +356,57 @@ func (c *Config) ParseConfig() error {
 				h.HealthCheckMode = "all"
 			}
 
+			if err := healthcheck.SetDefaultNames(h.HealthChecks, c.Loadbalancer.Pools[poolName].HealthChecks); err != nil {
+				return fmt.Errorf("Invalid health checks for pool:%s backend:%s error:%s", poolName, backendName, err)
+			}
+
+			if err := healthcheck.ValidateChecks(h.HealthCheckMode, append(append([]healthcheck.HealthCheck{}, c.Loadbalancer.Pools[poolName].HealthChecks...), h.HealthChecks...)); err != nil {
+				return fmt.Errorf("Invalid health checks for pool:%s backend:%s error:%s", poolName, backendName, err)
+			}
+
+			if backend.DrainTimeout == 0 {
+				h.DrainTimeout = 300
+			}
+
+			h.Auth = SetBackendAuthDefault(backend.Auth)
+			if err := h.Auth.Validate(); err != nil {
+				return fmt.Errorf("Invalid auth for pool:%s backend:%s error:%s", poolName, backendName, err)
+			}
+
+			if backend.SplitWeight < 0 {
+				return fmt.Errorf("Invalid split_weight for pool:%s backend:%s error:weight must be 0 or more", poolName, backendName)
+			}
+
+			if backend.Mirror.Backend != "" {
+				if _, ok := pool.Backends[backend.Mirror.Backend]; !ok || backend.Mirror.Backend == backendName {
+					return fmt.Errorf("Invalid mirror for pool:%s backend:%s error:unknown backend %s", poolName, backendName, backend.Mirror.Backend)
+				}
+			}
+
+			h.Timeouts = SetBackendTimeoutsDefault(backend.Timeouts, c.Loadbalancer.Pools[poolName].Listener.Mode)
+			if err := h.Timeouts.Validate(); err != nil {
+				return fmt.Errorf("Invalid timeouts for pool:%s backend:%s error:%s", poolName, backendName, err)
+			}
+
+			if err := backend.StatusPages.Validate(); err != nil {
+				return fmt.Errorf("Invalid status_pages for pool:%s backend:%s error:%s", poolName, backendName, err)
+			}
+
+			if err := backend.Websocket.Validate(); err != nil {
+				return fmt.Errorf("Invalid websocket for pool:%s backend:%s error:%s", poolName, backendName, err)
+			}
+
+			h.Mirror = SetMirrorDefault(backend.Mirror)
+			if err := h.Mirror.Validate(); err != nil {
+				return fmt.Errorf("Invalid mirror for pool:%s backend:%s error:%s", poolName, backendName, err)
+			}
+
+			for _, route := range backend.Routes {
+				if err := route.Validate(); err != nil {
+					return fmt.Errorf("Invalid route %s for pool:%s backend:%s error:%s", route.Name, poolName, backendName, err)
+				}
+			}
+
 			// Backwards compatibility: if ClusterNodes is set, put this in the new ServingClusterNdoes
 			if backend.BalanceMode.ClusterNodes != 0 {
 				h.BalanceMode.ServingClusterNodes = backend.BalanceMode.ClusterNodes
@@ -369,13 +483,179 @@ func (c *Config) ParseConfig() error {
 		}
 	}
 
+	// Maintenance windows
+	for id, window := range c.Maintenance {
+		if window.Name == "" {
+			c.Maintenance[id].Name = fmt.Sprintf("config-%d", id)
+		}
+		c.Maintenance[id].Source = "config"
+
+		if err := c.Maintenance[id].Validate(); err != nil {
+			return err
+		}
+	}
+
 	SetDefaultSettingsConfig(&c.Settings)
 	SetDefaultClusterConfig(&c.Cluster.Settings)
 	SetDefaultDNSConfig(&c.DNS)
 	SetDefaultWebConfig(&c.Web)
+
+	if !c.Web.Auth.DefaultRole.Valid() {
+		return fmt.Errorf("Unknown default_role for web auth: %s", c.Web.Auth.DefaultRole)
+	}
+
+	for _, binding := range c.Web.Auth.Roles {
+		if err := binding.Validate(); err != nil {
+			return fmt.Errorf("Invalid web auth roles: %s", err)
+		}
+	}
+
+	if c.Web.Auth.SigningKey != "" && len(c.Web.Auth.SigningKey) < 32 {
+		return fmt.Errorf("Invalid web auth: signing_key requires atleast 32 characters")
+	}
+
+	if c.Web.Auth.TokenFile != "" && c.Web.Auth.SigningKey == "" {
+		return fmt.Errorf("Invalid web auth: token_file requires a signing_key, so tokens are valid on all cluster nodes and after a restart")
+	}
+
+	if c.Web.Auth.OIDC != nil {
+		if err := c.Web.Auth.OIDC.Validate(); err != nil {
+			return fmt.Errorf("Invalid web auth: %s", err)
+		}
+	}
+
 	return nil
 }
 
+// SetBackendAuthDefault sets the default values of the authentication gateway of a backend
+func SetBackendAuthDefault(auth proxy.BackendAuth) proxy.BackendAuth {
+	if len(auth.RequestHeaders) == 0 {
+		auth.RequestHeaders = []string{"Cookie", "Authorization"}
+	}
+
+	if auth.Timeout < 1 {
+		auth.Timeout = 5
+	}
+
+	if len(auth.Scopes) == 0 {
+		auth.Scopes = []string{"openid", "profile", "email"}
+	}
+
+	if auth.UsernameClaim == "" {
+		auth.UsernameClaim = "preferred_username"
+	}
+
+	if auth.GroupsClaim == "" {
+		auth.GroupsClaim = "groups"
+	}
+
+	if auth.CookieName == "" {
+		auth.CookieName = "mercauth"
+	}
+
+	if auth.SessionTimeout < 1 {
+		auth.SessionTimeout = 28800
+	}
+
+	if auth.UserHeader == "" {
+		auth.UserHeader = "X-Auth-User"
+	}
+
+	if auth.GroupsHeader == "" {
+		auth.GroupsHeader = "X-Auth-Groups"
+	}
+
+	return auth
+}
+
+// SetCompressionDefault sets the default values of the compression of a pool
+func SetCompressionDefault(compression proxy.CompressionConfig) proxy.CompressionConfig {
+	if len(compression.Encodings) == 0 {
+		compression.Encodings = []string{"br", "gzip", "deflate"}
+	}
+
+	if compression.Level == 0 {
+		compression.Level = -1
+	}
+
+	if len(compression.ContentTypes) == 0 {
+		compression.ContentTypes = []string{"text/*", "application/json", "application/javascript", "application/xml", "application/xhtml+xml", "image/svg+xml"}
+	}
+
+	if compression.MinSize == 0 {
+		compression.MinSize = 1024
+	}
+
+	return compression
+}
+
+// SetTrafficSplitDefault sets the default values of the traffic split of a pool
+func SetTrafficSplitDefault(split proxy.TrafficSplit) proxy.TrafficSplit {
+	if split.Cookie == "" {
+		split.Cookie = "mercsplit"
+	}
+
+	if split.OverrideHeader == "" {
+		split.OverrideHeader = "X-Mercury-Backend"
+	}
+
+	if split.OverrideCookie == "" {
+		split.OverrideCookie = "mercbackend"
+	}
+
+	return split
+}
+
+// SetBackendTimeoutsDefault sets the default timeouts of a backend, based on the mode of its listener
+func SetBackendTimeoutsDefault(timeouts proxy.BackendTimeouts, mode string) proxy.BackendTimeouts {
+	if mode == "tcp" {
+		if timeouts.Connect == 0 {
+			timeouts.Connect = 60
+		}
+
+		return timeouts
+	}
+
+	if timeouts.Connect == 0 {
+		timeouts.Connect = 10
+	}
+
+	if timeouts.Idle == 0 {
+		timeouts.Idle = 10
+	}
+
+	if timeouts.KeepAlive == 0 {
+		timeouts.KeepAlive = 10
+	}
+
+	return timeouts
+}
+
+// SetMirrorDefault sets the default values of the mirror of a backend
+func SetMirrorDefault(mirror proxy.MirrorConfig) proxy.MirrorConfig {
+	if mirror.Backend == "" {
+		return mirror
+	}
+
+	if mirror.SampleRate == 0 {
+		mirror.SampleRate = 1
+	}
+
+	if mirror.MaxBodySize == 0 {
+		mirror.MaxBodySize = 1024 * 1024
+	}
+
+	if mirror.Timeout == 0 {
+		mirror.Timeout = 10
+	}
+
+	if mirror.MaxPending == 0 {
+		mirror.MaxPending = 100
+	}
+
+	return mirror
+}
+
 // SetHealthCheckDefault sets the default config for generic settings
 func SetHealthCheckDefault(check healthcheck.HealthCheck) healthcheck.HealthCheck {
 	if check.Interval < 1 {
@@ -499,6 +779,28 @@ func SetDefaultWebConfig(w *web.Config) {
 		w.Port = 9001
 	}
 
+	// Without roles everyone who can login is admin, as before roles existed
+	if w.Auth.DefaultRole == "" {
+		if len(w.Auth.Roles) == 0 {
+			w.Auth.DefaultRole = web.RoleAdmin
+		} else {
+			w.Auth.DefaultRole = web.RoleViewer
+		}
+	}
+
+	// Set default OIDC settings
+	if w.Auth.OIDC != nil {
+		if len(w.Auth.OIDC.Scopes) == 0 {
+			w.Auth.OIDC.Scopes = []string{"openid", "profile", "email"}
+		}
+		if w.Auth.OIDC.UsernameClaim == "" {
+			w.Auth.OIDC.UsernameClaim = "preferred_username"
+		}
+		if w.Auth.OIDC.GroupsClaim == "" {
+			w.Auth.OIDC.GroupsClaim = "groups"
+		}
+	}
+
 	// Set default LDAP settings
 	if w.Auth.LDAP != nil {
 		if w.Auth.LDAP.Method == "" {
@@ -38,6 +38,11 @@ type LoadbalancePool struct {
 	OutboundRule    []string                  `json:"outboundrules" toml:"outboundrules"`     // script based rules applied on outgoing connections to client
 	ErrorPage       proxy.ErrorPage           `json:"errorpage" toml:"errorpage"`             // alternative error page to show
 	MaintenancePage proxy.ErrorPage           `json:"maintenancepage" toml:"maintenancepage"` // alternative maintenance page to show
+	LimitPage       proxy.ErrorPage           `json:"limitpage" toml:"limitpage"`             // alternative page to show to clients that reached a limit
+	StatusPages     proxy.StatusPages         `json:"status_pages" toml:"status_pages"`       // templates of the pages to show by status code or range
+	WAF             proxy.WAFConfig           `json:"waf" toml:"waf"`                         // web application firewall applied on requests to all backends
+	Compression     proxy.CompressionConfig   `json:"compression" toml:"compression"`         // compression of the replies of all backends
+	TrafficSplit    proxy.TrafficSplit        `json:"traffic_split" toml:"traffic_split"`     // how requests are split over backends serving the same hostnames
 }
 
 // LoadbalancerListener is a listener for the loadbalancer
@@ -56,6 +61,7 @@ type LoadbalancerListener struct {
 	ReadTimeout    int                  `json:"readtimeout" toml:"readtimeout" yaml:"readtimeout"`          // read timeout on client reply to server
 	HTTPProto      int                  `json:"httpproto" toml:"httpproto" yaml:"httpproto"`                // force HTP protocol (1 = http/1.x 2 = http/2)
 	OCSPStapling   string               `json:"ocspstapling" toml:"ocspstapling" yaml:"ocspstapling"`       // Enable/Disable OCSP Stapling
+	HTTP3          bool                 `json:"http3" toml:"http3" yaml:"http3"`                            // also listen for HTTP/3 (QUIC) on the udp port of an https listener
 	//Error          string              `json:"error" toml:"error"` // error??? - not used
 }
 
@@ -65,6 +71,7 @@ type BackendNode struct {
 	Status      healthcheck.Status `json:"status" toml:"status" yaml:"-"`
 	Errors      []string           `json:"error" toml:"error" yaml:"-"`
 	ClusterName string             `json:"clustername" toml:"clustername" yaml:"-"`
+	CheckWeight *int               `json:"checkweight" toml:"-" yaml:"-"` // weight reported by the healthchecks, overrides the configured weight
 }
 
 // DNSEntry for GLB
@@ -96,6 +103,15 @@ type BackendPool struct {
 	Crossconnects   bool                      `json:"crossconnects" toml:"crossconnects"`     // allow cluster cross-connects (e.g. each server can connect to all backends)
 	ErrorPage       proxy.ErrorPage           `json:"errorpage" toml:"errorpage"`             // alternative error page to show
 	MaintenancePage proxy.ErrorPage           `json:"maintenancepage" toml:"maintenancepage"` // alternative maintenance page to show
+	LimitPage       proxy.ErrorPage           `json:"limitpage" toml:"limitpage"`             // alternative page to show to clients that reached a limit
+	StatusPages     proxy.StatusPages         `json:"status_pages" toml:"status_pages"`       // templates of the pages to show by status code or range
+	DrainTimeout    int                       `json:"drain_timeout" toml:"drain_timeout"`     // seconds a draining or removed node keeps its existing connections
+	Auth            proxy.BackendAuth         `json:"auth" toml:"auth"`                       // authentication gateway in front of the backend
+	Routes          []proxy.Route             `json:"routes" toml:"routes"`                   // routes sending requests to this backend by path, method, header, cookie or query
+	SplitWeight     int                       `json:"split_weight" toml:"split_weight"`       // share of the requests for the hostnames of this backend, when other backends serve them too
+	Mirror          proxy.MirrorConfig        `json:"mirror" toml:"mirror"`                   // backend receiving a copy of the requests to this backend
+	Timeouts        proxy.BackendTimeouts     `json:"timeouts" toml:"timeouts"`               // timeouts and connection pooling of the connections to the nodes
+	Websocket       proxy.WebsocketConfig     `json:"websocket" toml:"websocket"`             // limits of the websocket connections to the nodes
 }
 
 // BalanceMode Which type of loadbalancing to use
@@ -152,6 +168,30 @@ func UpdateNodeStatus(poolName string, backendName string, nodeUUID string, stat
 	}
 }
 
+// UpdateNodeWeight updates the weight reported by the healthchecks of a node
+func UpdateNodeWeight(poolName string, backendName string, nodeUUID string, weight *int) {
+	configLock.Lock()
+	defer configLock.Unlock()
+	if _, ok := config.Loadbalancer.Pools[poolName]; ok {
+		if _, ok := config.Loadbalancer.Pools[poolName].Backends[backendName]; ok {
+			for nid, node := range config.Loadbalancer.Pools[poolName].Backends[backendName].Nodes {
+				if node.UUID == nodeUUID {
+					config.Loadbalancer.Pools[poolName].Backends[backendName].Nodes[nid].CheckWeight = weight
+				}
+			}
+		}
+	}
+}
+
+// EffectiveWeight returns the weight of the node as reported by its healthchecks, or the configured weight
+func (n BackendNode) EffectiveWeight() int {
+	if n.CheckWeight != nil {
+		return *n.CheckWeight
+	}
+
+	return n.BackendNode.Weight
+}
+
 // GetNodeByUUID returns the node based on UUID
 func GetNodeByUUID(poolName string, backendName string, nodeUUID string) (BackendNode, error) {
 	configLock.Lock()
@@ -0,0 +1,220 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaintenanceWindow defines a period in which the matching nodes are put in maintenance
+type MaintenanceWindow struct {
+	Name     string    `json:"name" toml:"name"`         // name of the maintenance window
+	Pool     string    `json:"pool" toml:"pool"`         // pool to put in maintenance
+	Backend  string    `json:"backend" toml:"backend"`   // backend to put in maintenance, empty for all backends of the pool
+	Node     string    `json:"node" toml:"node"`         // node name, ip or uuid to put in maintenance, empty for all nodes of the backend
+	Start    time.Time `json:"start" toml:"start"`       // start of the window, or from when a recurring window applies
+	End      time.Time `json:"end" toml:"end"`           // end of the window, or until when a recurring window applies
+	Schedule string    `json:"schedule" toml:"schedule"` // cron like schedule for recurring windows: minute hour day month weekday
+	Duration int       `json:"duration" toml:"duration"` // duration in seconds of a recurring window
+	Source   string    `json:"source" toml:"-"`          // where the window was defined: config or api
+}
+
+// maxMaintenanceDuration is the longest duration allowed for a recurring window
+const maxMaintenanceDuration = 7 * 24 * 60 * 60
+
+// Validate checks if the maintenance window is usable
+func (w MaintenanceWindow) Validate() error {
+	if w.Name == "" {
+		return fmt.Errorf("maintenance window requires a name")
+	}
+
+	if w.Pool == "" {
+		return fmt.Errorf("maintenance window:%s requires a pool", w.Name)
+	}
+
+	if w.Schedule == "" {
+		if w.Start.IsZero() || w.End.IsZero() {
+			return fmt.Errorf("maintenance window:%s requires a start and end time, or a schedule", w.Name)
+		}
+
+		if !w.End.After(w.Start) {
+			return fmt.Errorf("maintenance window:%s ends before it starts", w.Name)
+		}
+
+		return nil
+	}
+
+	if w.Duration <= 0 || w.Duration > maxMaintenanceDuration {
+		return fmt.Errorf("maintenance window:%s requires a duration between 1 and %d seconds with a schedule", w.Name, maxMaintenanceDuration)
+	}
+
+	if _, err := parseSchedule(w.Schedule); err != nil {
+		return fmt.Errorf("maintenance window:%s has an invalid schedule: %s", w.Name, err)
+	}
+
+	return nil
+}
+
+// Active returns true if the maintenance window applies at the given time
+func (w MaintenanceWindow) Active(t time.Time) bool {
+	if !w.Start.IsZero() && t.Before(w.Start) {
+		return false
+	}
+
+	if !w.End.IsZero() && !t.Before(w.End) {
+		return false
+	}
+
+	if w.Schedule == "" {
+		return true
+	}
+
+	schedule, err := parseSchedule(w.Schedule)
+	if err != nil {
+		return false
+	}
+
+	// find a scheduled start within the duration before t
+	duration := time.Duration(w.Duration) * time.Second
+	for s := t.Truncate(time.Minute); t.Sub(s) < duration; s = s.Add(-time.Minute) {
+		if schedule.matches(s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Expired returns true if the maintenance window will no longer apply
+func (w MaintenanceWindow) Expired(t time.Time) bool {
+	return !w.End.IsZero() && !t.Before(w.End)
+}
+
+// Matches returns true if the maintenance window applies to the node
+func (w MaintenanceWindow) Matches(poolName, backendName string, node BackendNode) bool {
+	if w.Pool != poolName {
+		return false
+	}
+
+	if w.Backend != "" && w.Backend != backendName {
+		return false
+	}
+
+	if w.Node != "" && w.Node != node.Name() && w.Node != node.IP && w.Node != node.Hostname && w.Node != node.UUID {
+		return false
+	}
+
+	return true
+}
+
+// schedule contains the parsed fields of a cron like schedule
+type schedule struct {
+	minute  map[int]bool
+	hour    map[int]bool
+	day     map[int]bool
+	month   map[int]bool
+	weekday map[int]bool
+	anyDay  bool
+	anyWeek bool
+}
+
+// parseSchedule parses a cron like schedule in the format: minute hour day month weekday
+func parseSchedule(s string) (*schedule, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields (minute hour day month weekday), got %d", len(fields))
+	}
+
+	limits := [][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	parsed := make([]map[int]bool, 5)
+	for i, field := range fields {
+		values, err := parseScheduleField(field, limits[i][0], limits[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %s", field, err)
+		}
+		parsed[i] = values
+	}
+
+	// both 0 and 7 are sunday
+	if parsed[4][7] {
+		parsed[4][0] = true
+	}
+
+	return &schedule{
+		minute:  parsed[0],
+		hour:    parsed[1],
+		day:     parsed[2],
+		month:   parsed[3],
+		weekday: parsed[4],
+		anyDay:  strings.HasPrefix(fields[2], "*"),
+		anyWeek: strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+// parseScheduleField parses a single cron field supporting *, lists, ranges and steps
+func parseScheduleField(field string, min, max int) (map[int]bool, error) {
+	values := make(map[int]bool)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step: %s", part[i+1:])
+			}
+			part = part[:i]
+		}
+
+		start, end := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			r := strings.SplitN(part, "-", 2)
+			var err error
+			if start, err = strconv.Atoi(r[0]); err != nil {
+				return nil, fmt.Errorf("invalid range: %s", part)
+			}
+			if end, err = strconv.Atoi(r[1]); err != nil {
+				return nil, fmt.Errorf("invalid range: %s", part)
+			}
+		default:
+			var err error
+			if start, err = strconv.Atoi(part); err != nil {
+				return nil, fmt.Errorf("invalid value: %s", part)
+			}
+			end = start
+		}
+
+		if start < min || end > max || start > end {
+			return nil, fmt.Errorf("value out of range %d-%d: %s", min, max, part)
+		}
+
+		for v := start; v <= end; v += step {
+			values[v] = true
+		}
+	}
+
+	return values, nil
+}
+
+// matches returns true if the schedule matches the given time
+func (s *schedule) matches(t time.Time) bool {
+	if !s.minute[t.Minute()] || !s.hour[t.Hour()] || !s.month[int(t.Month())] {
+		return false
+	}
+
+	day := s.day[t.Day()]
+	weekday := s.weekday[int(t.Weekday())]
+	// like cron, if both day and weekday are restricted either may match
+	switch {
+	case s.anyDay && s.anyWeek:
+		return true
+	case s.anyDay:
+		return weekday
+	case s.anyWeek:
+		return day
+	}
+
+	return day || weekday
+}
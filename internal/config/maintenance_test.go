@@ -0,0 +1,86 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/schubergphilis/mercury/pkg/healthcheck"
+	"github.com/schubergphilis/mercury/pkg/proxy"
+)
+
+func TestMaintenanceWindow(t *testing.T) {
+	start, _ := time.Parse(time.RFC3339, "2018-01-01T10:00:00Z")
+	end, _ := time.Parse(time.RFC3339, "2018-01-01T12:00:00Z")
+	once := MaintenanceWindow{Name: "once", Pool: "pool", Start: start, End: end}
+	if err := once.Validate(); err != nil {
+		t.Errorf("Expected window to be valid (got:%s)", err)
+	}
+
+	checks := map[string]bool{
+		"2018-01-01T09:59:59Z": false,
+		"2018-01-01T10:00:00Z": true,
+		"2018-01-01T11:59:59Z": true,
+		"2018-01-01T12:00:00Z": false,
+	}
+	for in, out := range checks {
+		tm, _ := time.Parse(time.RFC3339, in)
+		if once.Active(tm) != out {
+			t.Errorf("Expected window active at %s to be %t", in, out)
+		}
+	}
+
+	// every monday at 02:30 for 1 hour
+	recurring := MaintenanceWindow{Name: "recurring", Pool: "pool", Schedule: "30 2 * * 1", Duration: 3600}
+	if err := recurring.Validate(); err != nil {
+		t.Errorf("Expected window to be valid (got:%s)", err)
+	}
+
+	checks = map[string]bool{
+		"2018-01-01T02:29:00Z": false,
+		"2018-01-01T02:30:00Z": true,
+		"2018-01-01T03:29:59Z": true,
+		"2018-01-01T03:30:00Z": false,
+		"2018-01-02T02:45:00Z": false,
+		"2018-01-08T02:45:00Z": true,
+	}
+	for in, out := range checks {
+		tm, _ := time.Parse(time.RFC3339, in)
+		if recurring.Active(tm) != out {
+			t.Errorf("Expected recurring window active at %s to be %t", in, out)
+		}
+	}
+
+	invalid := []MaintenanceWindow{
+		{Name: "nopool", Start: start, End: end},
+		{Name: "reversed", Pool: "pool", Start: end, End: start},
+		{Name: "noduration", Pool: "pool", Schedule: "* * * * *"},
+		{Name: "badschedule", Pool: "pool", Schedule: "61 * * * *", Duration: 60},
+		{Name: "shortschedule", Pool: "pool", Schedule: "* * *", Duration: 60},
+	}
+	for _, window := range invalid {
+		if err := window.Validate(); err == nil {
+			t.Errorf("Expected window:%s to be invalid", window.Name)
+		}
+	}
+}
+
+func TestMaintenanceWindowMatches(t *testing.T) {
+	node := BackendNode{
+		BackendNode: proxy.NewBackendNode("UUID1", "192.168.1.1", "server1", 80, 10, []string{}, 0, 0, healthcheck.Online),
+	}
+
+	checks := map[MaintenanceWindow]bool{
+		{Pool: "pool"}:                                    true,
+		{Pool: "other"}:                                   false,
+		{Pool: "pool", Backend: "backend"}:                true,
+		{Pool: "pool", Backend: "other"}:                  false,
+		{Pool: "pool", Backend: "backend", Node: "UUID1"}: true,
+		{Pool: "pool", Node: "192.168.1.1"}:               true,
+		{Pool: "pool", Node: "192.168.1.2"}:               false,
+	}
+	for window, out := range checks {
+		if window.Matches("pool", "backend", node) != out {
+			t.Errorf("Expected window %+v to match:%t", window, out)
+		}
+	}
+}
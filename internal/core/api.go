@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/schubergphilis/mercury/internal/config"
+	"github.com/schubergphilis/mercury/internal/web"
 )
 
 var (
@@ -17,6 +18,10 @@ var (
 	APITokenSigningKey = rndKey()
 	// APITokenDuration is how long the jwt token is valid
 	APITokenDuration = 1 * time.Hour
+	// apiRandomSigningKey is used to sign jwt tokens if no signing key is configured
+	apiRandomSigningKey = APITokenSigningKey
+	// apiTokens are the long lived api tokens for automation
+	apiTokens, _ = web.NewTokenStore("")
 )
 
 type apiMessage struct {
@@ -78,7 +83,9 @@ func (m *Manager) setupAPI() {
 	titleHead := fmt.Sprintf("Mercury %s - ", config.Get().Cluster.Binding.Name)
 
 	// HealthChecks are always used
-	http.Handle("/api/v1/healthchecks/admin/", authenticate(apiHealthCheckAdminHandler{manager: m}, string(APITokenSigningKey)))
+	http.Handle("/api/v1/healthchecks/admin/", authenticate(apiHealthCheckAdminHandler{manager: m}, string(APITokenSigningKey), web.RoleOperator))
+	http.Handle("/api/v1/healthchecks/history", apiHealthCheckHistoryHandler{manager: m})
+	http.Handle("/api/v1/healthchecks/history/", apiHealthCheckHistoryHandler{manager: m})
 	http.Handle("/api/v1/healthchecks/", apiHealthCheckPublicHandler{manager: m})
 	http.Handle("/healthchecks/", webHealthCheckHandler{
 		title:         titleHead + "Checks",
@@ -86,6 +93,21 @@ func (m *Manager) setupAPI() {
 		template:      "healthchecks",
 	})
 
+	// Maintenance windows
+	http.Handle("/api/v1/maintenance/", authenticate(apiMaintenanceHandler{manager: m}, string(APITokenSigningKey), web.RoleOperator))
+	http.Handle("/api/v1/split/", authenticate(apiSplitHandler{manager: m}, string(APITokenSigningKey), web.RoleOperator))
+
+	// API tokens
+	http.Handle("/api/v1/tokens/", authenticate(apiTokenHandler{manager: m}, string(APITokenSigningKey), web.RoleAdmin))
+
+	// Audit log
+	http.Handle("/api/v1/audit/", authenticate(apiAuditHandler{manager: m}, string(APITokenSigningKey), web.RoleAdmin))
+	http.Handle("/audit/", webHealthCheckHandler{
+		title:         titleHead + "Audit",
+		templateFiles: []string{"header.tmpl", "footer.tmpl", "audit.tmpl"},
+		template:      "audit",
+	})
+
 	// Enable login
 	http.Handle("/api/v1/login/", apiLoginHandler{manager: m})
 	http.Handle("/login/", webLoginHandler{
@@ -5,6 +5,8 @@ import (
 	"time"
 
 	jwt "github.com/golang-jwt/jwt"
+	"github.com/schubergphilis/mercury/internal/config"
+	"github.com/schubergphilis/mercury/internal/web"
 )
 
 // apiLoginHandler handles a login of a user, and gives back a cookie if successfull
@@ -23,7 +25,15 @@ func (h apiLoginHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	valid, err := h.manager.webAuthenticator.VerifyLogin(r.FormValue("username"), r.FormValue("password"))
+	// get the groups of the user if the authenticator supports it, used for role mapping
+	var groups []string
+	valid := true
+	var err error
+	if groupAuth, ok := h.manager.webAuthenticator.(web.GroupAuth); ok {
+		groups, err = groupAuth.VerifyLoginGroups(r.FormValue("username"), r.FormValue("password"))
+	} else {
+		valid, err = h.manager.webAuthenticator.VerifyLogin(r.FormValue("username"), r.FormValue("password"))
+	}
 	if err != nil {
 		apiWriteData(w, 501, apiMessage{Success: false, Error: err.Error()})
 		return
@@ -33,8 +43,14 @@ func (h apiLoginHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	grants := config.Get().Web.Auth.Grants(r.FormValue("username"), groups)
+	if !grants.AllowedAny(web.RoleViewer) {
+		apiWriteData(w, 403, apiMessage{Success: false, Error: "no role assigned to user"})
+		return
+	}
+
 	expiration := time.Now().Add(APITokenDuration)
-	apiKey, err := apiMakeKey(r.FormValue("username"), string(APITokenSigningKey), expiration.Unix())
+	apiKey, err := apiMakeKey(r.FormValue("username"), string(APITokenSigningKey), grants, expiration.Unix())
 	if err != nil {
 		apiWriteData(w, 501, apiMessage{Success: false, Error: err.Error()})
 		return
@@ -44,9 +60,10 @@ func (h apiLoginHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func apiMakeKey(username, key string, expire int64) (string, error) {
+func apiMakeKey(username, key string, grants web.Grants, expire int64) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.MapClaims{
 		"username": username,
+		"roles":    grants,
 		"expire":   expire,
 	})
 
@@ -1,20 +1,28 @@
 package core
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
 	"time"
 
 	jwt "github.com/golang-jwt/jwt"
+	"github.com/schubergphilis/mercury/internal/web"
 )
 
 // Authentication middleware, we expect a token and verify this
+// reading requires the viewer role, all other requests require the role of the middleware
 type apiAuthentication struct {
 	wrappedHandler http.Handler
 	authKey        string
+	role           web.Role
 }
 
+type apiGrantsContextKey struct{}
+type apiUsernameContextKey struct{}
+
 func (h apiAuthentication) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	auth := r.Header.Get("Authorization")
 	if auth != "" {
@@ -48,12 +56,46 @@ func (h apiAuthentication) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 
 		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-			if time.Now().Unix() > int64(claims["expire"].(float64)) {
-				apiWriteData(w, 403, apiMessage{Success: false, Error: "Login token expired"})
+			username := fmt.Sprintf("%s", claims["username"])
+			var grants web.Grants
+			if id, ok := claims["token"].(string); ok {
+				// api tokens are validated against the token store, so they can be revoked
+				apiToken, found := apiTokens.Get(id)
+				if !found {
+					apiWriteData(w, 403, apiMessage{Success: false, Error: "Unknown api token"})
+					return
+				}
+
+				if err := apiToken.Active(time.Now()); err != nil {
+					apiWriteData(w, 403, apiMessage{Success: false, Error: err.Error()})
+					return
+				}
+
+				username = "token:" + apiToken.Name
+				grants = apiToken.Grants
+			} else {
+				expire, _ := claims["expire"].(float64)
+				if time.Now().Unix() > int64(expire) {
+					apiWriteData(w, 403, apiMessage{Success: false, Error: "Login token expired"})
+					return
+				}
+
+				grants = claimGrants(claims)
+			}
+
+			required := h.role
+			if r.Method == "GET" || r.Method == "HEAD" {
+				required = web.RoleViewer
+			}
+
+			if !grants.AllowedAny(required) {
+				apiWriteData(w, 403, apiMessage{Success: false, Error: fmt.Sprintf("Permission denied, role %s required", required)})
 				return
 			}
 
-			h.wrappedHandler.ServeHTTP(w, r)
+			ctx := context.WithValue(r.Context(), apiGrantsContextKey{}, grants)
+			ctx = context.WithValue(ctx, apiUsernameContextKey{}, username)
+			h.wrappedHandler.ServeHTTP(w, r.WithContext(ctx))
 			return
 		}
 		apiWriteData(w, 403, apiMessage{Success: false, Error: err.Error()})
@@ -62,9 +104,46 @@ func (h apiAuthentication) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	apiWriteData(w, 403, apiMessage{Success: false, Error: "Login required"})
 }
 
-// Authenticate user
-func authenticate(h http.Handler, authKey string) apiAuthentication {
-	return apiAuthentication{h, authKey}
+// Authenticate user, and verify the user has the role required for changes
+func authenticate(h http.Handler, authKey string, role web.Role) apiAuthentication {
+	return apiAuthentication{h, authKey, role}
+}
+
+// claimGrants returns the roles stored in the claims of a token
+func claimGrants(claims jwt.MapClaims) (grants web.Grants) {
+	data, err := json.Marshal(claims["roles"])
+	if err != nil {
+		return nil
+	}
+
+	json.Unmarshal(data, &grants)
+	return
+}
+
+// apiAllowed returns true if the authenticated user of the request has role on the pool and backend
+func apiAllowed(r *http.Request, role web.Role, pool, backend string) bool {
+	grants, ok := r.Context().Value(apiGrantsContextKey{}).(web.Grants)
+	if !ok {
+		return false
+	}
+
+	return grants.Allowed(role, pool, backend)
+}
+
+// apiAllowedAny returns true if the authenticated user of the request has role, regardless of the pools and backends it applies to
+func apiAllowedAny(r *http.Request, role web.Role) bool {
+	grants, ok := r.Context().Value(apiGrantsContextKey{}).(web.Grants)
+	if !ok {
+		return false
+	}
+
+	return grants.AllowedAny(role)
+}
+
+// apiUsername returns the authenticated user of the request
+func apiUsername(r *http.Request) string {
+	username, _ := r.Context().Value(apiUsernameContextKey{}).(string)
+	return username
 }
 
 // authenticateUser returns authenticationStatus, username and error
@@ -84,7 +163,8 @@ func authenticateUser(r *http.Request) (bool, string, error) {
 		}
 
 		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-			if time.Now().Unix() > int64(claims["expire"].(float64)) {
+			expire, _ := claims["expire"].(float64)
+			if time.Now().Unix() > int64(expire) {
 				return false, "", fmt.Errorf("token expired")
 			}
 			return true, fmt.Sprintf("%s", claims["username"]), nil
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/schubergphilis/mercury/internal/web"
 	"github.com/schubergphilis/mercury/pkg/healthcheck"
 )
 
@@ -33,8 +34,13 @@ func (h apiHealthCheckAdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Req
 	switch r.Method {
 	case "GET":
 		path := strings.Split(r.RequestURI, "/")
-		if len(path) < 5 {
+		if len(path) < 6 {
 			apiWriteData(w, 405, apiMessage{Success: false, Error: "invalid request"})
+			return
+		}
+
+		if !h.authorize(w, r, web.RoleViewer, path[5]) {
+			return
 		}
 
 		data, err := h.manager.healthManager.JSONAuthorized(path[5])
@@ -54,21 +60,68 @@ func (h apiHealthCheckAdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Req
 			return
 		}
 
+		if !h.authorize(w, r, web.RoleOperator, path[5]) {
+			return
+		}
+
 		switch path[6] {
 		case "status":
 			if _, ok := healthcheck.StringToStatusType[path[7]]; !ok {
 				apiWriteData(w, 501, apiMessage{Success: false, Error: fmt.Sprintf("unknown status: %s", path[7])})
 				return
 			}
+			before, _ := h.manager.healthManager.GetStatus(path[5])
 			err := h.manager.healthManager.SetStatus(path[5], healthcheck.StringToStatusType[path[7]])
+			h.manager.auditRequest(r, "healthcheck.status", path[5], before.String(), path[7], err)
 			if err != nil {
 				apiWriteData(w, 501, apiMessage{Success: false, Error: err.Error()})
 				return
 			}
 			apiWriteData(w, 200, apiMessage{Success: true})
+			return
 		}
 		apiWriteData(w, 405, apiMessage{Success: false, Error: fmt.Sprintf("unknown action: %s", path[6])})
 
 	}
 
 }
+
+// authorize returns true if the user has role on the pool and backend of the worker
+// otherwise it replies with an error, workers of which the pool and backend are unknown are never allowed
+func (h apiHealthCheckAdminHandler) authorize(w http.ResponseWriter, r *http.Request, role web.Role, uuid string) bool {
+	pool, backend, err := h.manager.healthManager.WorkerScope(uuid)
+	if err != nil {
+		apiWriteData(w, 404, apiMessage{Success: false, Error: err.Error()})
+		return false
+	}
+
+	if !apiAllowed(r, role, pool, backend) {
+		apiWriteData(w, 403, apiMessage{Success: false, Error: "Permission denied for this pool or backend"})
+		return false
+	}
+
+	return true
+}
+
+// History API
+type apiHealthCheckHistoryHandler struct {
+	manager *Manager
+}
+
+// History API returns the state transitions of the workers and nodes, optionally filtered on uuid
+func (h apiHealthCheckHistoryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	//                             1   2  3            4       5
+	// expect a url in the format: api v1 healthchecks history [UUID]
+	path := strings.Split(r.URL.Path, "/")
+	uuid := ""
+	if len(path) > 5 {
+		uuid = path[5]
+	}
+
+	data, err := h.manager.healthManager.HistoryJSON(uuid)
+	if err != nil {
+		apiWriteData(w, 501, apiMessage{Success: false, Error: err.Error()})
+		return
+	}
+	apiWriteJSONData(w, http.StatusOK, apiMessage{Success: true, Data: string(data)})
+}
@@ -0,0 +1,31 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/schubergphilis/mercury/internal/web"
+	"github.com/schubergphilis/mercury/pkg/healthcheck"
+)
+
+func TestAPIHealthCheckAdminUnknownWorker(t *testing.T) {
+	manager := NewManager()
+	manager.healthManager = healthcheck.NewManager()
+	manager.healthManager.HealthStatusMap["removed-worker"] = healthcheck.HealthStatus{ManualStatus: healthcheck.Automatic}
+	h := apiHealthCheckAdminHandler{manager: manager}
+
+	// a worker that is no longer running has no pool or backend to check the grants against
+	r := httptest.NewRequest("POST", "/api/v1/healthchecks/admin/removed-worker/status/offline", nil)
+	r = r.WithContext(context.WithValue(r.Context(), apiGrantsContextKey{}, web.Grants{{Role: web.RoleOperator, Pools: []string{"pool1"}}}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status change of an unknown worker to be refused, got %d", w.Code)
+	}
+
+	if status, _ := manager.healthManager.GetStatus("removed-worker"); status != healthcheck.Automatic {
+		t.Errorf("Expected status of the unknown worker to stay %s, got %s", healthcheck.Automatic, status)
+	}
+}
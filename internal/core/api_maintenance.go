@@ -0,0 +1,110 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/schubergphilis/mercury/internal/config"
+	"github.com/schubergphilis/mercury/internal/web"
+)
+
+// Authorized personel only
+type apiMaintenanceHandler struct {
+	manager *Manager
+}
+
+// apiMaintenanceWindow is a maintenance window with its current state
+type apiMaintenanceWindow struct {
+	config.MaintenanceWindow
+	Active bool `json:"active"`
+}
+
+// Maintenance API lists, adds or removes maintenance windows
+func (h apiMaintenanceHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		now := time.Now()
+		var windows []apiMaintenanceWindow
+		for _, window := range h.manager.MaintenanceWindows() {
+			if !apiAllowed(r, web.RoleViewer, window.Pool, window.Backend) {
+				continue
+			}
+			windows = append(windows, apiMaintenanceWindow{MaintenanceWindow: window, Active: window.Active(now)})
+		}
+
+		data, err := json.Marshal(windows)
+		if err != nil {
+			apiWriteData(w, 501, apiMessage{Success: false, Error: err.Error()})
+			return
+		}
+		apiWriteJSONData(w, http.StatusOK, apiMessage{Success: true, Data: string(data)})
+
+	case "POST":
+		// expect a maintenance window in json format as body
+		window := config.MaintenanceWindow{}
+		if err := json.NewDecoder(r.Body).Decode(&window); err != nil {
+			apiWriteData(w, 400, apiMessage{Success: false, Error: fmt.Sprintf("invalid maintenance window: %s", err)})
+			return
+		}
+
+		if !apiAllowed(r, web.RoleOperator, window.Pool, window.Backend) {
+			apiWriteData(w, 403, apiMessage{Success: false, Error: "Permission denied for this pool or backend"})
+			return
+		}
+
+		before := ""
+		for _, existing := range h.manager.MaintenanceWindows() {
+			if existing.Name == window.Name {
+				before = auditState(existing)
+			}
+		}
+
+		err := h.manager.AddMaintenanceWindow(window)
+		h.manager.auditRequest(r, "maintenance.add", window.Name, before, auditState(window), err)
+		if err != nil {
+			apiWriteData(w, 400, apiMessage{Success: false, Error: err.Error()})
+			return
+		}
+
+		h.manager.maintenanceUpdates <- &config.ClusterPacketMaintenanceWindowUpdate{Window: window}
+		apiWriteData(w, 200, apiMessage{Success: true})
+
+	case "DELETE":
+		//                             1   2  3           4
+		// expect a url in the format: api v1 maintenance NAME
+		path := strings.Split(r.URL.Path, "/")
+		if len(path) < 5 || path[4] == "" {
+			apiWriteData(w, 405, apiMessage{Success: false, Error: "invalid request"})
+			return
+		}
+
+		before := ""
+		for _, window := range h.manager.MaintenanceWindows() {
+			if window.Name != path[4] {
+				continue
+			}
+
+			if !apiAllowed(r, web.RoleOperator, window.Pool, window.Backend) {
+				apiWriteData(w, 403, apiMessage{Success: false, Error: "Permission denied for this pool or backend"})
+				return
+			}
+			before = auditState(window)
+		}
+
+		err := h.manager.RemoveMaintenanceWindow(path[4])
+		h.manager.auditRequest(r, "maintenance.remove", path[4], before, "", err)
+		if err != nil {
+			apiWriteData(w, 404, apiMessage{Success: false, Error: err.Error()})
+			return
+		}
+
+		h.manager.maintenanceUpdates <- &config.ClusterPacketMaintenanceWindowUpdate{Window: config.MaintenanceWindow{Name: path[4]}, Remove: true}
+		apiWriteData(w, 200, apiMessage{Success: true})
+
+	default:
+		apiWriteData(w, 405, apiMessage{Success: false, Error: fmt.Sprintf("unsupported method: %s", r.Method)})
+	}
+}
@@ -0,0 +1,97 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/schubergphilis/mercury/internal/config"
+	"github.com/schubergphilis/mercury/internal/web"
+)
+
+// Authorized personel only
+type apiSplitHandler struct {
+	manager *Manager
+}
+
+// apiSplitWeight is the body of a split weight change
+type apiSplitWeight struct {
+	Weight int `json:"weight"`
+}
+
+// Split API lists, changes or resets the split weights of backends
+func (h apiSplitHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		var weights []SplitWeight
+		for _, weight := range h.manager.SplitWeights() {
+			if apiAllowed(r, web.RoleViewer, weight.PoolName, weight.BackendName) {
+				weights = append(weights, weight)
+			}
+		}
+
+		data, err := json.Marshal(weights)
+		if err != nil {
+			apiWriteData(w, 501, apiMessage{Success: false, Error: err.Error()})
+			return
+		}
+		apiWriteJSONData(w, http.StatusOK, apiMessage{Success: true, Data: string(data)})
+		return
+	}
+
+	//                             1   2  3     4    5
+	// expect a url in the format: api v1 split POOL BACKEND
+	path := strings.Split(r.URL.Path, "/")
+	if len(path) < 6 || path[4] == "" || path[5] == "" {
+		apiWriteData(w, 405, apiMessage{Success: false, Error: "invalid request"})
+		return
+	}
+	poolname, backendname := path[4], path[5]
+	target := splitWeightKey(poolname, backendname)
+
+	if !apiAllowed(r, web.RoleOperator, poolname, backendname) {
+		apiWriteData(w, 403, apiMessage{Success: false, Error: "Permission denied for this pool or backend"})
+		return
+	}
+
+	before := ""
+	for _, weight := range h.manager.SplitWeights() {
+		if weight.PoolName == poolname && weight.BackendName == backendname {
+			before = auditState(weight)
+		}
+	}
+
+	switch r.Method {
+	case "POST", "PUT":
+		// expect a weight in json format as body
+		weight := apiSplitWeight{}
+		if err := json.NewDecoder(r.Body).Decode(&weight); err != nil {
+			apiWriteData(w, 400, apiMessage{Success: false, Error: fmt.Sprintf("invalid split weight: %s", err)})
+			return
+		}
+
+		err := h.manager.SetSplitWeight(poolname, backendname, weight.Weight)
+		h.manager.auditRequest(r, "split.set", target, before, auditState(SplitWeight{PoolName: poolname, BackendName: backendname, Weight: weight.Weight, Source: "api"}), err)
+		if err != nil {
+			apiWriteData(w, 400, apiMessage{Success: false, Error: err.Error()})
+			return
+		}
+
+		h.manager.splitWeightUpdates <- &config.ClusterPacketSplitWeightUpdate{PoolName: poolname, BackendName: backendname, Weight: weight.Weight}
+		apiWriteData(w, 200, apiMessage{Success: true})
+
+	case "DELETE":
+		err := h.manager.ResetSplitWeight(poolname, backendname)
+		h.manager.auditRequest(r, "split.reset", target, before, "", err)
+		if err != nil {
+			apiWriteData(w, 404, apiMessage{Success: false, Error: err.Error()})
+			return
+		}
+
+		h.manager.splitWeightUpdates <- &config.ClusterPacketSplitWeightUpdate{PoolName: poolname, BackendName: backendname, Remove: true}
+		apiWriteData(w, 200, apiMessage{Success: true})
+
+	default:
+		apiWriteData(w, 405, apiMessage{Success: false, Error: fmt.Sprintf("unsupported method: %s", r.Method)})
+	}
+}
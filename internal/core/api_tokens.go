@@ -0,0 +1,193 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt"
+	uuid "github.com/nu7hatch/gouuid"
+
+	"github.com/schubergphilis/mercury/internal/config"
+	"github.com/schubergphilis/mercury/internal/web"
+	"github.com/schubergphilis/mercury/pkg/cluster"
+	"github.com/schubergphilis/mercury/pkg/logging"
+)
+
+// InitializeAPITokens sets the signing key of tokens, and loads the api tokens if their file changed
+func (manager *Manager) InitializeAPITokens() {
+	log := logging.For("core/apitokens/init")
+	APITokenSigningKey = apiRandomSigningKey
+	if config.Get().Web.Auth.SigningKey != "" {
+		APITokenSigningKey = []byte(config.Get().Web.Auth.SigningKey)
+	}
+
+	file := config.Get().Web.Auth.TokenFile
+	if manager.apiTokenFile == file {
+		return
+	}
+
+	tokens, err := web.NewTokenStore(file)
+	if err != nil {
+		log.WithError(err).WithField("file", file).Warn("Unable to load api tokens")
+		return
+	}
+
+	apiTokens = tokens
+	manager.apiTokenFile = file
+}
+
+// apiTokenRequest is the request to create an api token
+type apiTokenRequest struct {
+	Name     string     `json:"name"`     // name of the token
+	Roles    web.Grants `json:"roles"`    // roles of the token
+	Expire   time.Time  `json:"expire"`   // time the token expires
+	Duration int        `json:"duration"` // seconds until the token expires, if no expire time is given
+}
+
+// apiTokenReply is the reply on creating an api token, the only time the token itself is shown
+type apiTokenReply struct {
+	web.APIToken
+	Token string `json:"token"`
+}
+
+// Authorized personel only
+type apiTokenHandler struct {
+	manager *Manager
+}
+
+// API tokens lists, creates or revokes api tokens
+// admins can only see and manage tokens with roles they have themselves, on the same or fewer pools and backends
+func (h apiTokenHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !apiAllowedAny(r, web.RoleAdmin) {
+		apiWriteData(w, 403, apiMessage{Success: false, Error: fmt.Sprintf("Permission denied, role %s required", web.RoleAdmin)})
+		return
+	}
+
+	grants, _ := r.Context().Value(apiGrantsContextKey{}).(web.Grants)
+	switch r.Method {
+	case "GET":
+		tokens := []web.APIToken{}
+		for _, token := range apiTokens.Tokens() {
+			if grants.Covers(token.Grants) {
+				tokens = append(tokens, token)
+			}
+		}
+
+		data, err := json.Marshal(tokens)
+		if err != nil {
+			apiWriteData(w, 501, apiMessage{Success: false, Error: err.Error()})
+			return
+		}
+		apiWriteJSONData(w, http.StatusOK, apiMessage{Success: true, Data: string(data)})
+
+	case "POST":
+		// expect a token request in json format as body
+		request := apiTokenRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			apiWriteData(w, 400, apiMessage{Success: false, Error: fmt.Sprintf("invalid token request: %s", err)})
+			return
+		}
+
+		if !grants.Covers(request.Roles) {
+			apiWriteData(w, 403, apiMessage{Success: false, Error: "Permission denied, a token can not have roles you do not have yourself"})
+			return
+		}
+
+		if config.Get().Web.Auth.SigningKey == "" || config.Get().Web.Auth.TokenFile == "" {
+			apiWriteData(w, 501, apiMessage{Success: false, Error: "api tokens require a signing_key and token_file in the web auth config"})
+			return
+		}
+
+		id, err := uuid.NewV4()
+		if err != nil {
+			apiWriteData(w, 501, apiMessage{Success: false, Error: err.Error()})
+			return
+		}
+
+		token := web.APIToken{
+			ID:        id.String(),
+			Name:      request.Name,
+			Grants:    request.Roles,
+			CreatedBy: apiUsername(r),
+			Created:   time.Now(),
+			Expire:    request.Expire,
+		}
+		if token.Expire.IsZero() && request.Duration > 0 {
+			token.Expire = token.Created.Add(time.Duration(request.Duration) * time.Second)
+		}
+
+		err = apiTokens.Add(token)
+		h.manager.auditRequest(r, "token.create", token.Name, "", auditState(token), err)
+		if err != nil {
+			apiWriteData(w, 400, apiMessage{Success: false, Error: err.Error()})
+			return
+		}
+		h.manager.apiTokenUpdates <- &config.ClusterPacketAPITokenUpdate{Token: token}
+
+		signed, err := apiMakeToken(token)
+		if err != nil {
+			apiWriteData(w, 501, apiMessage{Success: false, Error: err.Error()})
+			return
+		}
+
+		data, err := json.Marshal(apiTokenReply{APIToken: token, Token: signed})
+		if err != nil {
+			apiWriteData(w, 501, apiMessage{Success: false, Error: err.Error()})
+			return
+		}
+		apiWriteJSONData(w, http.StatusOK, apiMessage{Success: true, Data: string(data)})
+
+	case "DELETE":
+		//                             1   2  3      4
+		// expect a url in the format: api v1 tokens NAME
+		path := strings.Split(r.URL.Path, "/")
+		if len(path) < 5 || path[4] == "" {
+			apiWriteData(w, 405, apiMessage{Success: false, Error: "invalid request"})
+			return
+		}
+
+		// tokens outside the scope of the user are not revealed
+		if token, ok := apiTokens.Find(path[4]); !ok || !grants.Covers(token.Grants) {
+			apiWriteData(w, 404, apiMessage{Success: false, Error: fmt.Sprintf("unknown token: %s", path[4])})
+			return
+		}
+
+		token, err := apiTokens.Revoke(path[4])
+		h.manager.auditRequest(r, "token.revoke", path[4], "active", "revoked", err)
+		if err != nil {
+			apiWriteData(w, 404, apiMessage{Success: false, Error: err.Error()})
+			return
+		}
+
+		h.manager.apiTokenUpdates <- &config.ClusterPacketAPITokenUpdate{Token: token}
+		apiWriteData(w, 200, apiMessage{Success: true})
+
+	default:
+		apiWriteData(w, 405, apiMessage{Success: false, Error: fmt.Sprintf("unsupported method: %s", r.Method)})
+	}
+}
+
+// apiMakeToken returns the signed jwt of an api token, its roles and expiry are kept in the token store
+func apiMakeToken(token web.APIToken) (string, error) {
+	jwtToken := jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.MapClaims{
+		"username": "token:" + token.Name,
+		"token":    token.ID,
+	})
+
+	return jwtToken.SignedString(APITokenSigningKey)
+}
+
+// clusterAPITokenBroadcast sends an api token update to all cluster nodes
+func clusterAPITokenBroadcast(cl *cluster.Manager, update *config.ClusterPacketAPITokenUpdate) {
+	cl.ToCluster <- update
+}
+
+// clusterAPITokensToNode sends all api tokens to a cluster node
+func clusterAPITokensToNode(cl *cluster.Manager, node string) {
+	for _, token := range apiTokens.Tokens() {
+		cl.ToNode <- cluster.NodeMessage{Node: node, Message: &config.ClusterPacketAPITokenUpdate{Token: token}}
+	}
+}
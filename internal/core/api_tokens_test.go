@@ -0,0 +1,69 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/schubergphilis/mercury/internal/web"
+)
+
+func TestAPITokenHandlerScope(t *testing.T) {
+	apiTokens, _ = web.NewTokenStore("")
+	for _, token := range []web.APIToken{
+		{ID: "1", Name: "global", Grants: web.Grants{{Role: web.RoleAdmin}}, Created: time.Now()},
+		{ID: "2", Name: "pool1", Grants: web.Grants{{Role: web.RoleOperator, Pools: []string{"pool1"}}}, Created: time.Now()},
+	} {
+		if err := apiTokens.Add(token); err != nil {
+			t.Fatalf("Failed to add token %s: %s", token.Name, err)
+		}
+	}
+
+	h := apiTokenHandler{manager: NewManager()}
+	scoped := web.Grants{{Role: web.RoleAdmin, Pools: []string{"pool1"}}}
+	request := func(method, url, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, url, strings.NewReader(body))
+		r = r.WithContext(context.WithValue(r.Context(), apiGrantsContextKey{}, scoped))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	// an admin of a single pool can not create a token for all pools
+	if w := request("POST", "/api/v1/tokens", `{"name":"escalate","roles":[{"role":"admin"}]}`); w.Code != http.StatusForbidden {
+		t.Errorf("Expected a token with roles outside the scope of the user to be refused, got %d", w.Code)
+	}
+
+	if w := request("POST", "/api/v1/tokens", `{"name":"escalate","roles":[{"role":"admin","pools":["pool1","pool2"]}]}`); w.Code != http.StatusForbidden {
+		t.Errorf("Expected a token for other pools to be refused, got %d", w.Code)
+	}
+
+	// only tokens within the scope of the user are listed
+	w := request("GET", "/api/v1/tokens", "")
+	var reply struct {
+		Data string `json:"data"`
+	}
+	var tokens []web.APIToken
+	if err := json.Unmarshal(w.Body.Bytes(), &reply); err != nil {
+		t.Fatalf("Invalid reply %s: %s", w.Body.String(), err)
+	}
+	if err := json.Unmarshal([]byte(reply.Data), &tokens); err != nil {
+		t.Fatalf("Invalid token list %s: %s", reply.Data, err)
+	}
+	if len(tokens) != 1 || tokens[0].Name != "pool1" {
+		t.Errorf("Expected only the token of pool1 to be listed, got %v", tokens)
+	}
+
+	// tokens outside the scope of the user can not be revoked
+	if w := request("DELETE", "/api/v1/tokens/global", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected revoking a token outside the scope of the user to fail, got %d", w.Code)
+	}
+
+	if _, ok := apiTokens.Find("global"); !ok {
+		t.Errorf("Expected the global token to stay active")
+	}
+}
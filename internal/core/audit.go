@@ -0,0 +1,187 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/schubergphilis/mercury/internal/config"
+	"github.com/schubergphilis/mercury/internal/web"
+	"github.com/schubergphilis/mercury/pkg/audit"
+	"github.com/schubergphilis/mercury/pkg/cluster"
+	"github.com/schubergphilis/mercury/pkg/logging"
+	"github.com/schubergphilis/mercury/pkg/param"
+)
+
+const (
+	// auditSyncSize is the number of audit entries sent per packet when syncing a joining node
+	auditSyncSize = 100
+	// auditBufferSize is the number of audit entries waiting to be mirrored to the cluster, before new entries are dropped
+	auditBufferSize = 100
+)
+
+// InitializeAudit opens the audit log
+func (manager *Manager) InitializeAudit() {
+	log := logging.For("core/audit/init")
+	auditLog, err := audit.New(config.Get().Audit)
+	if err != nil {
+		log.WithError(err).Warn("Unable to open audit log, only keeping entries in memory")
+	}
+
+	manager.auditLog = auditLog
+	manager.configHash = configHash()
+}
+
+// auditReload reopens the audit log, so rotated files are picked up, and records the config reload
+func (manager *Manager) auditReload() {
+	log := logging.For("core/audit/reload")
+	if err := manager.auditLog.Configure(config.Get().Audit); err != nil {
+		log.WithError(err).Warn("Unable to reopen audit log")
+	}
+
+	entry := audit.Entry{
+		User:   "system",
+		Action: "config.reload",
+		Target: *param.Get().ConfigFile,
+		Before: manager.configHash,
+		After:  configHash(),
+	}
+
+	if config.FailedReloadTime.After(config.ReloadTime) {
+		entry.Error = config.FailedReloadError
+	}
+
+	manager.configHash = entry.After
+	manager.audit(entry)
+}
+
+// audit records an administrative action, and mirrors it to the cluster
+// entries are only dropped from the mirror if the cluster client is not keeping up, they are always written locally
+func (manager *Manager) audit(entry audit.Entry) {
+	log := logging.For("core/audit").WithField("user", entry.User).WithField("action", entry.Action).WithField("target", entry.Target)
+	entry.Node = config.Get().Cluster.Binding.Name
+	entry, err := manager.auditLog.Add(entry)
+	if err != nil {
+		log.WithError(err).Warn("Unable to write audit log")
+	}
+	log.Info("Audit")
+
+	select {
+	case manager.auditUpdates <- &config.ClusterPacketAuditEntries{Entries: []audit.Entry{entry}}:
+	default:
+		dropped := atomic.AddUint64(&manager.auditDropped, 1)
+		log.WithField("dropped", dropped).Warn("Unable to mirror audit entry to the cluster, buffer full")
+	}
+}
+
+// auditRequest records an administrative action done through the api
+func (manager *Manager) auditRequest(r *http.Request, action, target, before, after string, err error) {
+	entry := audit.Entry{
+		User:     apiUsername(r),
+		SourceIP: sourceIP(r.RemoteAddr),
+		Action:   action,
+		Target:   target,
+		Before:   before,
+		After:    after,
+	}
+
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	manager.audit(entry)
+}
+
+// auditClusterAPI records a request done through the cluster admin api
+func (manager *Manager) auditClusterAPI(request cluster.APIRequest) {
+	manager.audit(audit.Entry{
+		User:     request.User,
+		SourceIP: sourceIP(request.RemoteAddr),
+		Action:   "cluster." + request.Action,
+		Target:   request.Node,
+	})
+}
+
+// clusterAuditBroadcast sends audit entries to all cluster nodes
+func clusterAuditBroadcast(cl *cluster.Manager, update *config.ClusterPacketAuditEntries) {
+	cl.ToCluster <- update
+}
+
+// clusterAuditToNode sends the audit entries we know to a cluster node, so it has the full history after joining
+func (manager *Manager) clusterAuditToNode(cl *cluster.Manager, node string) {
+	entries := manager.auditLog.Entries()
+	for len(entries) > 0 {
+		size := auditSyncSize
+		if len(entries) < size {
+			size = len(entries)
+		}
+
+		cl.ToNode <- cluster.NodeMessage{Node: node, Message: &config.ClusterPacketAuditEntries{Entries: entries[:size]}}
+		entries = entries[size:]
+	}
+}
+
+// addClusterAudit adds audit entries received from a cluster node
+func (manager *Manager) addClusterAudit(update *config.ClusterPacketAuditEntries) error {
+	for _, entry := range update.Entries {
+		if _, err := manager.auditLog.Add(entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// configHash returns the hash of the config file, to show config changes in the audit log
+func configHash() string {
+	data, err := ioutil.ReadFile(*param.Get().ConfigFile)
+	if err != nil {
+		return ""
+	}
+
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
+
+// sourceIP returns the ip of a remote address
+func sourceIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+
+	return host
+}
+
+// auditState returns the json of a value to use as before or after state in the audit log
+func auditState(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%+v", v)
+	}
+
+	return string(data)
+}
+
+// Audit API
+type apiAuditHandler struct {
+	manager *Manager
+}
+
+// Audit API returns the audit log of all cluster nodes
+func (h apiAuditHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !apiAllowedAny(r, web.RoleAdmin) {
+		apiWriteData(w, 403, apiMessage{Success: false, Error: fmt.Sprintf("Permission denied, role %s required", web.RoleAdmin)})
+		return
+	}
+
+	data, err := json.Marshal(h.manager.auditLog.Entries())
+	if err != nil {
+		apiWriteData(w, 501, apiMessage{Success: false, Error: err.Error()})
+		return
+	}
+	apiWriteJSONData(w, http.StatusOK, apiMessage{Success: true, Data: string(data)})
+}
@@ -57,6 +57,10 @@ func (manager *Manager) ClusterClient(cl *cluster.Manager) {
 				// Ignore config requests from self
 				log.WithField("client", packet.Name).WithField("request", packet.DataType).Info("Sending config")
 				go clusterDNSUpdateSingleBroadcastAll(cl, packet.Name)
+				go manager.clusterMaintenanceWindowsToNode(cl, packet.Name)
+				go manager.clusterSplitWeightsToNode(cl, packet.Name)
+				go manager.clusterAuditToNode(cl, packet.Name)
+				go clusterAPITokensToNode(cl, packet.Name)
 
 			case "config.ClusterPacketGlobalDNSUpdate":
 				log.WithField("func", "core").Debug("globalDNSUpdate")
@@ -117,6 +121,109 @@ func (manager *Manager) ClusterClient(cl *cluster.Manager) {
 				manager.clearStatsProxyBackend <- su
 				log.Debug("Clear proxy stats done")
 
+			case "config.ClusterPacketHealthCheckTransition":
+				log.WithField("func", "core").Debug("healthCheckTransition")
+				transition := &config.ClusterPacketHealthCheckTransition{}
+				err := packet.Message(transition)
+				if err != nil {
+					log.Warnf("Unable to parse ClusterHealthCheckTransition request: %s", err.Error())
+					continue
+				}
+
+				log.WithField("client", packet.Name).WithField("request", packet.DataType).WithField("pool", transition.Entry.PoolName).WithField("backend", transition.Entry.BackendName).WithField("node", transition.Entry.NodeName).WithField("status", transition.Entry.Status.String()).Debug("Received cluster healthcheck transition")
+				manager.healthManager.AddNodeHistory(packet.Name, transition.Entry)
+
+			case "config.ClusterPacketMaintenanceWindowUpdate":
+				log.WithField("func", "core").Debug("maintenanceWindowUpdate")
+				update := &config.ClusterPacketMaintenanceWindowUpdate{}
+				err := packet.Message(update)
+				if err != nil {
+					log.Warnf("Unable to parse ClusterMaintenanceWindowUpdate request: %s", err.Error())
+					continue
+				}
+
+				clog := log.WithField("client", packet.Name).WithField("request", packet.DataType).WithField("window", update.Window.Name).WithField("remove", update.Remove)
+				if update.Remove {
+					err = manager.RemoveMaintenanceWindow(update.Window.Name)
+				} else {
+					err = manager.AddMaintenanceWindow(update.Window)
+				}
+
+				if err != nil {
+					clog.WithError(err).Warn("Unable to process cluster maintenance window update")
+					continue
+				}
+				clog.Info("Received cluster maintenance window update")
+
+			case "config.ClusterPacketSplitWeightUpdate":
+				log.WithField("func", "core").Debug("splitWeightUpdate")
+				update := &config.ClusterPacketSplitWeightUpdate{}
+				err := packet.Message(update)
+				if err != nil {
+					log.Warnf("Unable to parse ClusterSplitWeightUpdate request: %s", err.Error())
+					continue
+				}
+
+				clog := log.WithField("client", packet.Name).WithField("request", packet.DataType).WithField("pool", update.PoolName).WithField("backend", update.BackendName).WithField("weight", update.Weight).WithField("remove", update.Remove)
+				if update.Remove {
+					err = manager.ResetSplitWeight(update.PoolName, update.BackendName)
+				} else {
+					err = manager.SetSplitWeight(update.PoolName, update.BackendName, update.Weight)
+				}
+
+				if err != nil {
+					clog.WithError(err).Warn("Unable to process cluster split weight update")
+					continue
+				}
+				clog.Info("Received cluster split weight update")
+
+			case "config.ClusterPacketAPITokenUpdate":
+				log.WithField("func", "core").Debug("apiTokenUpdate")
+				update := &config.ClusterPacketAPITokenUpdate{}
+				err := packet.Message(update)
+				if err != nil {
+					log.Warnf("Unable to parse ClusterAPITokenUpdate request: %s", err.Error())
+					continue
+				}
+
+				clog := log.WithField("client", packet.Name).WithField("request", packet.DataType).WithField("token", update.Token.Name)
+				changed, err := apiTokens.Merge(update.Token)
+				if err != nil {
+					clog.WithError(err).Warn("Unable to save cluster api token update")
+					continue
+				}
+
+				if changed {
+					clog.WithField("revoked", !update.Token.Revoked.IsZero()).Info("Received cluster api token update")
+				}
+
+			case "config.ClusterPacketAuditEntries":
+				log.WithField("func", "core").Debug("auditEntries")
+				update := &config.ClusterPacketAuditEntries{}
+				err := packet.Message(update)
+				if err != nil {
+					log.Warnf("Unable to parse ClusterAuditEntries request: %s", err.Error())
+					continue
+				}
+
+				if err := manager.addClusterAudit(update); err != nil {
+					log.WithField("client", packet.Name).WithField("request", packet.DataType).WithError(err).Warn("Unable to write cluster audit entries")
+				}
+
+			case "config.ClusterPacketLimitCounters":
+				update := &config.ClusterPacketLimitCounters{}
+				if err := packet.Message(update); err != nil {
+					log.Warnf("Unable to parse ClusterPacketLimitCounters request: %s", err.Error())
+					continue
+				}
+
+				backend, err := proxyGetBackend(update.PoolName, update.BackendName)
+				if err != nil {
+					log.WithField("client", packet.Name).WithError(err).Debug("Received limit counters for unknown backend")
+					continue
+				}
+				backend.AddRemoteLimitCounters(packet.Name, update.Counters)
+
 			default:
 				log.WithField("client", packet.Name).WithField("request", packet.DataType).WithField("data", packet.DataMessage).Warn("Recieved unknown cluster request")
 			}
@@ -138,6 +245,29 @@ func (manager *Manager) ClusterClient(cl *cluster.Manager) {
 			}
 			go clusterProxyStatsBroadcast(cl, cgstats)
 
+		case update := <-manager.maintenanceUpdates:
+			log.WithField("func", "core").Debug("maintenanceWindowBroadcast")
+			go clusterMaintenanceWindowBroadcast(cl, update)
+
+		case update := <-manager.splitWeightUpdates:
+			log.WithField("func", "core").Debug("splitWeightBroadcast")
+			go clusterSplitWeightBroadcast(cl, update)
+
+		case update := <-manager.limitCounterUpdates:
+			go clusterLimitCountersBroadcast(cl, update)
+
+		case update := <-manager.apiTokenUpdates:
+			log.WithField("func", "core").Debug("apiTokenBroadcast")
+			go clusterAPITokenBroadcast(cl, update)
+
+		case update := <-manager.auditUpdates:
+			log.WithField("func", "core").Debug("auditBroadcast")
+			go clusterAuditBroadcast(cl, update)
+
+		case request := <-cl.FromClusterAPI:
+			log.WithField("func", "core").WithField("action", request.Action).WithField("node", request.Node).Info("Received cluster api request")
+			manager.auditClusterAPI(request)
+
 		case healthcheck := <-manager.healthchecks:
 			log.WithField("func", "core").Debug("healthcheck")
 			clog := log.WithField("pool", healthcheck.PoolName).WithField("backend", healthcheck.BackendName).WithField("nodeuuid", healthcheck.NodeUUID).WithField("node", healthcheck.NodeName).WithField("status", healthcheck.ReportedStatus.String()).WithField("func", "healthcheck")
@@ -156,8 +286,12 @@ func (manager *Manager) ClusterClient(cl *cluster.Manager) {
 				continue
 			}
 
+			// Keep track of the transition, and share it with our peers
+			manager.addNodeHistory(cl, healthcheck)
+
 			// Update node status in memory
 			config.UpdateNodeStatus(healthcheck.PoolName, healthcheck.BackendName, healthcheck.NodeUUID, healthcheck.ReportedStatus, healthcheck.ErrorMsg)
+			config.UpdateNodeWeight(healthcheck.PoolName, healthcheck.BackendName, healthcheck.NodeUUID, healthcheck.Weight)
 
 			// Get UUID of node
 			clog.WithField("searchnode", healthcheck.NodeUUID).Debug("Search node by uuid")
@@ -248,7 +382,7 @@ func (manager *Manager) sendDNSUpdate(cl *cluster.Manager, poolName string, back
 		PoolName:    poolName,
 		BackendName: backendName,
 		DNSEntry:    backend.DNSEntry,
-		BalanceMode: backend.BalanceMode,
+		BalanceMode: getLocalBalanceMode(poolName, backendName, backend.BalanceMode),
 		BackendUUID: backend.UUID,
 		Status:      getLocalNodeStatus(poolName, backendName),
 	}
@@ -275,7 +409,7 @@ func (manager *Manager) updateProxyBackendNode(poolName string, backendName stri
 	proxyupdate := &config.ProxyBackendNodeUpdate{
 		PoolName:        poolName,
 		BackendName:     backendName,
-		BackendNode:     proxy.BackendNode{IP: node.IP, Port: node.Port, Hostname: node.Hostname, MaxConnections: node.MaxConnections, LocalNetwork: node.LocalNetwork, Preference: node.Preference, Weight: node.Weight, Status: node.Status},
+		BackendNode:     proxy.BackendNode{IP: node.IP, Port: node.Port, Hostname: node.Hostname, MaxConnections: node.MaxConnections, LocalNetwork: node.LocalNetwork, Preference: node.Preference, Weight: node.EffectiveWeight(), Status: node.Status},
 		BackendNodeUUID: node.UUID,
 	}
 	if config.Get().Settings.EnableProxy == YES {
@@ -288,6 +422,9 @@ func (manager *Manager) updateProxyBackendNode(poolName string, backendName stri
 		case healthcheck.Maintenance:
 			clog.Warnf("Set proxy to Maintenance")
 			manager.addProxyBackend <- proxyupdate // add or update
+		case healthcheck.Draining:
+			clog.Warnf("Set proxy to Draining")
+			manager.addProxyBackend <- proxyupdate // add or update
 		default:
 			clog.Warnf("Remove proxy due to offline")
 			manager.removeProxyBackend <- proxyupdate
@@ -389,7 +526,7 @@ func getLocalNodeStatus(poolName, backendName string) healthcheck.Status {
 		if node.Status == healthcheck.Online && node.ClusterName == config.GetNoLock().Cluster.Binding.Name {
 			online++
 		}
-		if node.Status == healthcheck.Maintenance && node.ClusterName == config.GetNoLock().Cluster.Binding.Name {
+		if (node.Status == healthcheck.Maintenance || node.Status == healthcheck.Draining) && node.ClusterName == config.GetNoLock().Cluster.Binding.Name {
 			maintenance++
 		}
 	}
@@ -398,7 +535,7 @@ func getLocalNodeStatus(poolName, backendName string) healthcheck.Status {
 		return healthcheck.Online
 	}
 
-	// if we are not online, but we have nodes in maintenance, then status is maintenance
+	// if we are not online, but we have nodes in maintenance or draining, then status is maintenance
 	if maintenance > 0 {
 		return healthcheck.Maintenance
 	}
@@ -407,6 +544,34 @@ func getLocalNodeStatus(poolName, backendName string) healthcheck.Status {
 	return healthcheck.Offline
 }
 
+// getLocalBalanceMode returns the balance mode of a backend, with its weight based on the weights reported by the healthchecks of our local nodes
+// if none of the local nodes reports a weight, the configured weight of the backend is used
+func getLocalBalanceMode(poolName, backendName string, balanceMode config.BalanceMode) config.BalanceMode {
+	config.RLock()
+	defer config.RUnlock()
+	reported := false
+	weight := 0
+	for _, node := range config.GetNoLock().Loadbalancer.Pools[poolName].Backends[backendName].Nodes {
+		if node.ClusterName != config.GetNoLock().Cluster.Binding.Name {
+			continue
+		}
+
+		if node.CheckWeight != nil {
+			reported = true
+		}
+
+		if node.Status == healthcheck.Online {
+			weight += node.EffectiveWeight()
+		}
+	}
+
+	if reported {
+		balanceMode.Weight = weight
+	}
+
+	return balanceMode
+}
+
 func clusterClearProxyStatistics(cl *cluster.Manager, poolname string, backendname string) {
 	c := &config.ClusterPacketClearProxyStatistics{
 		PoolName:    poolname,
@@ -415,10 +580,21 @@ func clusterClearProxyStatistics(cl *cluster.Manager, poolname string, backendna
 	cl.ToCluster <- c
 }
 
+func clusterHealthCheckTransitionBroadcast(cl *cluster.Manager, entry healthcheck.HistoryEntry) {
+	cl.ToCluster <- &config.ClusterPacketHealthCheckTransition{
+		Entry: entry,
+	}
+}
+
 func clusterProxyStatsBroadcast(cl *cluster.Manager, stats *config.ClusterPacketGlbalDNSStatisticsUpdate) {
 	cl.ToCluster <- stats
 }
 
+// clusterLimitCountersBroadcast sends the rate limit counters of a backend to all cluster nodes
+func clusterLimitCountersBroadcast(cl *cluster.Manager, update *config.ClusterPacketLimitCounters) {
+	cl.ToCluster <- update
+}
+
 func clusterDNSUpdateSingleBroadcastAll(cl *cluster.Manager, client string) {
 	log := logging.For("core/cluster/dnsbroadcast").WithField("func", "dns")
 	config.RLock()
@@ -450,7 +626,7 @@ func clusterDNSUpdateSingle(cl *cluster.Manager, client string, clusterNode stri
 		PoolName:    poolName,
 		BackendName: backendName,
 		DNSEntry:    dnsEntry,
-		BalanceMode: balanceMode,
+		BalanceMode: getLocalBalanceMode(poolName, backendName, balanceMode),
 		BackendUUID: backendUUID,
 		Status:      status,
 	}
@@ -464,7 +640,7 @@ func clusterDNSUpdateBroadcast(cl *cluster.Manager, clusterNode string, poolName
 		PoolName:    poolName,
 		BackendName: backendName,
 		DNSEntry:    dnsEntry,
-		BalanceMode: balanceMode,
+		BalanceMode: getLocalBalanceMode(poolName, backendName, balanceMode),
 		BackendUUID: backendUUID,
 		Status:      status,
 	}
@@ -81,7 +81,7 @@ func (manager *Manager) DNSHandler() {
 }
 
 // InitializeDNSUpdates manages DNS records
-func (manager Manager) InitializeDNSUpdates() {
+func (manager *Manager) InitializeDNSUpdates() {
 	log := logging.For("core/dnsinit").WithField("func", "dns")
 	log.Debugf("Starting DNS handler")
 	go manager.DNSHandler()
@@ -90,7 +90,7 @@ func (manager Manager) InitializeDNSUpdates() {
 }
 
 // StartDNSServer starts the dns server
-func (manager Manager) StartDNSServer() {
+func (manager *Manager) StartDNSServer() {
 	go dns.Server(config.Get().DNS.Binding, config.Get().DNS.Port, config.Get().DNS.AllowedRequests)
 }
 
@@ -150,7 +150,7 @@ func healthcheckStatusToDNSStatus(s healthcheck.Status) dns.Status {
 		return dns.Online
 	case healthcheck.Offline:
 		return dns.Offline
-	case healthcheck.Maintenance:
+	case healthcheck.Maintenance, healthcheck.Draining:
 		return dns.Maintenance
 	case healthcheck.Automatic:
 		return dns.Automatic
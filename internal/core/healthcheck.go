@@ -4,8 +4,10 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/schubergphilis/mercury/internal/config"
+	"github.com/schubergphilis/mercury/pkg/cluster"
 	"github.com/schubergphilis/mercury/pkg/healthcheck"
 	"github.com/schubergphilis/mercury/pkg/logging"
 )
@@ -32,6 +34,8 @@ func (manager *Manager) HealthHandler(healthCheck *healthcheck.Manager) {
 
 			// Set status in healh pool
 			healthCheck.SetCheckStatus(checkresult.WorkerUUID, checkresult.ReportedStatus, checkresult.ErrorMsg)
+			healthCheck.SetCheckWeight(checkresult.WorkerUUID, checkresult.Weight)
+			healthCheck.AddWorkerHistory(checkresult)
 
 			// Get all nodes using the check
 			nodeUUIDs := healthCheck.GetPools(checkresult.WorkerUUID)
@@ -43,6 +47,7 @@ func (manager *Manager) HealthHandler(healthCheck *healthcheck.Manager) {
 				checkresult.ReportedStatus = actualStatus
 				checkresult.ErrorMsg = errors
 				checkresult.NodeUUID = nodeUUID
+				checkresult.Weight = healthCheck.GetNodeWeight(nodeUUID)
 				if poolName != "" {
 					checkresult.PoolName = poolName
 				}
@@ -63,6 +68,23 @@ func (manager *Manager) HealthHandler(healthCheck *healthcheck.Manager) {
 	}
 }
 
+// addNodeHistory records a local node status in the healthcheck history, and broadcasts it to the cluster if it was a transition
+func (manager *Manager) addNodeHistory(cl *cluster.Manager, checkresult healthcheck.CheckResult) {
+	entry := healthcheck.HistoryEntry{
+		PoolName:    checkresult.PoolName,
+		BackendName: checkresult.BackendName,
+		NodeName:    checkresult.NodeName,
+		NodeUUID:    checkresult.NodeUUID,
+		Status:      checkresult.ReportedStatus,
+		ErrorMsg:    checkresult.ErrorMsg,
+		Time:        time.Now(), // the cluster gets the same time as the local history
+	}
+
+	if manager.healthManager.AddNodeHistory(config.Get().Cluster.Binding.Name, entry) {
+		go clusterHealthCheckTransitionBroadcast(cl, entry)
+	}
+}
+
 // InitializeHealthChecks sets up the health checking
 func (manager *Manager) InitializeHealthChecks(h *healthcheck.Manager) {
 	log := logging.For("core/healthcheck/init").WithField("func", "healthcheck")
@@ -114,18 +136,10 @@ func (manager *Manager) InitializeHealthChecks(h *healthcheck.Manager) {
 					}
 				}
 				// Register all checks applicable to this node
-				var nodeChecks []string
-				for _, w := range nodeWorkers {
-					nodeChecks = append(nodeChecks, w.UUID())
-				}
-
-				for _, w := range backendWorkers {
-					nodeChecks = append(nodeChecks, w.UUID())
-				}
-
-				for _, w := range poolWorkers {
-					nodeChecks = append(nodeChecks, w.UUID())
-				}
+				var nodeChecks []*healthcheck.Worker
+				nodeChecks = append(nodeChecks, nodeWorkers...)
+				nodeChecks = append(nodeChecks, backendWorkers...)
+				nodeChecks = append(nodeChecks, poolWorkers...)
 
 				// Register all checks applicable to the node UUID
 				new := h.SetCheckPool(node.UUID, poolName, backendName, node.Name(), backend.HealthCheckMode, nodeChecks)
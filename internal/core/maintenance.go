@@ -0,0 +1,170 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/schubergphilis/mercury/internal/config"
+	"github.com/schubergphilis/mercury/pkg/cluster"
+	"github.com/schubergphilis/mercury/pkg/healthcheck"
+	"github.com/schubergphilis/mercury/pkg/logging"
+)
+
+// maintenanceInterval is how often the maintenance windows are evaluated
+var maintenanceInterval = 10 * time.Second
+
+// MaintenanceHandler puts nodes in and out of maintenance based on the maintenance windows
+func (manager *Manager) MaintenanceHandler() {
+	ticker := time.NewTicker(maintenanceInterval)
+	for {
+		select {
+		case <-ticker.C:
+			manager.updateMaintenance(time.Now())
+		}
+	}
+}
+
+// MaintenanceWindows returns all maintenance windows from config and api
+func (manager *Manager) MaintenanceWindows() (windows []config.MaintenanceWindow) {
+	windows = append(windows, config.Get().Maintenance...)
+	manager.maintenanceLock.RLock()
+	defer manager.maintenanceLock.RUnlock()
+	var api []config.MaintenanceWindow
+	for _, window := range manager.maintenanceWindows {
+		api = append(api, window)
+	}
+
+	sort.Slice(api, func(i, j int) bool {
+		return api[i].Name < api[j].Name
+	})
+
+	return append(windows, api...)
+}
+
+// AddMaintenanceWindow adds or replaces a maintenance window defined through the api
+func (manager *Manager) AddMaintenanceWindow(window config.MaintenanceWindow) error {
+	window.Source = "api"
+	if err := window.Validate(); err != nil {
+		return err
+	}
+
+	for _, w := range config.Get().Maintenance {
+		if w.Name == window.Name {
+			return fmt.Errorf("maintenance window:%s is defined in the config", window.Name)
+		}
+	}
+
+	manager.maintenanceLock.Lock()
+	defer manager.maintenanceLock.Unlock()
+	manager.maintenanceWindows[window.Name] = window
+	return nil
+}
+
+// RemoveMaintenanceWindow removes a maintenance window defined through the api
+func (manager *Manager) RemoveMaintenanceWindow(name string) error {
+	manager.maintenanceLock.Lock()
+	defer manager.maintenanceLock.Unlock()
+	if _, ok := manager.maintenanceWindows[name]; !ok {
+		return fmt.Errorf("unknown maintenance window: %s", name)
+	}
+
+	delete(manager.maintenanceWindows, name)
+	return nil
+}
+
+// updateMaintenance sets the manual status of nodes matching an active window to maintenance, and reverts it for nodes no longer matching
+func (manager *Manager) updateMaintenance(now time.Time) {
+	log := logging.For("core/maintenance").WithField("func", "maintenance")
+
+	// remove expired windows
+	manager.maintenanceLock.Lock()
+	for name, window := range manager.maintenanceWindows {
+		if window.Expired(now) {
+			log.WithField("window", name).Info("Removing expired maintenance window")
+			delete(manager.maintenanceWindows, name)
+		}
+	}
+	manager.maintenanceLock.Unlock()
+
+	var active []config.MaintenanceWindow
+	for _, window := range manager.MaintenanceWindows() {
+		if window.Active(now) {
+			active = append(active, window)
+		}
+	}
+
+	expected := make(map[string]string)
+	for poolName, pool := range config.Get().Loadbalancer.Pools {
+		for backendName, backend := range pool.Backends {
+			for _, node := range backend.Nodes {
+				for _, window := range active {
+					if window.Matches(poolName, backendName, *node) {
+						expected[node.UUID] = window.Name
+					}
+				}
+			}
+		}
+	}
+
+	// put new nodes in maintenance
+	for nodeUUID, window := range expected {
+		if manager.maintenanceNodes[nodeUUID] {
+			continue
+		}
+
+		if err := manager.healthManager.SetNodeStatus(nodeUUID, healthcheck.Maintenance); err != nil {
+			log.WithField("window", window).WithField("nodeuuid", nodeUUID).WithError(err).Debug("Unable to put node in maintenance")
+			continue
+		}
+
+		log.WithField("window", window).WithField("nodeuuid", nodeUUID).Info("Maintenance window started for node")
+		manager.maintenanceNodes[nodeUUID] = true
+		manager.sendNodeStatus(nodeUUID)
+	}
+
+	// revert nodes no longer in a window
+	for nodeUUID := range manager.maintenanceNodes {
+		if _, ok := expected[nodeUUID]; ok {
+			continue
+		}
+
+		delete(manager.maintenanceNodes, nodeUUID)
+		if err := manager.healthManager.SetNodeStatus(nodeUUID, healthcheck.Automatic); err != nil {
+			log.WithField("nodeuuid", nodeUUID).WithError(err).Debug("Unable to revert node from maintenance")
+			continue
+		}
+
+		log.WithField("nodeuuid", nodeUUID).Info("Maintenance window ended for node")
+		manager.sendNodeStatus(nodeUUID)
+	}
+}
+
+// sendNodeStatus sends the current status of a node to the cluster client
+func (manager *Manager) sendNodeStatus(nodeUUID string) {
+	status, poolName, backendName, nodeName, errors := manager.healthManager.GetNodeStatus(nodeUUID)
+	manager.healthchecks <- healthcheck.CheckResult{
+		PoolName:       poolName,
+		BackendName:    backendName,
+		NodeName:       nodeName,
+		NodeUUID:       nodeUUID,
+		ActualStatus:   status,
+		ReportedStatus: status,
+		ErrorMsg:       errors,
+		Weight:         manager.healthManager.GetNodeWeight(nodeUUID),
+	}
+}
+
+// clusterMaintenanceWindowBroadcast sends a maintenance window change to all cluster nodes
+func clusterMaintenanceWindowBroadcast(cl *cluster.Manager, update *config.ClusterPacketMaintenanceWindowUpdate) {
+	cl.ToCluster <- update
+}
+
+// clusterMaintenanceWindowsToNode sends all maintenance windows defined through the api to a cluster node
+func (manager *Manager) clusterMaintenanceWindowsToNode(cl *cluster.Manager, node string) {
+	for _, window := range manager.MaintenanceWindows() {
+		if window.Source == "api" {
+			cl.ToNode <- cluster.NodeMessage{Node: node, Message: &config.ClusterPacketMaintenanceWindowUpdate{Window: window}}
+		}
+	}
+}
@@ -3,9 +3,11 @@ package core
 import (
 	"fmt"
 	"runtime"
+	"sync"
 
 	"github.com/schubergphilis/mercury/internal/config"
 	"github.com/schubergphilis/mercury/internal/web"
+	"github.com/schubergphilis/mercury/pkg/audit"
 	"github.com/schubergphilis/mercury/pkg/cluster"
 	"github.com/schubergphilis/mercury/pkg/healthcheck"
 	"github.com/schubergphilis/mercury/pkg/logging"
@@ -32,6 +34,20 @@ type Manager struct {
 	proxyBackendStatisticsUpdate    chan *config.ProxyBackendStatisticsUpdate
 	healthManager                   *healthcheck.Manager
 	webAuthenticator                web.Auth
+	maintenanceUpdates              chan *config.ClusterPacketMaintenanceWindowUpdate
+	maintenanceWindows              map[string]config.MaintenanceWindow // maintenance windows added through the api
+	maintenanceNodes                map[string]bool                     // nodes put in maintenance by a maintenance window
+	maintenanceLock                 sync.RWMutex
+	auditLog                        *audit.Log
+	auditUpdates                    chan *config.ClusterPacketAuditEntries
+	auditDropped                    uint64 // audit entries not mirrored to the cluster, because the cluster client was busy
+	configHash                      string // hash of the config file, to record config changes in the audit log
+	apiTokenUpdates                 chan *config.ClusterPacketAPITokenUpdate
+	apiTokenFile                    string // file the api tokens are loaded from
+	limitCounterUpdates             chan *config.ClusterPacketLimitCounters
+	splitWeightUpdates              chan *config.ClusterPacketSplitWeightUpdate
+	splitWeights                    map[string]int // split weights of backends changed through the api
+	splitLock                       sync.RWMutex
 }
 
 // NewManager creates a new manager
@@ -48,6 +64,14 @@ func NewManager() *Manager {
 		proxyBackendStatisticsUpdate:    make(chan *config.ProxyBackendStatisticsUpdate),
 		clusterGlbalDNSStatisticsUpdate: make(chan *config.ClusterPacketGlbalDNSStatisticsUpdate),
 		clearStatsProxyBackend:          make(chan *config.ClusterPacketClearProxyStatistics),
+		maintenanceUpdates:              make(chan *config.ClusterPacketMaintenanceWindowUpdate),
+		maintenanceWindows:              make(map[string]config.MaintenanceWindow),
+		maintenanceNodes:                make(map[string]bool),
+		auditUpdates:                    make(chan *config.ClusterPacketAuditEntries, auditBufferSize),
+		apiTokenUpdates:                 make(chan *config.ClusterPacketAPITokenUpdate),
+		limitCounterUpdates:             make(chan *config.ClusterPacketLimitCounters),
+		splitWeightUpdates:              make(chan *config.ClusterPacketSplitWeightUpdate),
+		splitWeights:                    make(map[string]int),
 	}
 	return manager
 }
@@ -62,26 +86,31 @@ func Initialize(reload <-chan bool) {
 	// Create IP's
 	CreateListeners()
 
+	// HealthCheck manager is required by the cluster client for history updates
+	manager.healthManager = healthcheck.NewManager()
+
+	// Audit log is required by the cluster client for mirrored entries
+	manager.InitializeAudit()
+
+	// API tokens and their signing key are required by the cluster client and api
+	manager.InitializeAPITokens()
+
 	// Cluster communication
 	go manager.InitializeCluster()
 
 	// HealthCheck's
-	manager.healthManager = healthcheck.NewManager()
 	go manager.HealthHandler(manager.healthManager)
 	go manager.InitializeHealthChecks(manager.healthManager)
+	go manager.MaintenanceHandler()
 
-	//log.Fatalf("web auth: %+v", config.Get().Web.Auth)
-	if config.Get().Web.Auth.LDAP != nil {
-		manager.webAuthenticator = config.Get().Web.Auth.LDAP
-	} else {
-		manager.webAuthenticator = config.Get().Web.Auth.Password
-	}
+	manager.webAuthenticator = webAuthenticator()
 	manager.setupAPI()
 
 	// Create Listeners for Loadbalancer
 	if config.Get().Settings.EnableProxy == YES {
 		go manager.InitializeProxies()
 		go manager.GetAllProxyStatsHandler()
+		go manager.GetLimitCountersHandler()
 	}
 
 	// DNS updates
@@ -100,6 +129,8 @@ func Initialize(reload <-chan bool) {
 			log.WithField("memory", fmt.Sprintf("%5.2fk", float64(stats.Alloc)/1024)).Infof("Memory usage before reload")
 			// Reload log level
 			go logging.Configure(config.Get().Logging.Output, config.Get().Logging.Level)
+			// Reopen the audit log and record the reload
+			manager.auditReload()
 			// Create new listeners if any
 			CreateListeners()
 			// Start new DNS Listeners (if changed)
@@ -112,11 +143,8 @@ func Initialize(reload <-chan bool) {
 			// Re-read proxies, and update where needed
 			// This needs to be after the healthchecks have been evacuated
 			go manager.InitializeProxies()
-			if config.Get().Web.Auth.LDAP != nil {
-				manager.webAuthenticator = config.Get().Web.Auth.LDAP
-			} else {
-				manager.webAuthenticator = config.Get().Web.Auth.Password
-			}
+			manager.webAuthenticator = webAuthenticator()
+			manager.InitializeAPITokens()
 			// force cargbage collection due to golang map[] memory leakage
 			// https://github.com/golang/go/issues/20135
 			runtime.GC()
@@ -125,6 +153,18 @@ func Initialize(reload <-chan bool) {
 	}
 }
 
+// webAuthenticator returns the configured authentication provider for the web interface
+func webAuthenticator() web.Auth {
+	switch {
+	case config.Get().Web.Auth.OIDC != nil:
+		return config.Get().Web.Auth.OIDC
+	case config.Get().Web.Auth.LDAP != nil:
+		return config.Get().Web.Auth.LDAP
+	}
+
+	return config.Get().Web.Auth.Password
+}
+
 // Cleanup the service
 func Cleanup() {
 	log := logging.For("core/manager")
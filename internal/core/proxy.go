@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/schubergphilis/mercury/internal/config"
+	"github.com/schubergphilis/mercury/internal/web"
 	"github.com/schubergphilis/mercury/pkg/logging"
 	"github.com/schubergphilis/mercury/pkg/proxy"
 	"github.com/schubergphilis/mercury/pkg/tlsconfig"
@@ -100,6 +101,7 @@ func (manager *Manager) InitializeProxies() {
 				existingProxy.ReadTimeout != pool.Listener.ReadTimeout ||
 				existingProxy.WriteTimeout != pool.Listener.WriteTimeout ||
 				existingProxy.OCSPStapling != pool.Listener.OCSPStapling ||
+				existingProxy.HTTP3 != pool.Listener.HTTP3 ||
 				!reflect.DeepEqual(existingTLS.CipherSuites, newTLS.CipherSuites) ||
 				!reflect.DeepEqual(existingTLS.CurvePreferences, newTLS.CurvePreferences) ||
 				!reflect.DeepEqual(existingTLS.Certificates, newTLS.Certificates) ||
@@ -109,7 +111,7 @@ func (manager *Manager) InitializeProxies() {
 			if listenerChanged {
 				// Interface changes, we need to restart the proxy, lets stop it
 				certchange := !reflect.DeepEqual(existingTLS.Certificates, newTLS.Certificates)
-				log.WithField("pool", poolname).Debugf("listener changed - mode:%t ip:%t port:%t, maxcon:%t readtimeout:%t writetimeout:%t ocsp:%t cert:%t cypher:%t curve:%t clientauth:%t",
+				log.WithField("pool", poolname).Debugf("listener changed - mode:%t ip:%t port:%t, maxcon:%t readtimeout:%t writetimeout:%t ocsp:%t http3:%t cert:%t cypher:%t curve:%t clientauth:%t",
 					existingProxy.ListenerMode != pool.Listener.Mode,
 					existingProxy.IP != pool.Listener.IP,
 					existingProxy.Port != pool.Listener.Port,
@@ -117,6 +119,7 @@ func (manager *Manager) InitializeProxies() {
 					existingProxy.ReadTimeout != pool.Listener.ReadTimeout,
 					existingProxy.WriteTimeout != pool.Listener.WriteTimeout,
 					existingProxy.OCSPStapling != pool.Listener.OCSPStapling,
+					existingProxy.HTTP3 != pool.Listener.HTTP3,
 					certchange,
 					!reflect.DeepEqual(existingTLS.CipherSuites, newTLS.CipherSuites),
 					!reflect.DeepEqual(existingTLS.CurvePreferences, newTLS.CurvePreferences),
@@ -124,6 +127,7 @@ func (manager *Manager) InitializeProxies() {
 				log.WithField("pool", poolname).Info("Restarting existing proxy for new listener settings")
 				existingProxy.Stop()
 				existingProxy.SetListener(pool.Listener.Mode, pool.Listener.SourceIP, pool.Listener.IP, pool.Listener.Port, pool.Listener.MaxConnections, newTLS, pool.Listener.ReadTimeout, pool.Listener.WriteTimeout, pool.Listener.HTTPProto, pool.Listener.OCSPStapling)
+				existingProxy.SetHTTP3(pool.Listener.HTTP3)
 				go existingProxy.Start()
 			}
 
@@ -162,6 +166,7 @@ func (manager *Manager) InitializeProxies() {
 			}
 
 			newProxy.SetListener(pool.Listener.Mode, pool.Listener.SourceIP, pool.Listener.IP, pool.Listener.Port, pool.Listener.MaxConnections, newTLS, pool.Listener.ReadTimeout, pool.Listener.WriteTimeout, pool.Listener.HTTPProto, pool.Listener.OCSPStapling)
+			newProxy.SetHTTP3(pool.Listener.HTTP3)
 			go newProxy.Start()
 			// Register new proxy
 			proxies.pool[poolname] = newProxy
@@ -187,6 +192,28 @@ func (manager *Manager) InitializeProxies() {
 			plog.WithField("file", pool.MaintenancePage.File).WithError(err).Warn("Unable to load Maintenance page")
 		}
 
+		if err := newProxy.LoadLimitPage(pool.LimitPage); err != nil {
+			// This is checked when loading the config
+			plog.WithField("file", pool.LimitPage.File).WithError(err).Warn("Unable to load Limit page")
+		}
+
+		if err := newProxy.LoadStatusPages(pool.StatusPages); err != nil {
+			// This is checked when loading the config
+			plog.WithError(err).Warn("Unable to load status pages")
+		}
+
+		if err := newProxy.SetWAF(pool.WAF); err != nil {
+			// This is checked when loading the config
+			plog.WithError(err).Warn("Unable to load waf rules")
+		}
+
+		if err := newProxy.SetCompression(pool.Compression); err != nil {
+			// This is checked when loading the config
+			plog.WithError(err).Warn("Unable to set compression")
+		}
+
+		newProxy.SetTrafficSplit(pool.TrafficSplit)
+
 		//log.Debugf("proxy:%s Proxy has the following backends before init:%+v", poolname, removableBackends)
 		for bid := range removableBackends {
 			plog.WithField("backend", bid).Debug("Backend before init")
@@ -208,6 +235,40 @@ func (manager *Manager) InitializeProxies() {
 
 			// Use backend to attach acl's
 			backend := newProxy.Backends[backendname]
+			backend.SetDrainTimeout(time.Duration(backendpool.DrainTimeout) * time.Second)
+			if err := backend.LoadLimitPage(backendpool.LimitPage); err != nil {
+				// This is checked when loading the config
+				plog.WithField("backend", backendname).WithField("file", backendpool.LimitPage.File).WithError(err).Warn("Unable to load Limit page")
+			}
+
+			if err := backend.LoadStatusPages(backendpool.StatusPages); err != nil {
+				// This is checked when loading the config
+				plog.WithField("backend", backendname).WithError(err).Warn("Unable to load status pages")
+			}
+
+			auth := backendpool.Auth
+			if auth.Type == "oidc" {
+				auth.Provider = newProxyOIDC(auth)
+			}
+			backend.SetAuth(auth)
+
+			if err := backend.SetRoutes(backendpool.Routes); err != nil {
+				// This is checked when loading the config
+				plog.WithField("backend", backendname).WithError(err).Warn("Unable to set routes")
+			}
+			backend.SetSplitWeight(manager.splitWeight(poolname, backendname, backendpool.SplitWeight))
+
+			backend.SetTimeouts(backendpool.Timeouts)
+
+			if err := backend.SetMirror(backendpool.Mirror); err != nil {
+				// This is checked when loading the config
+				plog.WithField("backend", backendname).WithError(err).Warn("Unable to set mirror")
+			}
+
+			if err := backend.SetWebsocket(backendpool.Websocket); err != nil {
+				// This is checked when loading the config
+				plog.WithField("backend", backendname).WithError(err).Warn("Unable to set websocket limits")
+			}
 
 			var inboundACLs []proxy.ACL
 			var outboundACLs []proxy.ACL
@@ -365,6 +426,7 @@ func (manager *Manager) ProxyHandler() {
 			if nodeid >= 0 {
 				plog.WithField("node", update.BackendNode.Name()).WithField("ip", update.BackendNode.IP).WithField("port", update.BackendNode.Port).Debug("Update proxy node")
 				backend.UpdateBackendNode(nodeid, update.BackendNode.Status)
+				backend.UpdateBackendNodeWeight(nodeid, update.BackendNode.Weight)
 				continue
 			}
 
@@ -486,3 +548,62 @@ func (manager *Manager) GetAllProxyStats() []*config.ProxyBackendStatisticsUpdat
 	}
 	return stats
 }
+
+// GetLimitCountersHandler periodicly sends the changed rate limit counters to the cluster nodes
+func (manager *Manager) GetLimitCountersHandler() {
+	ticker := time.NewTicker(250 * time.Millisecond)
+	for {
+		select {
+		case <-ticker.C:
+			for _, update := range manager.GetLimitCounters() {
+				manager.limitCounterUpdates <- update
+			}
+		}
+	}
+}
+
+// GetLimitCounters gets the changed rate limit counters of all backends
+func (manager *Manager) GetLimitCounters() (updates []*config.ClusterPacketLimitCounters) {
+	proxies.RLock()
+	defer proxies.RUnlock()
+	for poolname, pool := range proxies.pool {
+		for backendname, backend := range pool.Backends {
+			if counters := backend.LimitCounters(); len(counters) > 0 {
+				updates = append(updates, &config.ClusterPacketLimitCounters{
+					PoolName:    poolname,
+					BackendName: backendname,
+					Counters:    counters,
+				})
+			}
+		}
+	}
+	return
+}
+
+// proxyOIDC lets the auth gateway of a backend login users at an OpenID Connect issuer
+type proxyOIDC struct {
+	*web.AuthOIDC
+}
+
+// newProxyOIDC returns the OIDC provider for the auth settings of a backend
+func newProxyOIDC(auth proxy.BackendAuth) proxyOIDC {
+	return proxyOIDC{&web.AuthOIDC{
+		Issuer:        auth.Issuer,
+		ClientID:      auth.ClientID,
+		ClientSecret:  auth.ClientSecret,
+		RedirectURL:   auth.RedirectURL,
+		Scopes:        auth.Scopes,
+		UsernameClaim: auth.UsernameClaim,
+		GroupsClaim:   auth.GroupsClaim,
+	}}
+}
+
+// AuthCodeURL returns the url to send the user to for login
+func (p proxyOIDC) AuthCodeURL(state, nonce, verifier string) (string, error) {
+	return p.AuthOIDC.AuthCodeURL(web.OIDCLogin{State: state, Nonce: nonce, Verifier: verifier})
+}
+
+// Exchange exchanges the authorization code for the username and groups of the user
+func (p proxyOIDC) Exchange(code, nonce, verifier string) (string, []string, error) {
+	return p.AuthOIDC.Exchange(code, web.OIDCLogin{Nonce: nonce, Verifier: verifier})
+}
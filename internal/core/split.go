@@ -0,0 +1,121 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/schubergphilis/mercury/internal/config"
+	"github.com/schubergphilis/mercury/pkg/cluster"
+)
+
+// SplitWeight is the split weight of a backend
+type SplitWeight struct {
+	PoolName    string `json:"pool"`
+	BackendName string `json:"backend"`
+	Weight      int    `json:"weight"`
+	Source      string `json:"source"` // config, or api if it was changed at runtime
+}
+
+// splitWeightKey returns the key of a backend in the split weights changed through the api
+func splitWeightKey(poolname, backendname string) string {
+	return poolname + "/" + backendname
+}
+
+// splitWeight returns the split weight of a backend, the one set through the api if any, or else the configured weight
+func (manager *Manager) splitWeight(poolname, backendname string, configured int) int {
+	manager.splitLock.RLock()
+	defer manager.splitLock.RUnlock()
+	if weight, ok := manager.splitWeights[splitWeightKey(poolname, backendname)]; ok {
+		return weight
+	}
+
+	return configured
+}
+
+// SplitWeights returns the split weights of all http backends
+func (manager *Manager) SplitWeights() (weights []SplitWeight) {
+	for poolname, pool := range config.Get().Loadbalancer.Pools {
+		if pool.Listener.Mode != "http" && pool.Listener.Mode != "https" {
+			continue
+		}
+
+		for backendname, backend := range pool.Backends {
+			weight := SplitWeight{PoolName: poolname, BackendName: backendname, Weight: backend.SplitWeight, Source: "config"}
+			manager.splitLock.RLock()
+			if w, ok := manager.splitWeights[splitWeightKey(poolname, backendname)]; ok {
+				weight.Weight = w
+				weight.Source = "api"
+			}
+			manager.splitLock.RUnlock()
+			weights = append(weights, weight)
+		}
+	}
+
+	sort.Slice(weights, func(i, j int) bool {
+		if weights[i].PoolName != weights[j].PoolName {
+			return weights[i].PoolName < weights[j].PoolName
+		}
+		return weights[i].BackendName < weights[j].BackendName
+	})
+
+	return
+}
+
+// SetSplitWeight changes the split weight of a backend, until it is reset
+func (manager *Manager) SetSplitWeight(poolname, backendname string, weight int) error {
+	if weight < 0 {
+		return fmt.Errorf("split weight must be 0 or more: %d", weight)
+	}
+
+	if _, ok := config.Get().Loadbalancer.Pools[poolname].Backends[backendname]; !ok {
+		return fmt.Errorf("unknown backend:%s in pool:%s", backendname, poolname)
+	}
+
+	manager.splitLock.Lock()
+	manager.splitWeights[splitWeightKey(poolname, backendname)] = weight
+	manager.splitLock.Unlock()
+
+	manager.applySplitWeight(poolname, backendname)
+	return nil
+}
+
+// ResetSplitWeight reverts the split weight of a backend to the configured weight
+func (manager *Manager) ResetSplitWeight(poolname, backendname string) error {
+	manager.splitLock.Lock()
+	key := splitWeightKey(poolname, backendname)
+	if _, ok := manager.splitWeights[key]; !ok {
+		manager.splitLock.Unlock()
+		return fmt.Errorf("split weight of backend:%s in pool:%s was not changed", backendname, poolname)
+	}
+	delete(manager.splitWeights, key)
+	manager.splitLock.Unlock()
+
+	manager.applySplitWeight(poolname, backendname)
+	return nil
+}
+
+// applySplitWeight sets the split weight of a backend on its running proxy
+func (manager *Manager) applySplitWeight(poolname, backendname string) {
+	backend, err := proxyGetBackend(poolname, backendname)
+	if err != nil {
+		// the proxy gets the weight when it is created
+		return
+	}
+
+	configured := config.Get().Loadbalancer.Pools[poolname].Backends[backendname].SplitWeight
+	backend.SetSplitWeight(manager.splitWeight(poolname, backendname, configured))
+}
+
+// clusterSplitWeightBroadcast sends a split weight changed through the api to all cluster nodes
+func clusterSplitWeightBroadcast(cl *cluster.Manager, update *config.ClusterPacketSplitWeightUpdate) {
+	cl.ToCluster <- update
+}
+
+// clusterSplitWeightsToNode sends all split weights changed through the api to a cluster node
+func (manager *Manager) clusterSplitWeightsToNode(cl *cluster.Manager, node string) {
+	for _, weight := range manager.SplitWeights() {
+		if weight.Source == "api" {
+			cl.ToNode <- cluster.NodeMessage{Node: node, Message: &config.ClusterPacketSplitWeightUpdate{PoolName: weight.PoolName, BackendName: weight.BackendName, Weight: weight.Weight}}
+		}
+	}
+}
@@ -1,10 +1,16 @@
 package core
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
 	"fmt"
 	"net/http"
+	"time"
 
+	"github.com/schubergphilis/mercury/internal/config"
 	"github.com/schubergphilis/mercury/internal/web"
+	"github.com/schubergphilis/mercury/pkg/logging"
 )
 
 // web interface for healtheck
@@ -20,6 +26,20 @@ func (h webLoginHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Add("Cache-Control", "max-age=0, no-cache, must-revalidate, proxy-revalidate")
 
+	// OIDC users login at the issuer, which redirects back here with a code
+	token := ""
+	if oidc, ok := h.manager.webAuthenticator.(*web.AuthOIDC); ok {
+		if token, err = h.oidcLogin(w, r, oidc); err != nil {
+			webWriteError(w, 403, fmt.Sprintf("login failed: %s", err.Error()))
+			return
+		}
+
+		if token == "" {
+			// redirected to the issuer
+			return
+		}
+	}
+
 	page := newPage(h.title, r.RequestURI, username)
 	webTemplate, err := web.LoadTemplates("static", h.templateFiles)
 	if err != nil {
@@ -30,10 +50,92 @@ func (h webLoginHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	data := struct {
 		Page     web.Page
 		AuthType string
-	}{*page, h.manager.webAuthenticator.Type()}
+		Token    string
+	}{*page, h.manager.webAuthenticator.Type(), token}
 	err = webTemplate.ExecuteTemplate(w, h.template, data)
 	if err != nil {
 		webWriteError(w, 500, fmt.Sprintf("unable to execute template: %s", err.Error()))
 	}
 
 }
+
+// oidcLogin redirects the user to the issuer, or handles the redirect back from the issuer and returns an api token
+// the login in progress is kept in a signed cookie, so only the browser that started it can finish it
+func (h webLoginHandler) oidcLogin(w http.ResponseWriter, r *http.Request, oidc *web.AuthOIDC) (string, error) {
+	log := logging.For("core/login/oidc")
+	query := r.URL.Query()
+	if e := query.Get("error"); e != "" {
+		http.SetCookie(w, oidcLoginCookie(r, "", -1))
+		return "", fmt.Errorf("%s %s", e, query.Get("error_description"))
+	}
+
+	code := query.Get("code")
+	if code == "" {
+		login := web.NewOIDCLogin()
+		url, err := oidc.AuthCodeURL(login)
+		if err != nil {
+			log.WithError(err).Warn("Unable to start oidc login")
+			return "", err
+		}
+
+		value, err := login.Sign(oidcLoginKey())
+		if err != nil {
+			return "", err
+		}
+
+		http.SetCookie(w, oidcLoginCookie(r, value, int(web.OIDCLoginDuration.Seconds())))
+		http.Redirect(w, r, url, http.StatusFound)
+		return "", nil
+	}
+
+	// a login can only be used once
+	http.SetCookie(w, oidcLoginCookie(r, "", -1))
+	cookie, err := r.Cookie(web.OIDCLoginCookie)
+	if err != nil {
+		return "", fmt.Errorf("no login in progress, please try again")
+	}
+
+	login, err := web.ParseOIDCLogin(cookie.Value, oidcLoginKey())
+	if err != nil {
+		log.WithError(err).Info("Invalid oidc login cookie")
+		return "", fmt.Errorf("unknown or expired login, please try again")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(login.State), []byte(query.Get("state"))) != 1 {
+		return "", fmt.Errorf("invalid login state, please try again")
+	}
+
+	username, groups, err := oidc.Exchange(code, login)
+	if err != nil {
+		log.WithError(err).Warn("Unable to complete oidc login")
+		return "", err
+	}
+
+	grants := config.Get().Web.Auth.Grants(username, groups)
+	if !grants.AllowedAny(web.RoleViewer) {
+		return "", fmt.Errorf("no role assigned to user %s", username)
+	}
+
+	log.WithField("username", username).Info("User logged in through oidc")
+	return apiMakeKey(username, string(APITokenSigningKey), grants, time.Now().Add(APITokenDuration).Unix())
+}
+
+// oidcLoginKey returns the key signing the oidc login cookies, derived from the api signing key so they can not be used as api token
+func oidcLoginKey() []byte {
+	mac := hmac.New(sha256.New, APITokenSigningKey)
+	mac.Write([]byte("oidc login"))
+	return mac.Sum(nil)
+}
+
+// oidcLoginCookie returns the cookie with a login in progress, a negative maxAge removes it
+func oidcLoginCookie(r *http.Request, value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     web.OIDCLoginCookie,
+		Value:    value,
+		Path:     "/login/",
+		MaxAge:   maxAge,
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"github.com/schubergphilis/mercury/internal/config"
 	"github.com/schubergphilis/mercury/internal/web"
 	"github.com/schubergphilis/mercury/pkg/dns"
+	"github.com/schubergphilis/mercury/pkg/healthcheck"
 	"github.com/schubergphilis/mercury/pkg/logging"
 	"github.com/schubergphilis/mercury/pkg/proxy"
 	"github.com/schubergphilis/mercury/pkg/tlsconfig"
@@ -47,7 +48,7 @@ func FormattedDate(t time.Time) string {
 const applicationJSONHeader = "application/json"
 
 // WebBackendDetails Provides a detail page for backends
-func WebBackendDetails(w http.ResponseWriter, r *http.Request) {
+func (m *Manager) WebBackendDetails(w http.ResponseWriter, r *http.Request) {
 	log := logging.For("core/backenddetails").WithField("func", "web")
 	w.Header().Add("Cache-Control", "max-age=0, no-cache, must-revalidate, proxy-revalidate")
 	r.URL.Query().Get("backend")
@@ -71,13 +72,19 @@ func WebBackendDetails(w http.ResponseWriter, r *http.Request) {
 		log.WithField("error", err).Warn("Error loading templates")
 	}
 
+	history := make(map[string][]healthcheck.HistoryEntry)
+	for _, node := range backendDetails.Nodes {
+		history[node.UUID] = m.healthManager.GetNodeHistory(node.UUID)
+	}
+
 	data := struct {
 		Pool        config.LoadbalancePool
 		Backend     config.BackendPool
 		PoolName    string
 		BackendName string
+		History     map[string][]healthcheck.HistoryEntry
 		Page        web.Page
-	}{poolDetails, backendDetails, pool, backend, *page}
+	}{poolDetails, backendDetails, pool, backend, history, *page}
 
 	err = backenddetailsTemplate.ExecuteTemplate(w, "backenddetails", data)
 	if err != nil {
@@ -380,7 +387,7 @@ func (m *Manager) NewServer(ip string, port int) (s *http.Server, l net.Listener
 	http.HandleFunc("/backend", WebBackendStatus)
 	http.HandleFunc("/proxy", WebProxyStatus)
 	http.HandleFunc("/cluster", WebClusterStatus)
-	http.HandleFunc("/backenddetails", WebBackendDetails)
+	http.HandleFunc("/backenddetails", m.WebBackendDetails)
 	http.HandleFunc("/", WebRoot)
 
 	l, err = net.Listen("tcp", fmt.Sprintf("%s:%d", ip, port))
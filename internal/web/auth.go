@@ -6,6 +6,11 @@ type Auth interface {
 	Type() string
 }
 
+// GroupAuth is implemented by authentication providers that know the groups a user is member of
+type GroupAuth interface {
+	VerifyLoginGroups(username, password string) ([]string, error)
+}
+
 /*
 func NewAuthProvider(method string) (Auth, error) {
 	switch method {
@@ -12,15 +12,16 @@ import (
 
 // AuthLDAP is the provider for LDAP based authentication for the web service
 type AuthLDAP struct {
-	Host      string              `json:"host" toml:"host" yaml:"host"`       // address to connect to
-	Port      int                 `json:"port" toml:"port" yaml:"port"`       // port to connect to
-	Method    string              `json:"method" toml:"method" yaml:"method"` // connect method (SSL/TLS)
-	Domain    string              `json:"domain" toml:"domain" yaml:"domain"` // binddn
-	Filter    string              `json:"filter" toml:"filter" yaml:"filter"` // binddn
-	BindDN    string              `json:"binddn" toml:"binddn" yaml:"binddn"` // binddn
-	TLSConfig tlsconfig.TLSConfig `json:"tls" toml:"tls" yaml:"tls"`
-	addr      string
-	tlsConfig *tls.Config
+	Host           string              `json:"host" toml:"host" yaml:"host"`                                  // address to connect to
+	Port           int                 `json:"port" toml:"port" yaml:"port"`                                  // port to connect to
+	Method         string              `json:"method" toml:"method" yaml:"method"`                            // connect method (SSL/TLS)
+	Domain         string              `json:"domain" toml:"domain" yaml:"domain"`                            // binddn
+	Filter         string              `json:"filter" toml:"filter" yaml:"filter"`                            // binddn
+	BindDN         string              `json:"binddn" toml:"binddn" yaml:"binddn"`                            // binddn
+	GroupAttribute string              `json:"group_attribute" toml:"group_attribute" yaml:"group_attribute"` // attribute of the user containing its groups
+	TLSConfig      tlsconfig.TLSConfig `json:"tls" toml:"tls" yaml:"tls"`
+	addr           string
+	tlsConfig      *tls.Config
 }
 
 // Type is the authentication type
@@ -30,6 +31,15 @@ func (a *AuthLDAP) Type() string {
 
 // VerifyLogin validates a user/password combination and returns true or false accordingly
 func (a *AuthLDAP) VerifyLogin(username, password string) (bool, error) {
+	if _, err := a.VerifyLoginGroups(username, password); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// VerifyLoginGroups validates a user/password combination and returns the groups the user is member of
+func (a *AuthLDAP) VerifyLoginGroups(username, password string) ([]string, error) {
 	if a.tlsConfig == nil {
 		a.tlsConfig = &tls.Config{InsecureSkipVerify: a.TLSConfig.InsecureSkipVerify}
 	}
@@ -42,18 +52,21 @@ func (a *AuthLDAP) VerifyLogin(username, password string) (bool, error) {
 	case "TLS":
 		l, err = ldap.Dial("tcp", a.addr)
 		if err != nil {
-			return false, err
+			return nil, err
 		}
 		err = l.StartTLS(a.tlsConfig)
 		if err != nil {
-			return false, err
+			return nil, err
 		}
 	case "SSL":
 		l, err = ldap.DialTLS("tcp", a.addr, a.tlsConfig)
 		if err != nil {
-			return false, err
+			return nil, err
 		}
+	default:
+		return nil, fmt.Errorf("Unknown LDAP method: %s", a.Method)
 	}
+	defer l.Close()
 
 	// Bind using provided credentials
 	if a.Domain != "" {
@@ -62,14 +75,14 @@ func (a *AuthLDAP) VerifyLogin(username, password string) (bool, error) {
 		err = l.Bind(username, password)
 	}
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
 	if a.BindDN == "" {
-		return false, fmt.Errorf("Empty LDAP BindDN, cannot verify user exist in DN")
+		return nil, fmt.Errorf("Empty LDAP BindDN, cannot verify user exist in DN")
 	}
 	if a.Filter == "" {
-		return false, fmt.Errorf("Empty LDAP Filter, cannot verify user exist in Filter")
+		return nil, fmt.Errorf("Empty LDAP Filter, cannot verify user exist in Filter")
 	}
 
 	// Search user in Filter
@@ -78,22 +91,31 @@ func (a *AuthLDAP) VerifyLogin(username, password string) (bool, error) {
 		a.BindDN,
 		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
 		search,
-		[]string{"dn"},
+		[]string{"dn", a.groupAttribute()},
 		nil,
 	)
 
 	sr, err := l.Search(searchRequest)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
 	if len(sr.Entries) < 1 {
-		return false, fmt.Errorf("User does not exist")
+		return nil, fmt.Errorf("User does not exist")
 	}
 
 	if len(sr.Entries) > 1 {
-		return false, fmt.Errorf("Too many entries returned")
+		return nil, fmt.Errorf("Too many entries returned")
 	}
 
-	return true, nil
+	return sr.Entries[0].GetAttributeValues(a.groupAttribute()), nil
+}
+
+// groupAttribute returns the attribute of the user containing its groups
+func (a *AuthLDAP) groupAttribute() string {
+	if a.GroupAttribute == "" {
+		return "memberOf"
+	}
+
+	return a.GroupAttribute
 }
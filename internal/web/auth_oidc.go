@@ -0,0 +1,510 @@
+package web
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt"
+	"github.com/schubergphilis/mercury/pkg/tlsconfig"
+)
+
+// AuthOIDC is the provider for OpenID Connect based authentication for the web service
+// users login at the issuer using the authorization code flow with PKCE
+type AuthOIDC struct {
+	Issuer        string              `json:"issuer" toml:"issuer" yaml:"issuer"`                         // url of the issuer, used for discovery
+	ClientID      string              `json:"client_id" toml:"client_id" yaml:"client_id"`                // client id registered at the issuer
+	ClientSecret  string              `json:"client_secret" toml:"client_secret" yaml:"client_secret"`    // client secret, empty for public clients
+	RedirectURL   string              `json:"redirect_url" toml:"redirect_url" yaml:"redirect_url"`       // url of the login page the issuer redirects back to
+	Scopes        []string            `json:"scopes" toml:"scopes" yaml:"scopes"`                         // scopes to request
+	UsernameClaim string              `json:"username_claim" toml:"username_claim" yaml:"username_claim"` // claim containing the username
+	GroupsClaim   string              `json:"groups_claim" toml:"groups_claim" yaml:"groups_claim"`       // claim containing the groups, used to map roles
+	TLSConfig     tlsconfig.TLSConfig `json:"tls" toml:"tls" yaml:"tls"`
+	sync.Mutex
+	provider    *oidcProvider
+	keys        map[string]interface{}
+	keysFetched time.Time  // time the keys of the issuer were last requested
+	keysFetch   sync.Mutex // only one request for the keys at a time
+	client      *http.Client
+}
+
+// oidcKeysMinInterval is the minimum time between requests for the keys of the issuer, tokens with an unknown key within it are rejected
+var oidcKeysMinInterval = 1 * time.Minute
+
+// oidcProvider contains the endpoints found through discovery
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// OIDCLogin contains the secrets of a login in progress, to be kept until the issuer redirects back
+type OIDCLogin struct {
+	State    string
+	Nonce    string
+	Verifier string
+	Expire   time.Time
+}
+
+// OIDCLoginDuration is how long a user has to login at the issuer
+var OIDCLoginDuration = 10 * time.Minute
+
+// OIDCLoginCookie is the cookie keeping a login in progress in the browser that started it
+const OIDCLoginCookie = "mercury_oidc_login"
+
+// NewOIDCLogin returns a new login with random state, nonce and PKCE verifier
+func NewOIDCLogin() OIDCLogin {
+	return OIDCLogin{
+		State:    randomString(),
+		Nonce:    randomString(),
+		Verifier: randomString(),
+		Expire:   time.Now().Add(OIDCLoginDuration),
+	}
+}
+
+// Sign returns the login as a signed cookie value, valid until the login expires
+func (l OIDCLogin) Sign(key []byte) (string, error) {
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"typ":      "oidc_login",
+		"state":    l.State,
+		"nonce":    l.Nonce,
+		"verifier": l.Verifier,
+		"exp":      l.Expire.Unix(),
+	}).SignedString(key)
+}
+
+// ParseOIDCLogin returns the login of a signed cookie value, if it is valid and not expired
+func ParseOIDCLogin(value string, key []byte) (OIDCLogin, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(value, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+		}
+		return key, nil
+	})
+	if err != nil {
+		return OIDCLogin{}, err
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if claims["typ"] != "oidc_login" || !ok {
+		return OIDCLogin{}, fmt.Errorf("cookie is not an oidc login")
+	}
+
+	login := OIDCLogin{Expire: time.Unix(int64(exp), 0)}
+	login.State, _ = claims["state"].(string)
+	login.Nonce, _ = claims["nonce"].(string)
+	login.Verifier, _ = claims["verifier"].(string)
+	if login.State == "" || login.Verifier == "" {
+		return OIDCLogin{}, fmt.Errorf("oidc login is incomplete")
+	}
+
+	return login, nil
+}
+
+// Type is the authentication type
+func (a *AuthOIDC) Type() string {
+	return "OIDC"
+}
+
+// VerifyLogin is not supported, users login at the issuer
+func (a *AuthOIDC) VerifyLogin(username, password string) (bool, error) {
+	return false, fmt.Errorf("password login is not supported with OIDC, login through the web interface")
+}
+
+// Validate validates the OIDC settings
+func (a *AuthOIDC) Validate() error {
+	if a.Issuer == "" {
+		return fmt.Errorf("oidc requires an issuer")
+	}
+
+	if a.ClientID == "" {
+		return fmt.Errorf("oidc requires a client_id")
+	}
+
+	if _, err := url.ParseRequestURI(a.RedirectURL); err != nil {
+		return fmt.Errorf("oidc requires a valid redirect_url: %s", err)
+	}
+
+	if !contains(a.Scopes, "openid") {
+		return fmt.Errorf("oidc scopes require openid")
+	}
+
+	return nil
+}
+
+// AuthCodeURL returns the url to send the user to for login
+func (a *AuthOIDC) AuthCodeURL(login OIDCLogin) (string, error) {
+	provider, err := a.discover()
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(login.Verifier))
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", a.ClientID)
+	v.Set("redirect_uri", a.RedirectURL)
+	v.Set("scope", strings.Join(a.Scopes, " "))
+	v.Set("state", login.State)
+	v.Set("nonce", login.Nonce)
+	v.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	v.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(provider.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return provider.AuthorizationEndpoint + separator + v.Encode(), nil
+}
+
+// Exchange exchanges the authorization code for an id token, validates it, and returns the username and groups it contains
+func (a *AuthOIDC) Exchange(code string, login OIDCLogin) (string, []string, error) {
+	provider, err := a.discover()
+	if err != nil {
+		return "", nil, err
+	}
+
+	v := url.Values{}
+	v.Set("grant_type", "authorization_code")
+	v.Set("code", code)
+	v.Set("redirect_uri", a.RedirectURL)
+	v.Set("client_id", a.ClientID)
+	v.Set("code_verifier", login.Verifier)
+	req, err := http.NewRequest("POST", provider.TokenEndpoint, strings.NewReader(v.Encode()))
+	if err != nil {
+		return "", nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if a.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(a.ClientID), url.QueryEscape(a.ClientSecret))
+	}
+
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := a.getJSON(req, &token); err != nil && token.Error == "" {
+		return "", nil, fmt.Errorf("token request failed: %s", err)
+	}
+
+	if token.Error != "" {
+		return "", nil, fmt.Errorf("token request failed: %s %s", token.Error, token.ErrorDescription)
+	}
+
+	if token.IDToken == "" {
+		return "", nil, fmt.Errorf("token response contains no id_token")
+	}
+
+	claims, err := a.verifyIDToken(token.IDToken, login.Nonce)
+	if err != nil {
+		return "", nil, err
+	}
+
+	username, _ := claims[a.UsernameClaim].(string)
+	if username == "" {
+		username, _ = claims["sub"].(string)
+	}
+
+	if username == "" {
+		return "", nil, fmt.Errorf("id token contains no username in claim %s", a.UsernameClaim)
+	}
+
+	return username, claimStrings(claims[a.GroupsClaim]), nil
+}
+
+// verifyIDToken validates the signature and claims of an id token and returns its claims
+func (a *AuthOIDC) verifyIDToken(idToken, nonce string) (jwt.MapClaims, error) {
+	provider, err := a.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA, *jwt.SigningMethodRSAPSS:
+		default:
+			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+		}
+
+		kid, _ := token.Header["kid"].(string)
+		return a.key(kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid id token: %s", err)
+	}
+
+	if !claims.VerifyIssuer(provider.Issuer, true) {
+		return nil, fmt.Errorf("invalid id token: issuer %v does not match %s", claims["iss"], provider.Issuer)
+	}
+
+	if !claims.VerifyAudience(a.ClientID, true) {
+		return nil, fmt.Errorf("invalid id token: audience %v does not contain %s", claims["aud"], a.ClientID)
+	}
+
+	// a token for multiple audiences must be issued to us
+	if aud, ok := claims["aud"].([]interface{}); (ok && len(aud) > 1) || claims["azp"] != nil {
+		if azp, _ := claims["azp"].(string); azp != a.ClientID {
+			return nil, fmt.Errorf("invalid id token: authorized party %v is not %s", claims["azp"], a.ClientID)
+		}
+	}
+
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, fmt.Errorf("invalid id token: token expired")
+	}
+
+	if claims["nonce"] != nonce {
+		return nil, fmt.Errorf("invalid id token: nonce mismatch")
+	}
+
+	return claims, nil
+}
+
+// discover returns the endpoints of the issuer, discovering them on first use
+func (a *AuthOIDC) discover() (*oidcProvider, error) {
+	a.Lock()
+	provider := a.provider
+	a.Unlock()
+	if provider != nil {
+		return provider, nil
+	}
+
+	req, err := http.NewRequest("GET", strings.TrimSuffix(a.Issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	provider = &oidcProvider{}
+	if err := a.getJSON(req, provider); err != nil {
+		return nil, fmt.Errorf("oidc discovery failed: %s", err)
+	}
+
+	if strings.TrimSuffix(provider.Issuer, "/") != strings.TrimSuffix(a.Issuer, "/") {
+		return nil, fmt.Errorf("oidc discovery failed: issuer %s does not match %s", provider.Issuer, a.Issuer)
+	}
+
+	if provider.AuthorizationEndpoint == "" || provider.TokenEndpoint == "" || provider.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc discovery failed: issuer does not provide the required endpoints")
+	}
+
+	a.Lock()
+	a.provider = provider
+	a.Unlock()
+	return provider, nil
+}
+
+// key returns the signing key with kid, the keys of the issuer are (re)loaded if the key is unknown, so rotated keys are picked up
+// the keys are loaded at most once every oidcKeysMinInterval, so tokens with unknown keys can not flood the issuer
+func (a *AuthOIDC) key(kid string) (interface{}, error) {
+	a.Lock()
+	key, ok := lookupKey(a.keys, kid)
+	a.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	a.keysFetch.Lock()
+	defer a.keysFetch.Unlock()
+
+	// the keys may have been loaded while waiting
+	a.Lock()
+	key, ok = lookupKey(a.keys, kid)
+	fetched := a.keysFetched
+	a.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	if time.Since(fetched) < oidcKeysMinInterval {
+		return nil, fmt.Errorf("unknown signing key: %s", kid)
+	}
+
+	provider, err := a.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("GET", provider.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	a.Lock()
+	a.keysFetched = time.Now()
+	a.Unlock()
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := a.getJSON(req, &jwks); err != nil {
+		return nil, fmt.Errorf("unable to load issuer keys: %s", err)
+	}
+
+	keys := make(map[string]interface{})
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		if pub, err := k.publicKey(); err == nil {
+			keys[k.Kid] = pub
+		}
+	}
+
+	a.Lock()
+	a.keys = keys
+	a.Unlock()
+
+	if key, ok := lookupKey(keys, kid); ok {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("unknown signing key: %s", kid)
+}
+
+// lookupKey returns the key with kid
+// tokens without kid can be used if the issuer has only one key
+func lookupKey(keys map[string]interface{}, kid string) (interface{}, bool) {
+	if key, ok := keys[kid]; ok {
+		return key, true
+	}
+
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key, true
+		}
+	}
+
+	return nil, false
+}
+
+// getJSON does a request and decodes its json reply in to v
+func (a *AuthOIDC) getJSON(req *http.Request, v interface{}) error {
+	a.Lock()
+	if a.client == nil {
+		a.client = &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: a.TLSConfig.InsecureSkipVerify}},
+		}
+	}
+	client := a.client
+	a.Unlock()
+
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("invalid reply from %s (%s): %s", req.URL, resp.Status, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected reply from %s: %s", req.URL, resp.Status)
+	}
+
+	return nil
+}
+
+// jsonWebKey is a public key as published by the issuer
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey returns the rsa or ecdsa public key of the json web key
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve: %s", k.Crv)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type: %s", k.Kty)
+}
+
+// claimStrings returns a claim that is either a string or a list of strings as list
+func claimStrings(claim interface{}) (result []string) {
+	switch c := claim.(type) {
+	case string:
+		return []string{c}
+	case []interface{}:
+		for _, v := range c {
+			if s, ok := v.(string); ok {
+				result = append(result, s)
+			}
+		}
+	}
+
+	return
+}
+
+func randomString() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,265 @@
+package web
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt"
+)
+
+// testIssuer is a minimal OIDC issuer handing out id tokens for a single authorization code
+type testIssuer struct {
+	*httptest.Server
+	key       *rsa.PrivateKey
+	challenge string
+	nonce     string
+	audience  interface{}
+	azp       string
+	jwks      int32 // requests for the keys
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate issuer key: %s", err)
+	}
+
+	issuer := &testIssuer{key: key, audience: "mercury"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer.URL,
+			"authorization_endpoint": issuer.URL + "/authorize",
+			"token_endpoint":         issuer.URL + "/token",
+			"jwks_uri":               issuer.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&issuer.jwks, 1)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kid": "test",
+				"kty": "RSA",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		verifier := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if r.FormValue("code") != "code" || base64.RawURLEncoding.EncodeToString(verifier[:]) != issuer.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{"id_token": issuer.idToken(t, "test"), "access_token": "access"})
+	})
+	issuer.Server = httptest.NewServer(mux)
+	return issuer
+}
+
+// idToken returns an id token for alice, signed by the issuer with kid
+func (i *testIssuer) idToken(t *testing.T, kid string) string {
+	claims := jwt.MapClaims{
+		"iss":                i.URL,
+		"aud":                i.audience,
+		"sub":                "1234",
+		"exp":                time.Now().Add(time.Minute).Unix(),
+		"nonce":              i.nonce,
+		"preferred_username": "alice",
+		"groups":             []string{"webteam"},
+	}
+	if i.azp != "" {
+		claims["azp"] = i.azp
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	idToken, err := token.SignedString(i.key)
+	if err != nil {
+		t.Errorf("Failed to sign id token: %s", err)
+	}
+
+	return idToken
+}
+
+// authorize does what the issuer does when the user logs in: remember the challenge and nonce of the request
+func (i *testIssuer) authorize(t *testing.T, authURL string) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("Invalid authorization url %s: %s", authURL, err)
+	}
+
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("client_id") != "mercury" || q.Get("response_type") != "code" {
+		t.Errorf("Unexpected authorization url %s", authURL)
+	}
+	i.challenge = q.Get("code_challenge")
+	i.nonce = q.Get("nonce")
+}
+
+func TestAuthOIDC(t *testing.T) {
+	issuer := newTestIssuer(t)
+	defer issuer.Close()
+
+	oidc := &AuthOIDC{
+		Issuer:        issuer.URL,
+		ClientID:      "mercury",
+		RedirectURL:   "https://localhost:9001/login/",
+		Scopes:        []string{"openid"},
+		UsernameClaim: "preferred_username",
+		GroupsClaim:   "groups",
+	}
+	if err := oidc.Validate(); err != nil {
+		t.Fatalf("Expected oidc config to be valid: %s", err)
+	}
+
+	login := NewOIDCLogin()
+	authURL, err := oidc.AuthCodeURL(login)
+	if err != nil {
+		t.Fatalf("Failed to get authorization url: %s", err)
+	}
+	issuer.authorize(t, authURL)
+
+	username, groups, err := oidc.Exchange("code", login)
+	if err != nil {
+		t.Fatalf("Failed to exchange code: %s", err)
+	}
+
+	if username != "alice" || len(groups) != 1 || groups[0] != "webteam" {
+		t.Errorf("Expected user alice in group webteam, got %s %v", username, groups)
+	}
+
+	// the verifier proves the login was started by us
+	stolen := login
+	stolen.Verifier = "other"
+	if _, _, err := oidc.Exchange("code", stolen); err == nil {
+		t.Errorf("Expected exchange with wrong PKCE verifier to fail")
+	}
+
+	replayed := login
+	replayed.Nonce = "other"
+	if _, _, err := oidc.Exchange("code", replayed); err == nil {
+		t.Errorf("Expected exchange with wrong nonce to fail")
+	}
+
+	issuer.audience = "other-client"
+	if _, _, err := oidc.Exchange("code", login); err == nil {
+		t.Errorf("Expected id token for another client to be rejected")
+	}
+
+	// tokens for multiple audiences must be issued to us
+	issuer.audience = []string{"mercury", "other-client"}
+	if _, _, err := oidc.Exchange("code", login); err == nil {
+		t.Errorf("Expected id token for multiple audiences without authorized party to be rejected")
+	}
+
+	issuer.azp = "other-client"
+	if _, _, err := oidc.Exchange("code", login); err == nil {
+		t.Errorf("Expected id token issued to another client to be rejected")
+	}
+
+	issuer.azp = "mercury"
+	if _, _, err := oidc.Exchange("code", login); err != nil {
+		t.Errorf("Expected id token issued to us for multiple audiences to be accepted: %s", err)
+	}
+}
+
+func TestAuthOIDCKeys(t *testing.T) {
+	issuer := newTestIssuer(t)
+	defer issuer.Close()
+
+	oidc := &AuthOIDC{Issuer: issuer.URL, ClientID: "mercury", RedirectURL: "https://localhost:9001/login/", Scopes: []string{"openid"}}
+	issuer.nonce = "nonce"
+	if _, err := oidc.verifyIDToken(issuer.idToken(t, "test"), "nonce"); err != nil {
+		t.Fatalf("Expected id token to be valid: %s", err)
+	}
+
+	// tokens with unknown keys do not reload the keys every time
+	for i := 0; i < 5; i++ {
+		if _, err := oidc.verifyIDToken(issuer.idToken(t, "unknown"), "nonce"); err == nil {
+			t.Errorf("Expected id token with unknown key to be rejected")
+		}
+	}
+
+	if requests := atomic.LoadInt32(&issuer.jwks); requests != 1 {
+		t.Errorf("Expected keys to be requested once, got %d", requests)
+	}
+
+	// unknown keys reload the keys once the interval passed
+	oidc.Lock()
+	oidc.keysFetched = time.Now().Add(-oidcKeysMinInterval)
+	oidc.Unlock()
+	oidc.verifyIDToken(issuer.idToken(t, "unknown"), "nonce")
+	if requests := atomic.LoadInt32(&issuer.jwks); requests != 2 {
+		t.Errorf("Expected keys to be requested again, got %d requests", requests)
+	}
+
+	if _, err := oidc.verifyIDToken(issuer.idToken(t, "test"), "nonce"); err != nil {
+		t.Errorf("Expected id token with known key to be valid: %s", err)
+	}
+}
+
+func TestAuthOIDCValidate(t *testing.T) {
+	invalid := []*AuthOIDC{
+		{ClientID: "mercury", RedirectURL: "https://localhost/login/", Scopes: []string{"openid"}},
+		{Issuer: "https://issuer", RedirectURL: "https://localhost/login/", Scopes: []string{"openid"}},
+		{Issuer: "https://issuer", ClientID: "mercury", Scopes: []string{"openid"}},
+		{Issuer: "https://issuer", ClientID: "mercury", RedirectURL: "https://localhost/login/", Scopes: []string{"profile"}},
+	}
+
+	for _, oidc := range invalid {
+		if err := oidc.Validate(); err == nil {
+			t.Errorf("Expected oidc config to be invalid: issuer:%s client_id:%s redirect_url:%s scopes:%v", oidc.Issuer, oidc.ClientID, oidc.RedirectURL, oidc.Scopes)
+		}
+	}
+}
+
+func TestOIDCLoginCookie(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	login := NewOIDCLogin()
+	value, err := login.Sign(key)
+	if err != nil {
+		t.Fatalf("Failed to sign login: %s", err)
+	}
+
+	parsed, err := ParseOIDCLogin(value, key)
+	if err != nil {
+		t.Fatalf("Failed to parse login: %s", err)
+	}
+
+	if parsed.State != login.State || parsed.Nonce != login.Nonce || parsed.Verifier != login.Verifier || parsed.Expire.Unix() != login.Expire.Unix() {
+		t.Errorf("Expected login %+v, got %+v", login, parsed)
+	}
+
+	if _, err := ParseOIDCLogin(value, []byte("another key of at least 32 bytes")); err == nil {
+		t.Errorf("Expected login signed with another key to be rejected")
+	}
+
+	expired := NewOIDCLogin()
+	expired.Expire = time.Now().Add(-time.Minute)
+	if value, err = expired.Sign(key); err != nil {
+		t.Fatalf("Failed to sign login: %s", err)
+	}
+	if _, err := ParseOIDCLogin(value, key); err == nil {
+		t.Errorf("Expected expired login to be rejected")
+	}
+
+	// other tokens signed with the same key are not a login
+	other, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"state": "x", "verifier": "y", "exp": time.Now().Add(time.Minute).Unix()}).SignedString(key)
+	if _, err := ParseOIDCLogin(other, key); err == nil {
+		t.Errorf("Expected token without login type to be rejected")
+	}
+}
@@ -0,0 +1,212 @@
+package web
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Role defines what a user is allowed to do in the web interface and api
+type Role string
+
+const (
+	// RoleNone gives no access
+	RoleNone Role = "none"
+	// RoleViewer can view all details
+	RoleViewer Role = "viewer"
+	// RoleOperator can change the status of nodes and manage maintenance windows
+	RoleOperator Role = "operator"
+	// RoleAdmin can do everything
+	RoleAdmin Role = "admin"
+)
+
+var roleLevel = map[Role]int{
+	RoleNone:     0,
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+// Valid returns true if the role is known
+func (r Role) Valid() bool {
+	_, ok := roleLevel[r]
+	return ok
+}
+
+// Includes returns true if the role has atleast the rights of role o
+func (r Role) Includes(o Role) bool {
+	return roleLevel[r] > 0 && roleLevel[r] >= roleLevel[o]
+}
+
+// RoleBinding grants a role to users and groups, optionally limited to specific pools and backends
+type RoleBinding struct {
+	Role     Role     `json:"role" toml:"role" yaml:"role"`             // role to grant
+	Users    []string `json:"users" toml:"users" yaml:"users"`          // usernames to grant the role to
+	Groups   []string `json:"groups" toml:"groups" yaml:"groups"`       // ldap (dn or cn) or oidc groups to grant the role to
+	Pools    []string `json:"pools" toml:"pools" yaml:"pools"`          // pools the role applies to, all if empty
+	Backends []string `json:"backends" toml:"backends" yaml:"backends"` // backends the role applies to, all if empty
+}
+
+// Validate validates the role binding
+func (b RoleBinding) Validate() error {
+	if !b.Role.Valid() || b.Role == RoleNone {
+		return fmt.Errorf("unknown role: %s", b.Role)
+	}
+
+	if len(b.Users) == 0 && len(b.Groups) == 0 {
+		return fmt.Errorf("role %s is not bound to any users or groups", b.Role)
+	}
+
+	return nil
+}
+
+// matches returns true if the binding applies to the user or any of its groups
+func (b RoleBinding) matches(username string, groups []string) bool {
+	for _, user := range b.Users {
+		if user == username {
+			return true
+		}
+	}
+
+	for _, group := range b.Groups {
+		for _, member := range groups {
+			if strings.EqualFold(group, member) || strings.EqualFold(group, groupCN(member)) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// groupCN returns the common name of a group dn, e.g. cn=admins,ou=groups,dc=example,dc=com returns admins
+func groupCN(dn string) string {
+	rdn := strings.SplitN(dn, ",", 2)[0]
+	if kv := strings.SplitN(rdn, "=", 2); len(kv) == 2 && strings.EqualFold(strings.TrimSpace(kv[0]), "cn") {
+		return strings.TrimSpace(kv[1])
+	}
+
+	return dn
+}
+
+// Grant is a role given to a user, optionally limited to specific pools and backends
+type Grant struct {
+	Role     Role     `json:"role"`
+	Pools    []string `json:"pools,omitempty"`
+	Backends []string `json:"backends,omitempty"`
+}
+
+// allows returns true if the grant gives role on the pool and backend
+// an empty backend refers to the whole pool, which requires a grant that is not limited to specific backends
+func (g Grant) allows(role Role, pool, backend string) bool {
+	if !g.Role.Includes(role) {
+		return false
+	}
+
+	if len(g.Pools) > 0 && !contains(g.Pools, pool) {
+		return false
+	}
+
+	if len(g.Backends) > 0 && (backend == "" || !contains(g.Backends, backend)) {
+		return false
+	}
+
+	return true
+}
+
+// Grants are all roles given to a user
+type Grants []Grant
+
+// Allowed returns true if any of the grants gives role on the pool and backend
+func (g Grants) Allowed(role Role, pool, backend string) bool {
+	for _, grant := range g {
+		if grant.allows(role, pool, backend) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AllowedAny returns true if any of the grants gives role, regardless of the pools and backends it applies to
+func (g Grants) AllowedAny(role Role) bool {
+	for _, grant := range g {
+		if grant.Role.Includes(role) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Covers returns true if every grant of o is given by one of the grants, with the same or a higher role
+// on the same or fewer pools and backends
+func (g Grants) Covers(o Grants) bool {
+	for _, grant := range o {
+		covered := false
+		for _, own := range g {
+			if own.covers(grant) {
+				covered = true
+				break
+			}
+		}
+
+		if !covered {
+			return false
+		}
+	}
+
+	return true
+}
+
+// covers returns true if the grant gives atleast the rights of grant o
+func (g Grant) covers(o Grant) bool {
+	if !g.Role.Includes(o.Role) {
+		return false
+	}
+
+	if len(g.Pools) > 0 && (len(o.Pools) == 0 || !containsAll(g.Pools, o.Pools)) {
+		return false
+	}
+
+	if len(g.Backends) > 0 && (len(o.Backends) == 0 || !containsAll(g.Backends, o.Backends)) {
+		return false
+	}
+
+	return true
+}
+
+// Grants returns the roles of a user based on its username and groups
+// the default role applies to all users, role bindings add to this
+func (a AuthConfig) Grants(username string, groups []string) (grants Grants) {
+	if a.DefaultRole != RoleNone && a.DefaultRole != "" {
+		grants = append(grants, Grant{Role: a.DefaultRole})
+	}
+
+	for _, binding := range a.Roles {
+		if binding.matches(username, groups) {
+			grants = append(grants, Grant{Role: binding.Role, Pools: binding.Pools, Backends: binding.Backends})
+		}
+	}
+
+	return
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsAll(list []string, items []string) bool {
+	for _, item := range items {
+		if !contains(list, item) {
+			return false
+		}
+	}
+
+	return true
+}
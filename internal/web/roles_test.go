@@ -0,0 +1,91 @@
+package web
+
+import (
+	"testing"
+)
+
+func TestGrants(t *testing.T) {
+	auth := AuthConfig{
+		DefaultRole: RoleViewer,
+		Roles: []RoleBinding{
+			{Role: RoleOperator, Groups: []string{"webteam"}, Pools: []string{"pool1"}, Backends: []string{"web"}},
+			{Role: RoleAdmin, Users: []string{"alice"}},
+		},
+	}
+
+	checks := []struct {
+		username string
+		groups   []string
+		role     Role
+		pool     string
+		backend  string
+		allowed  bool
+	}{
+		{"bob", nil, RoleViewer, "pool1", "web", true},
+		{"bob", nil, RoleOperator, "pool1", "web", false},
+		{"bob", []string{"cn=webteam,ou=groups,dc=example,dc=com"}, RoleOperator, "pool1", "web", true},
+		{"bob", []string{"WEBTEAM"}, RoleOperator, "pool1", "web", true},
+		{"bob", []string{"webteam"}, RoleOperator, "pool1", "api", false},
+		{"bob", []string{"webteam"}, RoleOperator, "pool2", "web", false},
+		{"bob", []string{"webteam"}, RoleOperator, "pool1", "", false},
+		{"bob", []string{"webteam"}, RoleAdmin, "pool1", "web", false},
+		{"alice", nil, RoleAdmin, "pool2", "", true},
+	}
+
+	for _, c := range checks {
+		grants := auth.Grants(c.username, c.groups)
+		if allowed := grants.Allowed(c.role, c.pool, c.backend); allowed != c.allowed {
+			t.Errorf("User %s with groups %v allowed %s on %s/%s: %t expected %t", c.username, c.groups, c.role, c.pool, c.backend, allowed, c.allowed)
+		}
+	}
+
+	none := AuthConfig{DefaultRole: RoleNone}
+	if none.Grants("bob", nil).AllowedAny(RoleViewer) {
+		t.Errorf("Expected user without roles to have no access")
+	}
+}
+
+func TestGrantsCovers(t *testing.T) {
+	scoped := Grants{{Role: RoleAdmin, Pools: []string{"pool1"}}, {Role: RoleViewer}}
+	checks := []struct {
+		grants  Grants
+		covered bool
+	}{
+		{Grants{{Role: RoleAdmin, Pools: []string{"pool1"}}}, true},
+		{Grants{{Role: RoleOperator, Pools: []string{"pool1"}, Backends: []string{"web"}}}, true},
+		{Grants{{Role: RoleViewer}}, true},
+		{Grants{{Role: RoleAdmin}}, false},
+		{Grants{{Role: RoleOperator}}, false},
+		{Grants{{Role: RoleAdmin, Pools: []string{"pool1", "pool2"}}}, false},
+		{Grants{{Role: RoleViewer}, {Role: RoleAdmin, Pools: []string{"pool2"}}}, false},
+	}
+
+	for _, c := range checks {
+		if covered := scoped.Covers(c.grants); covered != c.covered {
+			t.Errorf("Grants %v covered by %v: %t expected %t", c.grants, scoped, covered, c.covered)
+		}
+	}
+
+	backend := Grants{{Role: RoleAdmin, Pools: []string{"pool1"}, Backends: []string{"web"}}}
+	if backend.Covers(Grants{{Role: RoleAdmin, Pools: []string{"pool1"}}}) {
+		t.Errorf("Expected a grant on a backend not to cover the whole pool")
+	}
+}
+
+func TestRoleBindingValidate(t *testing.T) {
+	invalid := []RoleBinding{
+		{Role: "superuser", Users: []string{"alice"}},
+		{Role: RoleNone, Users: []string{"alice"}},
+		{Role: RoleAdmin},
+	}
+
+	for _, binding := range invalid {
+		if err := binding.Validate(); err == nil {
+			t.Errorf("Expected role binding %+v to be invalid", binding)
+		}
+	}
+
+	if err := (RoleBinding{Role: RoleOperator, Groups: []string{"ops"}}).Validate(); err != nil {
+		t.Errorf("Expected role binding to be valid: %s", err)
+	}
+}
@@ -0,0 +1,229 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// APIToken is a long lived token for automation, with its own roles
+type APIToken struct {
+	ID        string    `json:"id"`                // unique id of the token, stored in the token itself
+	Name      string    `json:"name"`              // name of the token
+	Grants    Grants    `json:"roles"`             // roles of the token
+	CreatedBy string    `json:"created_by"`        // user who created the token
+	Created   time.Time `json:"created"`           // time the token was created
+	Expire    time.Time `json:"expire"`            // time the token expires, never if zero
+	Revoked   time.Time `json:"revoked,omitempty"` // time the token was revoked, not revoked if zero
+}
+
+var tokenName = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// Validate validates a new token
+func (t APIToken) Validate() error {
+	if !tokenName.MatchString(t.Name) {
+		return fmt.Errorf("token name must consist of letters, digits, '_', '.' or '-'")
+	}
+
+	if len(t.Grants) == 0 {
+		return fmt.Errorf("token %s requires atleast one role", t.Name)
+	}
+
+	for _, grant := range t.Grants {
+		if !grant.Role.Valid() || grant.Role == RoleNone {
+			return fmt.Errorf("token %s has unknown role: %s", t.Name, grant.Role)
+		}
+	}
+
+	if !t.Expire.IsZero() && t.Expire.Before(time.Now()) {
+		return fmt.Errorf("token %s expires in the past", t.Name)
+	}
+
+	return nil
+}
+
+// Active returns an error if the token can not be used at time now
+func (t APIToken) Active(now time.Time) error {
+	if !t.Revoked.IsZero() {
+		return fmt.Errorf("token %s is revoked", t.Name)
+	}
+
+	if !t.Expire.IsZero() && now.After(t.Expire) {
+		return fmt.Errorf("token %s expired", t.Name)
+	}
+
+	return nil
+}
+
+// TokenStore keeps the api tokens, and persists them to disk if a file is set
+type TokenStore struct {
+	sync.RWMutex
+	file   string
+	tokens map[string]APIToken
+}
+
+// NewTokenStore returns a token store, loading the tokens from file if it exists
+func NewTokenStore(file string) (*TokenStore, error) {
+	s := &TokenStore{
+		file:   file,
+		tokens: make(map[string]APIToken),
+	}
+
+	if file == "" {
+		return s, nil
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+
+	if err != nil {
+		return s, fmt.Errorf("unable to read token file: %s", err)
+	}
+
+	var tokens []APIToken
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return s, fmt.Errorf("unable to parse token file: %s", err)
+	}
+
+	for _, token := range tokens {
+		s.tokens[token.ID] = token
+	}
+
+	return s, nil
+}
+
+// Get returns the token with id
+func (s *TokenStore) Get(id string) (APIToken, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	token, ok := s.tokens[id]
+	return token, ok
+}
+
+// Tokens returns all tokens ordered by name
+func (s *TokenStore) Tokens() (tokens []APIToken) {
+	s.RLock()
+	defer s.RUnlock()
+	for _, token := range s.tokens {
+		tokens = append(tokens, token)
+	}
+
+	sort.Slice(tokens, func(i, j int) bool {
+		if tokens[i].Name == tokens[j].Name {
+			return tokens[i].Created.Before(tokens[j].Created)
+		}
+		return tokens[i].Name < tokens[j].Name
+	})
+
+	return
+}
+
+// Add adds a new token, the name must be unique among the tokens that are not revoked
+func (s *TokenStore) Add(token APIToken) error {
+	if err := token.Validate(); err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	s.prune(time.Now())
+	for _, existing := range s.tokens {
+		if existing.Name == token.Name && existing.Active(time.Now()) == nil {
+			return fmt.Errorf("token %s already exists", token.Name)
+		}
+	}
+
+	s.tokens[token.ID] = token
+	return s.save()
+}
+
+// Find returns the active token with name
+func (s *TokenStore) Find(name string) (APIToken, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	now := time.Now()
+	for _, token := range s.tokens {
+		if token.Name == name && token.Active(now) == nil {
+			return token, true
+		}
+	}
+
+	return APIToken{}, false
+}
+
+// Revoke revokes the active token with name, and returns it
+func (s *TokenStore) Revoke(name string) (APIToken, error) {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	for id, token := range s.tokens {
+		if token.Name == name && token.Active(now) == nil {
+			token.Revoked = now
+			s.tokens[id] = token
+			return token, s.save()
+		}
+	}
+
+	return APIToken{}, fmt.Errorf("unknown token: %s", name)
+}
+
+// Merge adds or updates a token received from a cluster node, a revoked token stays revoked
+// returns true if the store changed
+func (s *TokenStore) Merge(token APIToken) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	if !token.Expire.IsZero() && token.Expire.Before(time.Now()) {
+		return false, nil
+	}
+
+	if existing, ok := s.tokens[token.ID]; ok && (!existing.Revoked.IsZero() || token.Revoked.IsZero()) {
+		return false, nil
+	}
+
+	s.tokens[token.ID] = token
+	return true, s.save()
+}
+
+// prune removes tokens that expired before time t, they can not be used anymore
+func (s *TokenStore) prune(t time.Time) {
+	for id, token := range s.tokens {
+		if !token.Expire.IsZero() && token.Expire.Before(t) {
+			delete(s.tokens, id)
+		}
+	}
+}
+
+// save writes the tokens to file, replacing it atomically
+func (s *TokenStore) save() error {
+	if s.file == "" {
+		return nil
+	}
+
+	var tokens []APIToken
+	for _, token := range s.tokens {
+		tokens = append(tokens, token)
+	}
+
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := s.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("unable to write token file: %s", err)
+	}
+
+	if err := os.Rename(tmp, s.file); err != nil {
+		return fmt.Errorf("unable to write token file: %s", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package web
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTokenStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tokens")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "tokens.json")
+	store, err := NewTokenStore(file)
+	if err != nil {
+		t.Fatalf("Failed to create token store: %s", err)
+	}
+
+	token := APIToken{ID: "1", Name: "deploy", Grants: Grants{{Role: RoleOperator, Pools: []string{"pool1"}}}, Created: time.Now()}
+	if err := store.Add(token); err != nil {
+		t.Fatalf("Failed to add token: %s", err)
+	}
+
+	if err := store.Add(APIToken{ID: "2", Name: "deploy", Grants: token.Grants}); err == nil {
+		t.Errorf("Expected duplicate token name to fail")
+	}
+
+	invalid := []APIToken{
+		{ID: "3", Name: "with space", Grants: token.Grants},
+		{ID: "3", Name: "noroles"},
+		{ID: "3", Name: "badrole", Grants: Grants{{Role: "superuser"}}},
+		{ID: "3", Name: "expired", Grants: token.Grants, Expire: time.Now().Add(-time.Minute)},
+	}
+	for _, i := range invalid {
+		if err := store.Add(i); err == nil {
+			t.Errorf("Expected token %s to be invalid", i.Name)
+		}
+	}
+
+	// tokens survive a restart
+	store, err = NewTokenStore(file)
+	if err != nil {
+		t.Fatalf("Failed to load token store: %s", err)
+	}
+
+	loaded, ok := store.Get("1")
+	if !ok || loaded.Active(time.Now()) != nil || !loaded.Grants.Allowed(RoleOperator, "pool1", "") {
+		t.Fatalf("Expected active token after reload, got %+v", loaded)
+	}
+
+	revoked, err := store.Revoke("deploy")
+	if err != nil {
+		t.Fatalf("Failed to revoke token: %s", err)
+	}
+
+	if loaded, _ := store.Get("1"); loaded.Active(time.Now()) == nil {
+		t.Errorf("Expected revoked token to be inactive")
+	}
+
+	// a revoked token stays revoked when an older copy is received from a peer
+	if changed, _ := store.Merge(token); changed {
+		t.Errorf("Expected active copy of a revoked token to be ignored")
+	}
+
+	other, _ := NewTokenStore("")
+	other.Merge(token)
+	if changed, _ := other.Merge(revoked); !changed {
+		t.Errorf("Expected revocation from a peer to be applied")
+	}
+
+	if loaded, _ := other.Get("1"); loaded.Active(time.Now()) == nil {
+		t.Errorf("Expected token revoked by a peer to be inactive")
+	}
+}
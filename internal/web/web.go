@@ -22,8 +22,13 @@ type Config struct {
 
 // AuthConfig contains the authentication configuration for web interface
 type AuthConfig struct {
-	Password *AuthPassword `json:"password" toml:"password" yaml:"password"`
-	LDAP     *AuthLDAP     `json:"ldap" toml:"ldap" yaml:"ldap"`
+	Password    *AuthPassword `json:"password" toml:"password" yaml:"password"`
+	LDAP        *AuthLDAP     `json:"ldap" toml:"ldap" yaml:"ldap"`
+	OIDC        *AuthOIDC     `json:"oidc" toml:"oidc" yaml:"oidc"`
+	Roles       []RoleBinding `json:"roles" toml:"roles" yaml:"roles"`                      // roles granted to users and groups
+	DefaultRole Role          `json:"default_role" toml:"default_role" yaml:"default_role"` // role of all authenticated users
+	SigningKey  string        `json:"-" toml:"signing_key" yaml:"signing_key"`              // key to sign login and api tokens, must be equal on all cluster nodes
+	TokenFile   string        `json:"token_file" toml:"token_file" yaml:"token_file"`       // file to persist api tokens in
 }
 
 // Page data
@@ -0,0 +1,220 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HistorySize is the number of entries kept in memory
+var HistorySize = 1000
+
+// Config contains the audit log settings
+type Config struct {
+	File   string `toml:"file" json:"file"`     // file to append the audit log to, disabled if empty
+	Syslog bool   `toml:"syslog" json:"syslog"` // also send the audit log to syslog
+}
+
+// Entry is a single administrative action
+type Entry struct {
+	ID       string    `json:"id"`               // unique id of the entry
+	Time     time.Time `json:"time"`             // time of the action
+	Node     string    `json:"node"`             // cluster node the action was performed on
+	User     string    `json:"user"`             // user performing the action
+	SourceIP string    `json:"sourceip"`         // ip of the user performing the action
+	Action   string    `json:"action"`           // action performed, e.g. healthcheck.status
+	Target   string    `json:"target"`           // target of the action, e.g. the healthcheck uuid
+	Before   string    `json:"before,omitempty"` // state before the action
+	After    string    `json:"after,omitempty"`  // state after the action
+	Error    string    `json:"error,omitempty"`  // error if the action failed
+}
+
+// String returns the entry in a human readable format
+func (e Entry) String() string {
+	s := fmt.Sprintf("node=%q user=%q sourceip=%q action=%q target=%q before=%q after=%q", e.Node, e.User, e.SourceIP, e.Action, e.Target, e.Before, e.After)
+	if e.Error != "" {
+		s += fmt.Sprintf(" error=%q", e.Error)
+	}
+
+	return s
+}
+
+// Log is an append-only audit log
+type Log struct {
+	sync.RWMutex
+	config  Config
+	file    *os.File
+	syslog  *syslog.Writer
+	entries []Entry
+	ids     map[string]bool
+}
+
+// New returns a new audit log, loading the most recent entries of an existing log file
+func New(config Config) (*Log, error) {
+	l := &Log{
+		ids: make(map[string]bool),
+	}
+
+	if config.File != "" {
+		if err := l.load(config.File); err != nil {
+			return l, err
+		}
+	}
+
+	return l, l.Configure(config)
+}
+
+// Configure (re)opens the audit log file and syslog, so a rotated file is picked up
+func (l *Log) Configure(config Config) error {
+	l.Lock()
+	defer l.Unlock()
+	l.close()
+	l.config = config
+
+	if config.Syslog {
+		w, err := syslog.New(syslog.LOG_AUTH|syslog.LOG_NOTICE, "mercury-audit")
+		if err != nil {
+			return fmt.Errorf("unable to open syslog for audit log: %s", err)
+		}
+		l.syslog = w
+	}
+
+	if config.File != "" {
+		f, err := os.OpenFile(config.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return fmt.Errorf("unable to open audit log: %s", err)
+		}
+		l.file = f
+	}
+
+	return nil
+}
+
+// Close closes the audit log file and syslog
+func (l *Log) Close() {
+	l.Lock()
+	defer l.Unlock()
+	l.close()
+}
+
+func (l *Log) close() {
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+
+	if l.syslog != nil {
+		l.syslog.Close()
+		l.syslog = nil
+	}
+}
+
+// load reads the most recent entries from an existing audit log file
+func (l *Log) load(file string) error {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("unable to read audit log: %s", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		entry := Entry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// skip lines we cannot parse, the file is append-only and we should not stop on a single bad line
+			continue
+		}
+		l.remember(entry)
+	}
+
+	return scanner.Err()
+}
+
+// Add records a new entry, assigning an id and time if it has none
+// entries already known, or older than the history we keep, are ignored, so entries received from peers can be added safely
+func (l *Log) Add(entry Entry) (Entry, error) {
+	if entry.ID == "" {
+		entry.ID = newID()
+	}
+
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	if l.ids[entry.ID] {
+		return entry, nil
+	}
+
+	if len(l.entries) >= HistorySize && len(l.entries) > 0 && entry.Time.Before(l.entries[0].Time) {
+		return entry, nil
+	}
+
+	l.remember(entry)
+
+	var err error
+	if l.file != nil {
+		data, jerr := json.Marshal(entry)
+		if jerr != nil {
+			return entry, jerr
+		}
+
+		if _, err = l.file.Write(append(data, '\n')); err != nil {
+			err = fmt.Errorf("unable to write audit log: %s", err)
+		}
+	}
+
+	if l.syslog != nil {
+		if serr := l.syslog.Notice(entry.String()); serr != nil && err == nil {
+			err = fmt.Errorf("unable to write audit log to syslog: %s", serr)
+		}
+	}
+
+	return entry, err
+}
+
+// remember adds the entry to the in memory history, ordered by time
+func (l *Log) remember(entry Entry) {
+	if l.ids[entry.ID] {
+		return
+	}
+
+	l.ids[entry.ID] = true
+	i := sort.Search(len(l.entries), func(i int) bool { return l.entries[i].Time.After(entry.Time) })
+	l.entries = append(l.entries, Entry{})
+	copy(l.entries[i+1:], l.entries[i:])
+	l.entries[i] = entry
+
+	for len(l.entries) > HistorySize {
+		delete(l.ids, l.entries[0].ID)
+		l.entries = l.entries[1:]
+	}
+}
+
+// Entries returns the entries in memory, oldest first
+func (l *Log) Entries() []Entry {
+	l.RLock()
+	defer l.RUnlock()
+	entries := make([]Entry, len(l.entries))
+	copy(entries, l.entries)
+	return entries
+}
+
+func newID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,83 @@
+package audit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "audit.log")
+	l, err := New(Config{File: file})
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %s", err)
+	}
+
+	entry, err := l.Add(Entry{User: "alice", SourceIP: "127.0.0.1", Action: "healthcheck.status", Target: "uuid", Before: "automatic", After: "offline"})
+	if err != nil {
+		t.Fatalf("Failed to add audit entry: %s", err)
+	}
+
+	if entry.ID == "" || entry.Time.IsZero() {
+		t.Errorf("Expected entry to get an id and time, got %+v", entry)
+	}
+
+	// entries mirrored from peers may arrive more than once
+	if _, err := l.Add(entry); err != nil {
+		t.Fatalf("Failed to add duplicate audit entry: %s", err)
+	}
+
+	older := Entry{ID: "peer", Time: entry.Time.Add(-time.Minute), User: "bob", Action: "config.reload"}
+	if _, err := l.Add(older); err != nil {
+		t.Fatalf("Failed to add audit entry: %s", err)
+	}
+	l.Close()
+
+	reopened, err := New(Config{File: file})
+	if err != nil {
+		t.Fatalf("Failed to reopen audit log: %s", err)
+	}
+	defer reopened.Close()
+
+	entries := reopened.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries after reopening the audit log, got %d", len(entries))
+	}
+
+	if entries[0].ID != "peer" || entries[1].ID != entry.ID || entries[1].After != "offline" {
+		t.Errorf("Expected entries ordered by time, got %+v", entries)
+	}
+}
+
+func TestAuditLogHistorySize(t *testing.T) {
+	size := HistorySize
+	HistorySize = 2
+	defer func() { HistorySize = size }()
+
+	l, err := New(Config{})
+	if err != nil {
+		t.Fatalf("Failed to create audit log: %s", err)
+	}
+
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		l.Add(Entry{Time: now.Add(time.Duration(i) * time.Second), Action: "test"})
+	}
+
+	if _, err := l.Add(Entry{Time: now.Add(-time.Hour), Action: "old"}); err != nil {
+		t.Fatalf("Failed to add audit entry: %s", err)
+	}
+
+	entries := l.Entries()
+	if len(entries) != 2 || !entries[0].Time.Equal(now.Add(time.Second)) {
+		t.Errorf("Expected the 2 most recent entries, got %+v", entries)
+	}
+}
@@ -19,7 +19,9 @@ type Statistics struct {
 	TimeCounter       chan bool `json:"-"`         // counts the elements
 	TimeTimer         int       `json:"timetimer"` // time to keep elements
 	ResponseTimeValue []float64 `json:"responsetimevalue"`
-	Weighted          int       `json:"weighted"` // weighted value
+	Weighted          int       `json:"weighted"`      // weighted value
+	CompressedIn      int64     `json:"compressedin"`  // size of compressed replies before compression
+	CompressedOut     int64     `json:"compressedout"` // size of compressed replies after compression
 }
 
 // NewStatistics returns new statistics
@@ -41,6 +43,8 @@ func (s *Statistics) Reset() {
 	s.ClientsConnected = 0
 	s.RX = 0
 	s.TX = 0
+	s.CompressedIn = 0
+	s.CompressedOut = 0
 	s.ResponseTimeValue = []float64{}
 	// TODO: how to reset TimeCounter ? and do we need to since it expires in 30 seconds anyway
 }
@@ -101,11 +105,19 @@ func (s *Statistics) TXAdd(tx int64) {
 	s.TX += tx
 }
 
-// SetWeighted adds a weight to the counter
+// CompressionAdd adds the size of a compressed reply before and after compression
+func (s *Statistics) CompressionAdd(in, out int64) {
+	s.Lock()
+	defer s.Unlock()
+	s.CompressedIn += in
+	s.CompressedOut += out
+}
+
+// SetWeighted sets the weight of the counter
 func (s *Statistics) SetWeighted(w int) {
 	s.Lock()
 	defer s.Unlock()
-	s.Weighted += w
+	s.Weighted = w
 }
 
 // TimeCounterAdd counter for entriest per X seconds
@@ -231,6 +243,23 @@ func (s *Statistics) TXGet() int64 {
 	return s.TX
 }
 
+// CompressionGet returns the size of the compressed replies before and after compression
+func (s *Statistics) CompressionGet() (int64, int64) {
+	s.RLock()
+	defer s.RUnlock()
+	return s.CompressedIn, s.CompressedOut
+}
+
+// CompressionRatioGet returns the compression ratio of the compressed replies, the size before divided by the size after
+func (s *Statistics) CompressionRatioGet() float64 {
+	s.RLock()
+	defer s.RUnlock()
+	if s.CompressedOut == 0 {
+		return 0
+	}
+	return toFixed(float64(s.CompressedIn)/float64(s.CompressedOut), 2)
+}
+
 // ResponseTimeValueGet returns the responsetime values
 func (s *Statistics) ResponseTimeValueGet() []float64 {
 	s.RLock()
@@ -1,6 +1,7 @@
 package cluster
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/json"
 	"fmt"
@@ -33,12 +34,16 @@ type apiMessage struct {
 
 // APIRequest is used to pass requests done to the cluster API to the client application
 type APIRequest struct {
-	Action  string `json:"action"`
-	Manager string `json:"manager"`
-	Node    string `json:"node"`
-	Data    string `json:"data"`
+	Action     string `json:"action"`
+	Manager    string `json:"manager"`
+	Node       string `json:"node"`
+	Data       string `json:"data"`
+	User       string `json:"user"`       // authenticated user doing the request
+	RemoteAddr string `json:"remoteaddr"` // address the request came from
 }
 
+type apiUsernameContextKey struct{}
+
 func rndKey() []byte {
 	token := make([]byte, 128)
 	rand.Read(token)
@@ -62,12 +67,14 @@ func (h apiAuthentication) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 
 		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-			if time.Now().Unix() > int64(claims["expire"].(float64)) {
+			expire, _ := claims["expire"].(float64)
+			if time.Now().Unix() > int64(expire) {
 				apiWriteData(w, 403, apiMessage{Success: false, Error: "Token expired"})
 				return
 			}
 
-			h.wrappedHandler.ServeHTTP(w, r)
+			username, _ := claims["username"].(string)
+			h.wrappedHandler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiUsernameContextKey{}, username)))
 		} else {
 			apiWriteData(w, 403, apiMessage{Success: false, Error: err.Error()})
 			return
@@ -18,6 +18,8 @@ type apiClusterAdminHandler struct {
 	 	reconnect - reconnect to a node (disconnect, as it reconnects on timeout)
 	 	admindown - disconnect a node, and do not reconnect for duration
 	 	reload - reload config - passed on to client application
+
+		all requests are passed on to the client application through FromClusterAPI, so it can act on or audit them
 */
 
 func (h apiClusterAdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -27,6 +29,7 @@ func (h apiClusterAdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request
 		return
 	}
 	node, action := path[2], path[3]
-	h.manager.internalMessage <- internalMessage{Type: "api" + action, Node: node}
+	username, _ := r.Context().Value(apiUsernameContextKey{}).(string)
+	h.manager.apiRequest <- APIRequest{Action: action, Manager: h.manager.name, Node: node, User: username, RemoteAddr: r.RemoteAddr}
 	apiWriteData(w, 200, apiMessage{Success: true, Data: action + " OK"})
 }
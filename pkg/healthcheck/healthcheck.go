@@ -16,6 +16,8 @@ type Manager struct {
 	Workers         []*Worker               `json:"workers" toml:"workers"`
 	HealthStatusMap map[string]HealthStatus `json:"healthstatusmap" toml:"healthstatusmap"` // keeps the health of all items
 	HealthPoolMap   map[string]HealthPool   `json:"healthpoolmap" toml:"healthpoolmap"`     // keeps a list of uuids and what checks apply to them
+	WorkerHistory   map[string]*History     `json:"-" toml:"-"`                             // keeps the state transitions of each worker
+	NodeHistory     map[string]*History     `json:"-" toml:"-"`                             // keeps the state transitions of each node per cluster node
 
 	Worker sync.RWMutex
 }
@@ -31,10 +33,13 @@ type CheckResult struct {
 	ActualStatus   Status   `json:"actualstatus" toml:"actualstatus"`     // status of the check as it is performed
 	ReportedStatus Status   `json:"reportedstatus" toml:"reportedstatus"` // status of the check after applying state processing
 	ErrorMsg       []string `json:"errormsg" toml:"errormsg"`             // error message if any
+	Weight         *int     `json:"weight" toml:"weight"`                 // weight reported by the check, if any
 }
 
 // HealthCheck custom HealthCheck
 type HealthCheck struct {
+	Name               string              `json:"name" toml:"name"`                             // name of the check, used in expressions and dependencies
+	DependsOn          []string            `json:"depends_on" toml:"depends_on"`                 // names of checks that need to be online before this check counts
 	Type               string              `json:"type" toml:"type"`                             // check type
 	TCPRequest         string              `json:"tcprequest" toml:"tcprequest"`                 // tcp request to send
 	TCPReply           string              `json:"tcpreply" toml:"tcpreply"`                     // tcp reply to expect
@@ -44,6 +49,8 @@ type HealthCheck struct {
 	HTTPStatus         int                 `json:"httpstatus" toml:"httpstatus"`                 // http status expected
 	HTTPReply          string              `json:"httpreply" toml:"httpreply"`                   // http reply expected
 	HTTPFollowRedirect string              `json:"httpfollowredirect" toml:"httpfollowredirect"` // http follow redirects
+	WeightRegex        string              `json:"weight_regex" toml:"weight_regex"`             // regex extracting the node weight from the http body or tcp reply
+	WeightJSON         string              `json:"weight_json" toml:"weight_json"`               // dot separated key of the node weight in a json http body
 	SSHUser            string              `json:"sshuser" toml:"sshuser"`                       // ssh user
 	SSHPassword        string              `json:"sshpassword" toml:"sshpassword"`               // ssh password
 	SSHKey             string              `json:"sshkey" toml:"sshkey"`                         // ssh key
@@ -122,6 +129,8 @@ func NewManager() *Manager {
 		Incoming:        make(chan CheckResult),
 		HealthStatusMap: make(map[string]HealthStatus),
 		HealthPoolMap:   make(map[string]HealthPool),
+		WorkerHistory:   make(map[string]*History),
+		NodeHistory:     make(map[string]*History),
 	}
 
 	return manager
@@ -191,6 +200,29 @@ func (m *Manager) JSONAuthorized(uuid string) ([]byte, error) {
 	return result, err
 }
 
+// WorkerScope returns the pool and backend of the worker with the uuid, backend is empty for pool checks
+func (m *Manager) WorkerScope(uuid string) (string, string, error) {
+	m.Worker.Lock()
+	defer m.Worker.Unlock()
+	for _, w := range m.Workers {
+		if w.UUIDStr == uuid {
+			return w.Pool, w.Backend, nil
+		}
+	}
+
+	return "", "", fmt.Errorf("unkown uuid: %s", uuid)
+}
+
+// GetStatus returns the manual status of a uuid
+func (m *Manager) GetStatus(uuid string) (Status, error) {
+	m.Worker.Lock()
+	defer m.Worker.Unlock()
+	if node, ok := m.HealthStatusMap[uuid]; ok {
+		return node.ManualStatus, nil
+	}
+	return Automatic, fmt.Errorf("unkown uuid: %s", uuid)
+}
+
 // SetStatus sets the status of a uuid to status
 func (m *Manager) SetStatus(uuid string, status Status) error {
 	m.Worker.Lock()
@@ -0,0 +1,219 @@
+package healthcheck
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SetDefaultNames names all unnamed checks after their type, adding a -N suffix for duplicates
+// reserved contains checks that already have their name (such as pool checks), which will not be reused
+func SetDefaultNames(checks []HealthCheck, reserved []HealthCheck) error {
+	taken := make(map[string]bool)
+	for _, check := range reserved {
+		taken[check.Name] = true
+	}
+
+	for _, check := range checks {
+		if check.Name == "" {
+			continue
+		}
+
+		if taken[check.Name] {
+			return fmt.Errorf("duplicate health check name: %s", check.Name)
+		}
+		taken[check.Name] = true
+	}
+
+	for id, check := range checks {
+		if check.Name != "" {
+			continue
+		}
+
+		name := check.Type
+		for i := 2; taken[name]; i++ {
+			name = fmt.Sprintf("%s-%d", check.Type, i)
+		}
+		checks[id].Name = name
+		taken[name] = true
+	}
+
+	return nil
+}
+
+// ValidateChecks validates the checks, the dependencies between them, and the match mode using them
+func ValidateChecks(match string, checks []HealthCheck) error {
+	deps := make(map[string][]string)
+	for _, check := range checks {
+		deps[check.Name] = check.DependsOn
+		if err := check.validateWeight(); err != nil {
+			return fmt.Errorf("health check %s: %s", check.Name, err)
+		}
+	}
+
+	for _, check := range checks {
+		for _, dep := range check.DependsOn {
+			if _, ok := deps[dep]; !ok {
+				return fmt.Errorf("health check %s depends on unknown check: %s", check.Name, dep)
+			}
+		}
+
+		if loop := dependencyLoop(check.Name, deps, []string{}); loop != nil {
+			return fmt.Errorf("health check dependency loop: %s", strings.Join(loop, " -> "))
+		}
+	}
+
+	switch match {
+	case "all", "any":
+		return nil
+	}
+
+	expression, err := ParseExpression(match)
+	if err != nil {
+		return fmt.Errorf("invalid healthcheckmode %q: %s", match, err)
+	}
+
+	for _, name := range expression.Names() {
+		if _, ok := deps[name]; !ok {
+			return fmt.Errorf("healthcheckmode %q uses unknown check: %s", match, name)
+		}
+	}
+
+	return nil
+}
+
+// dependencyLoop returns the path of a dependency loop starting at name, or nil if there is none
+func dependencyLoop(name string, deps map[string][]string, path []string) []string {
+	for _, p := range path {
+		if p == name {
+			return append(path, name)
+		}
+	}
+
+	path = append(path, name)
+	for _, dep := range deps[name] {
+		if loop := dependencyLoop(dep, deps, path); loop != nil {
+			return loop
+		}
+	}
+
+	return nil
+}
+
+// checkStatus is the status of a worker as used to determine the node status
+type checkStatus struct {
+	status  Status
+	errors  []string
+	skipped bool
+}
+
+// nodeCheckStatus returns the status of all checks of a node, taking manual overrides and dependencies in to account
+// checks depending on a check that is not online are skipped and considered offline, the failing dependency is reported as root cause
+func (m *Manager) nodeCheckStatus(pool HealthPool) map[string]*checkStatus {
+	statuses := make(map[string]*checkStatus)
+	uuids := make(map[string]string)
+	for _, workerUUID := range pool.Checks {
+		s := &checkStatus{status: Offline}
+		if worker, found := m.HealthStatusMap[workerUUID]; found {
+			s.status = worker.CheckStatus
+			if worker.ManualStatus != Automatic {
+				s.status = worker.ManualStatus
+			}
+			s.errors = worker.ErrorMsg
+		} else {
+			s.errors = []string{fmt.Sprintf("Pending health check with worker:%s", workerUUID)}
+		}
+
+		statuses[workerUUID] = s
+		if name, ok := pool.Names[workerUUID]; ok {
+			uuids[name] = workerUUID
+		}
+	}
+
+	var resolve func(workerUUID string, visiting map[string]bool) Status
+	resolve = func(workerUUID string, visiting map[string]bool) Status {
+		s := statuses[workerUUID]
+		if s.skipped || visiting[workerUUID] {
+			return s.status
+		}
+
+		visiting[workerUUID] = true
+		defer delete(visiting, workerUUID)
+		for _, dep := range pool.DependsOn[workerUUID] {
+			depUUID, ok := uuids[dep]
+			if !ok {
+				s.status = Offline
+				s.skipped = true
+				s.errors = []string{fmt.Sprintf("check %s skipped: depends on unknown check %s", pool.Names[workerUUID], dep)}
+				break
+			}
+
+			if visiting[depUUID] {
+				s.status = Offline
+				s.skipped = true
+				s.errors = []string{fmt.Sprintf("check %s skipped: dependency loop on %s", pool.Names[workerUUID], dep)}
+				break
+			}
+
+			if status := resolve(depUUID, visiting); status != Online {
+				s.status = Offline
+				s.skipped = true
+				s.errors = []string{fmt.Sprintf("check %s skipped: depends on %s which is %s", pool.Names[workerUUID], dep, status)}
+				break
+			}
+		}
+
+		return s.status
+	}
+
+	for workerUUID := range statuses {
+		resolve(workerUUID, make(map[string]bool))
+	}
+
+	return statuses
+}
+
+// matchStatus returns true if the checks of the node match the match mode of the pool: all, any or an expression
+func (pool HealthPool) matchStatus(statuses map[string]*checkStatus) (bool, error) {
+	switch pool.Match {
+	case "", "all":
+		for _, s := range statuses {
+			if s.status != Online {
+				return false, nil
+			}
+		}
+		return true, nil
+
+	case "any":
+		for _, s := range statuses {
+			if s.status == Online {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	expression, err := ParseExpression(pool.Match)
+	if err != nil {
+		return false, err
+	}
+
+	uuids := make(map[string]string)
+	for workerUUID, name := range pool.Names {
+		uuids[name] = workerUUID
+	}
+
+	var unknown []string
+	result := expression.Evaluate(func(name string) bool {
+		if s, ok := statuses[uuids[name]]; ok {
+			return s.status == Online
+		}
+		unknown = append(unknown, name)
+		return false
+	})
+
+	if unknown != nil {
+		return result, fmt.Errorf("unknown checks in expression %s: %s", pool.Match, strings.Join(unknown, ", "))
+	}
+
+	return result, nil
+}
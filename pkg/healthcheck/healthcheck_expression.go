@@ -0,0 +1,187 @@
+package healthcheck
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Expression is a parsed boolean expression over healthcheck names, such as "(http AND db) OR override"
+type Expression struct {
+	op    string // and, or, not or name
+	name  string
+	left  *Expression
+	right *Expression
+}
+
+// ParseExpression parses a boolean expression using AND, OR, NOT (or &&, ||, !) and parentheses over check names
+func ParseExpression(s string) (*Expression, error) {
+	p := &expressionParser{tokens: tokenizeExpression(s)}
+	if len(p.tokens) == 0 {
+		return nil, fmt.Errorf("empty expression")
+	}
+
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in expression: %s", p.tokens[p.pos], s)
+	}
+
+	return e, nil
+}
+
+// Evaluate returns the result of the expression, using online to get the result of each check name
+func (e *Expression) Evaluate(online func(name string) bool) bool {
+	switch e.op {
+	case "and":
+		return e.left.Evaluate(online) && e.right.Evaluate(online)
+	case "or":
+		return e.left.Evaluate(online) || e.right.Evaluate(online)
+	case "not":
+		return !e.left.Evaluate(online)
+	}
+
+	return online(e.name)
+}
+
+// Names returns all check names used in the expression
+func (e *Expression) Names() (names []string) {
+	switch e.op {
+	case "and", "or":
+		return append(e.left.Names(), e.right.Names()...)
+	case "not":
+		return e.left.Names()
+	}
+
+	return []string{e.name}
+}
+
+func (e *Expression) String() string {
+	switch e.op {
+	case "and", "or":
+		return fmt.Sprintf("(%s %s %s)", e.left, strings.ToUpper(e.op), e.right)
+	case "not":
+		return fmt.Sprintf("NOT %s", e.left)
+	}
+
+	return e.name
+}
+
+// tokenizeExpression splits an expression in to parentheses, operators and names
+func tokenizeExpression(s string) (tokens []string) {
+	var current strings.Builder
+	flush := func() {
+		if current.Len() > 0 {
+			tokens = append(tokens, current.String())
+			current.Reset()
+		}
+	}
+
+	for i := 0; i < len(s); i++ {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			flush()
+		case c == '(' || c == ')' || c == '!':
+			flush()
+			tokens = append(tokens, string(c))
+		case (c == '&' || c == '|') && i+1 < len(s) && s[i+1] == s[i]:
+			flush()
+			tokens = append(tokens, s[i:i+2])
+			i++
+		default:
+			current.WriteRune(c)
+		}
+	}
+	flush()
+
+	return
+}
+
+type expressionParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *expressionParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+
+	return ""
+}
+
+func (p *expressionParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *expressionParser) parseOr() (*Expression, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for t := p.peek(); t == "||" || strings.EqualFold(t, "or"); t = p.peek() {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &Expression{op: "or", left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *expressionParser) parseAnd() (*Expression, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for t := p.peek(); t == "&&" || strings.EqualFold(t, "and"); t = p.peek() {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &Expression{op: "and", left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *expressionParser) parseNot() (*Expression, error) {
+	t := p.next()
+	switch {
+	case t == "":
+		return nil, fmt.Errorf("unexpected end of expression")
+
+	case t == "!" || strings.EqualFold(t, "not"):
+		e, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &Expression{op: "not", left: e}, nil
+
+	case t == "(":
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		return e, nil
+
+	case t == ")" || t == "&&" || t == "||" || strings.EqualFold(t, "and") || strings.EqualFold(t, "or"):
+		return nil, fmt.Errorf("unexpected %q in expression", t)
+	}
+
+	return &Expression{op: "name", name: t}, nil
+}
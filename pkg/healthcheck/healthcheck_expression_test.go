@@ -0,0 +1,103 @@
+package healthcheck
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/schubergphilis/mercury/pkg/logging"
+)
+
+func TestParseExpression(t *testing.T) {
+	online := map[string]bool{"http": true, "db": false, "override": true}
+	checks := map[string]bool{
+		"http":                        true,
+		"db":                          false,
+		"http AND db":                 false,
+		"http && !db":                 true,
+		"(http AND db) OR override":   true,
+		"(http and db) or NOT http":   false,
+		"NOT (db || override)":        false,
+		"http AND (db OR override)":   true,
+		"http OR db AND NOT override": true,
+	}
+
+	for in, out := range checks {
+		e, err := ParseExpression(in)
+		if err != nil {
+			t.Errorf("Failed to parse expression %q: %s", in, err)
+			continue
+		}
+
+		if result := e.Evaluate(func(name string) bool { return online[name] }); result != out {
+			t.Errorf("Expression %q (%s) returned %t expected %t", in, e, result, out)
+		}
+	}
+
+	invalid := []string{"", "http AND", "(http OR db", "http db", "AND http", "http)"}
+	for _, in := range invalid {
+		if _, err := ParseExpression(in); err == nil {
+			t.Errorf("Expected expression %q to be invalid", in)
+		}
+	}
+}
+
+func TestValidateChecks(t *testing.T) {
+	checks := []HealthCheck{{Type: "icmpping"}, {Type: "httpget", DependsOn: []string{"icmpping"}}, {Type: "httpget"}}
+	if err := SetDefaultNames(checks, nil); err != nil {
+		t.Fatalf("Failed to set default names: %s", err)
+	}
+
+	if checks[2].Name != "httpget-2" {
+		t.Errorf("Expected duplicate check to be named httpget-2, got %s", checks[2].Name)
+	}
+
+	if err := ValidateChecks("icmpping AND (httpget OR httpget-2)", checks); err != nil {
+		t.Errorf("Expected checks to be valid: %s", err)
+	}
+
+	if err := ValidateChecks("httpget AND unknown", checks); err == nil {
+		t.Errorf("Expected expression with unknown check to be invalid")
+	}
+
+	checks[0].DependsOn = []string{"httpget"}
+	if err := ValidateChecks("all", checks); err == nil {
+		t.Errorf("Expected dependency loop to be invalid")
+	}
+
+	if err := SetDefaultNames([]HealthCheck{{Name: "ping"}}, []HealthCheck{{Name: "ping"}}); err == nil {
+		t.Errorf("Expected duplicate name to be invalid")
+	}
+}
+
+func TestNodeStatusDependencies(t *testing.T) {
+	logging.Configure("stdout", "error")
+	m := NewManager()
+	ping := NewWorker("pool", "backend", "node", "node1", "127.0.0.1", 80, "", HealthCheck{Name: "ping", Type: "icmpping"}, m.Incoming)
+	http := NewWorker("pool", "backend", "node", "node1", "127.0.0.1", 80, "", HealthCheck{Name: "http", Type: "httpget", DependsOn: []string{"ping"}}, m.Incoming)
+	override := NewWorker("pool", "backend", "node", "node1", "127.0.0.1", 80, "", HealthCheck{Name: "override", Type: "httpget", HTTPRequest: "/override"}, m.Incoming)
+	m.SetCheckPool("node1", "pool", "backend", "node", "http OR override", []*Worker{ping, http, override})
+
+	m.SetCheckStatus(ping.UUID(), Offline, []string{"ping timeout"})
+	m.SetCheckStatus(http.UUID(), Online, []string{})
+	m.SetCheckStatus(override.UUID(), Offline, []string{"override not set"})
+	status, _, _, _, errors := m.GetNodeStatus("node1")
+	if status != Offline {
+		t.Errorf("Expected node to be offline when dependency fails, got %s", status)
+	}
+
+	msg := strings.Join(errors, ",")
+	if !strings.Contains(msg, "ping timeout") || !strings.Contains(msg, "check http skipped: depends on ping") {
+		t.Errorf("Expected root cause in errors, got %v", errors)
+	}
+
+	m.SetCheckStatus(ping.UUID(), Online, []string{})
+	if status, _, _, _, errors := m.GetNodeStatus("node1"); status != Online {
+		t.Errorf("Expected node to be online, got %s (%v)", status, errors)
+	}
+
+	m.SetCheckStatus(ping.UUID(), Offline, []string{"ping timeout"})
+	m.SetCheckStatus(override.UUID(), Online, []string{})
+	if status, _, _, _, errors := m.GetNodeStatus("node1"); status != Online {
+		t.Errorf("Expected node to be online through override, got %s (%v)", status, errors)
+	}
+}
@@ -11,15 +11,19 @@ type HealthStatus struct {
 	CheckStatus  Status   `json:"checkstatus" toml:"checkstatus"`   // map[checkuuid]status - status returned by worker
 	ManualStatus Status   `json:"manualstatus" toml:"manualstatus"` // manual override
 	ErrorMsg     []string `json:"errormsg" toml:"errormsg"`         // error message
+	Weight       *int     `json:"weight" toml:"weight"`             // weight reported by the worker, if any
 }
 
 // HealthPool contains a per nodeuuid information about all checks that apply to this node
 type HealthPool struct {
-	PoolName    string   `json:"poolname" toml:"poolname"`       // name of the vip pool
-	BackendName string   `json:"backendname" toml:"backendname"` // name of the backend
-	NodeName    string   `json:"nodename" toml:"nodename"`       // name of the node
-	Match       string   `json:"match" toml:"match"`             // all/any
-	Checks      []string `json:"checks" toml:"checks"`           // []checkuuid
+	PoolName     string              `json:"poolname" toml:"poolname"`         // name of the vip pool
+	BackendName  string              `json:"backendname" toml:"backendname"`   // name of the backend
+	NodeName     string              `json:"nodename" toml:"nodename"`         // name of the node
+	Match        string              `json:"match" toml:"match"`               // all/any/expression
+	Checks       []string            `json:"checks" toml:"checks"`             // []checkuuid
+	Names        map[string]string   `json:"names" toml:"names"`               // map[checkuuid]name
+	DependsOn    map[string][]string `json:"dependson" toml:"dependson"`       // map[checkuuid][]name
+	ManualStatus Status              `json:"manualstatus" toml:"manualstatus"` // manual override of the node status
 }
 
 // SetCheckStatus sets the status of a worker check based on the health check result
@@ -40,7 +44,18 @@ func (m *Manager) SetCheckStatus(workerUUID string, status Status, errorMsg []st
 }
 
 // SetCheckPool sets which checks for a specified backend are applicable
-func (m *Manager) SetCheckPool(nodeUUID string, poolName string, backendName string, nodeName string, match string, checks []string) bool {
+func (m *Manager) SetCheckPool(nodeUUID string, poolName string, backendName string, nodeName string, match string, workers []*Worker) bool {
+	var checks []string
+	names := make(map[string]string)
+	dependsOn := make(map[string][]string)
+	for _, w := range workers {
+		checks = append(checks, w.UUID())
+		names[w.UUID()] = w.Check.Name
+		if len(w.Check.DependsOn) > 0 {
+			dependsOn[w.UUID()] = w.Check.DependsOn
+		}
+	}
+
 	m.Worker.Lock()
 	defer m.Worker.Unlock()
 	new := false
@@ -50,6 +65,8 @@ func (m *Manager) SetCheckPool(nodeUUID string, poolName string, backendName str
 	}
 	s := m.HealthPoolMap[nodeUUID]
 	s.Checks = checks
+	s.Names = names
+	s.DependsOn = dependsOn
 	s.PoolName = poolName
 	s.BackendName = backendName
 	s.NodeName = nodeName
@@ -58,6 +75,24 @@ func (m *Manager) SetCheckPool(nodeUUID string, poolName string, backendName str
 	return new
 }
 
+// SetNodeStatus sets the manual status of a node, overriding the status of all checks applicable to it
+func (m *Manager) SetNodeStatus(nodeUUID string, status Status) error {
+	m.Worker.Lock()
+	defer m.Worker.Unlock()
+	pool, ok := m.HealthPoolMap[nodeUUID]
+	if !ok {
+		return fmt.Errorf("unkown node uuid: %s", nodeUUID)
+	}
+
+	if _, ok := StatusTypeToString[status]; !ok {
+		return fmt.Errorf("unknown status to set: %s", status)
+	}
+
+	pool.ManualStatus = status
+	m.HealthPoolMap[nodeUUID] = pool
+	return nil
+}
+
 // GetNodeStatus returns the combined status of all checks applicable to a specific backend
 func (m *Manager) GetNodeStatus(nodeUUID string) (Status, string, string, string, []string) {
 	var errors []string
@@ -65,48 +100,48 @@ func (m *Manager) GetNodeStatus(nodeUUID string) (Status, string, string, string
 	m.Worker.Lock()
 	defer m.Worker.Unlock()
 	if pool, ok := m.HealthPoolMap[nodeUUID]; ok {
+		if pool.ManualStatus != Automatic {
+			log.WithField("manualstatus", pool.ManualStatus).WithField("nodeuuid", nodeUUID).Debug("Status check for node overridden")
+			return pool.ManualStatus, pool.PoolName, pool.BackendName, pool.NodeName, []string{fmt.Sprintf("Node status manually set to %s", pool.ManualStatus)}
+		}
+
 		ok := 0
 		nok := 0
 		maintenance := 0
+		draining := 0
+		statuses := m.nodeCheckStatus(pool)
 		for _, workerUUID := range pool.Checks {
-			if worker, found := m.HealthStatusMap[workerUUID]; found {
-				log.WithField("workeruuid", workerUUID).WithField("checkstatus", worker.CheckStatus).WithField("nodeuuid", nodeUUID).Debug("Status check for node")
-				switch worker.ManualStatus {
-				case Online:
-					ok++
-				case Offline:
-					nok++
-				case Maintenance:
-					maintenance++
-				default: // (Automatic)
-					switch worker.CheckStatus {
-					case Online:
-						ok++
-					case Offline:
-						nok++
-					case Maintenance:
-						maintenance++
-					}
-				}
-
-				errors = append(errors, worker.ErrorMsg...)
-			} else {
+			check := statuses[workerUUID]
+			log.WithField("workeruuid", workerUUID).WithField("checkstatus", check.status).WithField("skipped", check.skipped).WithField("nodeuuid", nodeUUID).Debug("Status check for node")
+			switch check.status {
+			case Online:
+				ok++
+			case Maintenance:
+				maintenance++
+			case Draining:
+				draining++
+			default:
 				nok++
-				log.WithField("workeruuid", workerUUID).WithField("checkstatus", worker.CheckStatus).WithField("nodeuuid", nodeUUID).Debug("Status check for node FAILED")
-				errors = append(errors, fmt.Sprintf("Pending health check with worker:%s", workerUUID))
 			}
+
+			errors = append(errors, check.errors...)
 		}
 
-		log.WithField("ok", ok).WithField("nok", nok).WithField("maintenance", maintenance).WithField("nodeuuid", nodeUUID).WithField("match", pool.Match).WithField("pool", pool.PoolName).WithField("backend", pool.BackendName).WithField("node", pool.NodeName).Debug("Health Status Check")
+		log.WithField("ok", ok).WithField("nok", nok).WithField("maintenance", maintenance).WithField("draining", draining).WithField("nodeuuid", nodeUUID).WithField("match", pool.Match).WithField("pool", pool.PoolName).WithField("backend", pool.BackendName).WithField("node", pool.NodeName).Debug("Health Status Check")
 		if maintenance > 0 {
 			return Maintenance, pool.PoolName, pool.BackendName, pool.NodeName, errors
 		}
 
-		if pool.Match == "any" && ok > 0 {
-			return Online, pool.PoolName, pool.BackendName, pool.NodeName, errors
+		if draining > 0 {
+			return Draining, pool.PoolName, pool.BackendName, pool.NodeName, errors
+		}
+
+		online, err := pool.matchStatus(statuses)
+		if err != nil {
+			errors = append(errors, err.Error())
 		}
 
-		if pool.Match == "all" && nok == 0 {
+		if online {
 			return Online, pool.PoolName, pool.BackendName, pool.NodeName, errors
 		}
 
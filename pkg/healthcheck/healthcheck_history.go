@@ -0,0 +1,195 @@
+package healthcheck
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// HistorySize is the amount of state transitions kept for each worker and each node
+var HistorySize = 100
+
+// HistoryEntry contains a single state transition of a worker or node
+type HistoryEntry struct {
+	Time        time.Time     `json:"time" toml:"time"`               // time the transition happened
+	ClusterNode string        `json:"clusternode" toml:"clusternode"` // cluster node that reported the transition
+	PoolName    string        `json:"poolname" toml:"poolname"`       // pool this transition belongs to
+	BackendName string        `json:"backendname" toml:"backendname"` // backend this transition belongs to
+	NodeName    string        `json:"nodename" toml:"nodename"`       // node name of the transition
+	NodeUUID    string        `json:"nodeuuid" toml:"nodeuuid"`       // node uuid of the transition
+	WorkerUUID  string        `json:"workeruuid" toml:"workeruuid"`   // worker uuid, empty for node transitions
+	Description string        `json:"description" toml:"description"` // description of the check
+	Status      Status        `json:"status" toml:"status"`           // status after the transition
+	ErrorMsg    []string      `json:"errormsg" toml:"errormsg"`       // error message if any
+	Duration    time.Duration `json:"duration" toml:"duration"`       // how long the status was kept, or is kept so far for the last entry
+}
+
+// History is a bounded ring buffer of state transitions
+type History struct {
+	entries []HistoryEntry
+	next    int
+	full    bool
+}
+
+// NewHistory creates a new history that keeps up to size entries
+func NewHistory(size int) *History {
+	if size < 1 {
+		size = 1
+	}
+
+	return &History{
+		entries: make([]HistoryEntry, size),
+	}
+}
+
+// Add adds a transition to the history, overwriting the oldest entry if the history is full
+func (h *History) Add(e HistoryEntry) {
+	if last, ok := h.last(); ok {
+		last.Duration = e.Time.Sub(last.Time)
+	}
+
+	h.entries[h.next] = e
+	h.next = (h.next + 1) % len(h.entries)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// Entries returns a copy of all transitions, oldest first
+func (h *History) Entries() []HistoryEntry {
+	var entries []HistoryEntry
+	if h.full {
+		entries = append(entries, h.entries[h.next:]...)
+	}
+
+	entries = append(entries, h.entries[:h.next]...)
+	if len(entries) > 0 {
+		entries[len(entries)-1].Duration = time.Since(entries[len(entries)-1].Time)
+	}
+
+	return entries
+}
+
+// last returns a pointer to the most recent transition
+func (h *History) last() (*HistoryEntry, bool) {
+	if h.next == 0 && !h.full {
+		return nil, false
+	}
+
+	return &h.entries[(h.next+len(h.entries)-1)%len(h.entries)], true
+}
+
+// changed returns true if the entry differs from the most recent transition
+func (h *History) changed(e HistoryEntry) bool {
+	last, ok := h.last()
+	if !ok {
+		return true
+	}
+
+	return last.Status != e.Status || !reflect.DeepEqual(last.ErrorMsg, e.ErrorMsg)
+}
+
+// AddWorkerHistory records the check result of a worker if its status or error changed
+func (m *Manager) AddWorkerHistory(checkresult CheckResult) {
+	m.Worker.Lock()
+	defer m.Worker.Unlock()
+	if _, ok := m.WorkerHistory[checkresult.WorkerUUID]; !ok {
+		m.WorkerHistory[checkresult.WorkerUUID] = NewHistory(HistorySize)
+	}
+
+	entry := HistoryEntry{
+		Time:        time.Now(),
+		PoolName:    checkresult.PoolName,
+		BackendName: checkresult.BackendName,
+		NodeName:    checkresult.NodeName,
+		NodeUUID:    checkresult.NodeUUID,
+		WorkerUUID:  checkresult.WorkerUUID,
+		Description: checkresult.Description,
+		Status:      checkresult.ReportedStatus,
+		ErrorMsg:    checkresult.ErrorMsg,
+	}
+
+	if m.WorkerHistory[checkresult.WorkerUUID].changed(entry) {
+		m.WorkerHistory[checkresult.WorkerUUID].Add(entry)
+	}
+}
+
+// AddNodeHistory records the status of a node as seen by a cluster node, returns true if this was a transition
+func (m *Manager) AddNodeHistory(clusterNode string, entry HistoryEntry) bool {
+	m.Worker.Lock()
+	defer m.Worker.Unlock()
+	entry.ClusterNode = clusterNode
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	key := clusterNode + "/" + entry.NodeUUID
+	if _, ok := m.NodeHistory[key]; !ok {
+		m.NodeHistory[key] = NewHistory(HistorySize)
+	}
+
+	if !m.NodeHistory[key].changed(entry) {
+		return false
+	}
+
+	m.NodeHistory[key].Add(entry)
+	return true
+}
+
+// GetNodeHistory returns the transitions of a node reported by all cluster nodes, oldest first
+func (m *Manager) GetNodeHistory(nodeUUID string) []HistoryEntry {
+	m.Worker.RLock()
+	defer m.Worker.RUnlock()
+	return m.nodeHistory(nodeUUID)
+}
+
+func (m *Manager) nodeHistory(nodeUUID string) (entries []HistoryEntry) {
+	for _, history := range m.NodeHistory {
+		for _, entry := range history.Entries() {
+			if entry.NodeUUID == nodeUUID {
+				entries = append(entries, entry)
+			}
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+
+	return
+}
+
+// HistoryJSON returns the transitions of all workers and nodes in json format, optionally filtered on a worker or node uuid
+func (m *Manager) HistoryJSON(uuid string) ([]byte, error) {
+	m.Worker.RLock()
+	defer m.Worker.RUnlock()
+	tmp := struct {
+		Workers map[string][]HistoryEntry `json:"workers" toml:"workers"` // transitions for each worker uuid
+		Nodes   map[string][]HistoryEntry `json:"nodes" toml:"nodes"`     // transitions for each node uuid
+	}{
+		Workers: make(map[string][]HistoryEntry),
+		Nodes:   make(map[string][]HistoryEntry),
+	}
+
+	for workerUUID, history := range m.WorkerHistory {
+		if uuid == "" || uuid == workerUUID {
+			tmp.Workers[workerUUID] = history.Entries()
+		}
+	}
+
+	for _, history := range m.NodeHistory {
+		for _, entry := range history.Entries() {
+			if _, ok := tmp.Nodes[entry.NodeUUID]; ok {
+				continue
+			}
+
+			if uuid == "" || uuid == entry.NodeUUID {
+				tmp.Nodes[entry.NodeUUID] = m.nodeHistory(entry.NodeUUID)
+			}
+		}
+	}
+
+	result, err := json.Marshal(tmp)
+	return result, err
+}
@@ -0,0 +1,58 @@
+package healthcheck
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	h := NewHistory(3)
+	if len(h.Entries()) != 0 {
+		t.Errorf("New history returned %d entries, expected 0", len(h.Entries()))
+	}
+
+	tm, _ := time.Parse(time.RFC3339, "2012-12-12T12:12:12+02:00")
+	for i := 0; i < 5; i++ {
+		h.Add(HistoryEntry{Time: tm.Add(time.Duration(i) * time.Minute), Status: Status(i % 3)})
+	}
+
+	entries := h.Entries()
+	if len(entries) != 3 {
+		t.Fatalf("History returned %d entries, expected 3", len(entries))
+	}
+
+	for i, entry := range entries {
+		expected := tm.Add(time.Duration(i+2) * time.Minute)
+		if !entry.Time.Equal(expected) {
+			t.Errorf("History entry %d has time:%s expected:%s", i, entry.Time, expected)
+		}
+	}
+
+	if entries[0].Duration != time.Minute {
+		t.Errorf("History entry duration is %s expected:%s", entries[0].Duration, time.Minute)
+	}
+}
+
+func TestNodeHistory(t *testing.T) {
+	m := NewManager()
+	if !m.AddNodeHistory("cluster1", HistoryEntry{NodeUUID: "node1", Status: Online}) {
+		t.Errorf("Expected first node status to be recorded as a transition")
+	}
+
+	if m.AddNodeHistory("cluster1", HistoryEntry{NodeUUID: "node1", Status: Online}) {
+		t.Errorf("Expected unchanged node status not to be recorded as a transition")
+	}
+
+	if !m.AddNodeHistory("cluster2", HistoryEntry{NodeUUID: "node1", Status: Offline, ErrorMsg: []string{"down"}}) {
+		t.Errorf("Expected node status of peer to be recorded as a transition")
+	}
+
+	entries := m.GetNodeHistory("node1")
+	if len(entries) != 2 {
+		t.Fatalf("Node history returned %d entries, expected 2", len(entries))
+	}
+
+	if entries[1].ClusterNode != "cluster2" {
+		t.Errorf("Node history entry has cluster node:%s expected:cluster2", entries[1].ClusterNode)
+	}
+}
@@ -71,12 +71,12 @@ func postDataParser(t time.Time, data string) string {
 }
 
 // httpRequest does a http request check
-func httpRequest(method string, host string, port int, sourceIP string, healthCheck HealthCheck) (Status, error, string) {
+func httpRequest(method string, host string, port int, sourceIP string, healthCheck HealthCheck) (Status, *int, error, string) {
 	var err error
 
 	localAddr, errl := net.ResolveIPAddr("ip", sourceIP)
 	if errl != nil {
-		return Offline, errl, fmt.Sprintf("failed to resolve %s to an ip", sourceIP)
+		return Offline, nil, errl, fmt.Sprintf("failed to resolve %s to an ip", sourceIP)
 	}
 
 	localTCPAddr := net.TCPAddr{
@@ -101,7 +101,7 @@ func httpRequest(method string, host string, port int, sourceIP string, healthCh
 	// Parse TLS config if provided
 	tlsConfig, err := tlsconfig.LoadCertificate(healthCheck.TLSConfig)
 	if err != nil {
-		return Offline, fmt.Errorf("Unable to setup TLS:%s", err), fmt.Sprintf("failed to load tls configuration %v", healthCheck.TLSConfig)
+		return Offline, nil, fmt.Errorf("Unable to setup TLS:%s", err), fmt.Sprintf("failed to load tls configuration %v", healthCheck.TLSConfig)
 	}
 
 	// Overwrite default transports with our own for checking the correct node
@@ -138,7 +138,7 @@ func httpRequest(method string, host string, port int, sourceIP string, healthCh
 	}
 
 	if err != nil {
-		return Offline, err, fmt.Sprintf("error creating request %s %s %s", method, healthCheck.HTTPRequest, postData)
+		return Offline, nil, err, fmt.Sprintf("error creating request %s %s %s", method, healthCheck.HTTPRequest, postData)
 	}
 
 	// Process headers to add
@@ -153,34 +153,40 @@ func httpRequest(method string, host string, port int, sourceIP string, healthCh
 	req.Header.Set("Accept", "*/*")
 	resp, err := client.Do(req)
 	if err != nil {
-		return Offline, err, fmt.Sprintf("error executing request %+v\n response was%+v", req, resp)
+		return Offline, nil, err, fmt.Sprintf("error executing request %+v\n response was%+v", req, resp)
 	}
 
 	defer resp.Body.Close()
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return Offline, fmt.Errorf("Error reading HTTP Body: %s", err), fmt.Sprintf("Failed to read body, did get %+v", resp)
+		return Offline, nil, fmt.Errorf("Error reading HTTP Body: %s", err), fmt.Sprintf("Failed to read body, did get %+v", resp)
 	}
 
 	// Check health status
 	if healthCheck.HTTPStatus > 0 {
 		if resp.StatusCode != healthCheck.HTTPStatus {
-			return Offline, fmt.Errorf("HTTP Response code incorrect (got:%d %s expected:%d)", resp.StatusCode, resp.Status, healthCheck.HTTPStatus), fmt.Sprintf("Failed to get expected response, request: %+v\n return headers: %+v\n return body: %s", *req, resp, body)
+			return Offline, nil, fmt.Errorf("HTTP Response code incorrect (got:%d %s expected:%d)", resp.StatusCode, resp.Status, healthCheck.HTTPStatus), fmt.Sprintf("Failed to get expected response, request: %+v\n return headers: %+v\n return body: %s", *req, resp, body)
 		}
 	}
 
 	// check body
 	r, err := regexp.Compile(healthCheck.HTTPReply)
 	if err != nil {
-		return Offline, err, fmt.Sprintf("Failed to compile regex for body check, did get headers: %+v\n body: %s", resp, body)
+		return Offline, nil, err, fmt.Sprintf("Failed to compile regex for body check, did get headers: %+v\n body: %s", resp, body)
 	}
 
 	if len(healthCheck.HTTPReply) != 0 {
 		if !r.MatchString(string(body)) {
-			return Offline, fmt.Errorf("Reply '%s' not found in body", healthCheck.HTTPReply), fmt.Sprintf("Failed to find text in body, did get headers: %+v\n body: %s", resp, body)
+			return Offline, nil, fmt.Errorf("Reply '%s' not found in body", healthCheck.HTTPReply), fmt.Sprintf("Failed to find text in body, did get headers: %+v\n body: %s", resp, body)
 		}
 	}
+	// extract the weight of the node if requested
+	weight, err := extractWeight(healthCheck, body)
+	if err != nil {
+		return Offline, nil, err, fmt.Sprintf("Failed to find weight in body, did get headers: %+v\n body: %s", resp, body)
+	}
+
 	// http and body check were ok
-	return Online, nil, "all OK"
+	return Online, weight, nil, "all OK"
 }
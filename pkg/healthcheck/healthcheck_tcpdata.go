@@ -9,16 +9,16 @@ import (
 )
 
 // tcpData does a simple tcp connect/reply check
-func tcpData(host string, port int, sourceIP string, healthCheck HealthCheck) (Status, error, string) {
+func tcpData(host string, port int, sourceIP string, healthCheck HealthCheck) (Status, *int, error, string) {
 
 	tcpAddr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf("%s:%d", host, port))
 	if err != nil {
-		return Offline, err, fmt.Sprintf("failed to resolve to an address: %s:%d", host, port)
+		return Offline, nil, err, fmt.Sprintf("failed to resolve to an address: %s:%d", host, port)
 	}
 
 	localAddr, errl := net.ResolveIPAddr("ip", sourceIP)
 	if errl != nil {
-		return Offline, errl, fmt.Sprintf("failed to resolve to an ip adress: %s", sourceIP)
+		return Offline, nil, errl, fmt.Sprintf("failed to resolve to an ip adress: %s", sourceIP)
 	}
 
 	localTCPAddr := net.TCPAddr{
@@ -28,7 +28,7 @@ func tcpData(host string, port int, sourceIP string, healthCheck HealthCheck) (S
 	// Custom dialer with
 	conn, err := net.DialTCP("tcp", &localTCPAddr, tcpAddr)
 	if err != nil {
-		return Offline, err, fmt.Sprintf("failed to dail from source: %+v target: %+v", localTCPAddr, *tcpAddr)
+		return Offline, nil, err, fmt.Sprintf("failed to dail from source: %+v target: %+v", localTCPAddr, *tcpAddr)
 	}
 
 	defer conn.Close()
@@ -36,18 +36,24 @@ func tcpData(host string, port int, sourceIP string, healthCheck HealthCheck) (S
 	fmt.Fprintf(conn, healthCheck.TCPRequest)
 	r, err := regexp.Compile(healthCheck.TCPReply)
 	if err != nil {
-		return Offline, err, fmt.Sprintf("regex Compile failed on %s", healthCheck.TCPReply)
+		return Offline, nil, err, fmt.Sprintf("regex Compile failed on %s", healthCheck.TCPReply)
 	}
 
 	conn.SetReadDeadline(time.Now().Add(time.Duration(healthCheck.Timeout) * time.Second))
 	for {
 		line, err := bufio.NewReader(conn).ReadString('\n')
 		if err != nil {
-			return Offline, err, fmt.Sprintf("failed with last input %s", line)
+			return Offline, nil, err, fmt.Sprintf("failed with last input %s", line)
 		}
 
 		if r.MatchString(line) {
-			return Online, nil, "OK"
+			// extract the weight of the node if requested
+			weight, err := extractWeight(healthCheck, []byte(line))
+			if err != nil {
+				return Offline, nil, err, fmt.Sprintf("failed to find weight in %s", line)
+			}
+
+			return Online, weight, nil, "OK"
 		}
 	}
 }
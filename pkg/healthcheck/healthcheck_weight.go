@@ -0,0 +1,137 @@
+package healthcheck
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// HasWeight returns true if the check extracts a node weight from its reply
+func (h HealthCheck) HasWeight() bool {
+	return h.WeightRegex != "" || h.WeightJSON != ""
+}
+
+// validateWeight validates the weight extraction settings of the check
+func (h HealthCheck) validateWeight() error {
+	if !h.HasWeight() {
+		return nil
+	}
+
+	if h.WeightRegex != "" && h.WeightJSON != "" {
+		return fmt.Errorf("weight_regex and weight_json cannot be used together")
+	}
+
+	switch h.Type {
+	case "httpget", "httppost":
+	case "tcpdata":
+		if h.WeightJSON != "" {
+			return fmt.Errorf("weight_json is not supported for tcpdata checks, use weight_regex")
+		}
+	default:
+		return fmt.Errorf("weight extraction is not supported for %s checks", h.Type)
+	}
+
+	if _, err := regexp.Compile(h.WeightRegex); err != nil {
+		return fmt.Errorf("invalid weight_regex: %s", err)
+	}
+
+	return nil
+}
+
+// extractWeight returns the node weight found in the reply of a check, using either its weight regex or json key
+// returns nil if the check does not extract a weight
+func extractWeight(healthCheck HealthCheck, reply []byte) (*int, error) {
+	var value string
+	switch {
+	case healthCheck.WeightJSON != "":
+		var data interface{}
+		if err := json.Unmarshal(reply, &data); err != nil {
+			return nil, fmt.Errorf("unable to parse reply as json for weight: %s", err)
+		}
+
+		for _, key := range strings.Split(healthCheck.WeightJSON, ".") {
+			m, ok := data.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("weight key %s not found in reply", healthCheck.WeightJSON)
+			}
+
+			if data, ok = m[key]; !ok {
+				return nil, fmt.Errorf("weight key %s not found in reply", healthCheck.WeightJSON)
+			}
+		}
+
+		value = fmt.Sprintf("%v", data)
+
+	case healthCheck.WeightRegex != "":
+		r, err := regexp.Compile(healthCheck.WeightRegex)
+		if err != nil {
+			return nil, err
+		}
+
+		match := r.FindSubmatch(reply)
+		if match == nil {
+			return nil, fmt.Errorf("weight regex '%s' not found in reply", healthCheck.WeightRegex)
+		}
+
+		// use the first capture group if there is one, otherwise the whole match
+		value = string(match[0])
+		if len(match) > 1 {
+			value = string(match[1])
+		}
+
+	default:
+		return nil, nil
+	}
+
+	f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || f < 0 {
+		return nil, fmt.Errorf("invalid weight in reply: %s", value)
+	}
+
+	weight := int(math.Round(f))
+	return &weight, nil
+}
+
+// SetCheckWeight sets the weight reported by a worker check, nil if it did not report any
+func (m *Manager) SetCheckWeight(workerUUID string, weight *int) {
+	m.Worker.Lock()
+	defer m.Worker.Unlock()
+	s := m.HealthStatusMap[workerUUID]
+	s.Weight = weight
+	m.HealthStatusMap[workerUUID] = s
+}
+
+// GetNodeWeight returns the weight of a node based on the weights reported by its checks
+// if multiple checks report a weight the lowest is used, returns nil if no check reported a weight
+func (m *Manager) GetNodeWeight(nodeUUID string) *int {
+	m.Worker.Lock()
+	defer m.Worker.Unlock()
+	pool, ok := m.HealthPoolMap[nodeUUID]
+	if !ok {
+		return nil
+	}
+
+	var weight *int
+	for _, workerUUID := range pool.Checks {
+		if worker, found := m.HealthStatusMap[workerUUID]; found && worker.Weight != nil {
+			if weight == nil || *worker.Weight < *weight {
+				w := *worker.Weight
+				weight = &w
+			}
+		}
+	}
+
+	return weight
+}
+
+// sameWeight returns true if both weights are equal or both are not set
+func sameWeight(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return *a == *b
+}
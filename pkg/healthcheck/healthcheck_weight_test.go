@@ -0,0 +1,74 @@
+package healthcheck
+
+import (
+	"testing"
+)
+
+func TestExtractWeight(t *testing.T) {
+	checks := []struct {
+		check  HealthCheck
+		reply  string
+		weight int
+	}{
+		{HealthCheck{WeightJSON: "capacity"}, `{"capacity": 40}`, 40},
+		{HealthCheck{WeightJSON: "status.capacity"}, `{"status": {"capacity": "12.6"}}`, 13},
+		{HealthCheck{WeightRegex: `capacity=(\d+)`}, "OK capacity=25\n", 25},
+		{HealthCheck{WeightRegex: `\d+`}, "load 7", 7},
+	}
+
+	for _, c := range checks {
+		weight, err := extractWeight(c.check, []byte(c.reply))
+		if err != nil {
+			t.Errorf("Failed to extract weight from %q: %s", c.reply, err)
+			continue
+		}
+
+		if weight == nil || *weight != c.weight {
+			t.Errorf("Extracted weight %v from %q, expected %d", weight, c.reply, c.weight)
+		}
+	}
+
+	invalid := []struct {
+		check HealthCheck
+		reply string
+	}{
+		{HealthCheck{WeightJSON: "capacity"}, `not json`},
+		{HealthCheck{WeightJSON: "capacity"}, `{"load": 40}`},
+		{HealthCheck{WeightJSON: "capacity"}, `{"capacity": -1}`},
+		{HealthCheck{WeightRegex: `capacity=(\d+)`}, "OK"},
+	}
+
+	for _, c := range invalid {
+		if _, err := extractWeight(c.check, []byte(c.reply)); err == nil {
+			t.Errorf("Expected weight extraction from %q to fail", c.reply)
+		}
+	}
+
+	if weight, err := extractWeight(HealthCheck{}, []byte("OK")); weight != nil || err != nil {
+		t.Errorf("Expected no weight for check without weight extraction, got %v (%v)", weight, err)
+	}
+}
+
+func TestNodeWeight(t *testing.T) {
+	m := NewManager()
+	http := NewWorker("pool", "backend", "node", "node1", "127.0.0.1", 80, "", HealthCheck{Type: "httpget", WeightJSON: "capacity"}, m.Incoming)
+	tcp := NewWorker("pool", "backend", "node", "node1", "127.0.0.1", 81, "", HealthCheck{Type: "tcpdata", WeightRegex: `(\d+)`}, m.Incoming)
+	ping := NewWorker("pool", "backend", "node", "node1", "127.0.0.1", 80, "", HealthCheck{Type: "icmpping"}, m.Incoming)
+	m.SetCheckPool("node1", "pool", "backend", "node", "all", []*Worker{http, tcp, ping})
+
+	if weight := m.GetNodeWeight("node1"); weight != nil {
+		t.Errorf("Expected no weight before checks reported, got %d", *weight)
+	}
+
+	high, low := 40, 10
+	m.SetCheckWeight(http.UUID(), &high)
+	m.SetCheckWeight(tcp.UUID(), &low)
+	if weight := m.GetNodeWeight("node1"); weight == nil || *weight != low {
+		t.Errorf("Expected node weight to be the lowest reported weight %d, got %v", low, weight)
+	}
+
+	m.SetCheckWeight(tcp.UUID(), nil)
+	if weight := m.GetNodeWeight("node1"); weight == nil || *weight != high {
+		t.Errorf("Expected node weight %d, got %v", high, weight)
+	}
+}
@@ -22,6 +22,7 @@ type Worker struct {
 	Check       HealthCheck `json:"check" toml:"check"`
 	CheckResult Status      `json:"checkresult" toml:"checkresult"` //
 	CheckError  string      `json:"checkerror" toml:"checkerror"`
+	CheckWeight *int        `json:"checkweight" toml:"checkweight"` // weight reported by the check, if any
 	UUIDStr     string      `json:"uuid" toml:"uuid"`
 	update      chan CheckResult
 	stop        chan bool
@@ -119,7 +120,7 @@ func (w *Worker) Start() {
 			select {
 			/* new check interval has reached */
 			case <-timer.C:
-				result, err, _ := w.ExecuteCheck()
+				result, weight, err, _ := w.executeCheck()
 
 				// Send update if check result or error changes
 				var checkerror string
@@ -132,7 +133,7 @@ func (w *Worker) Start() {
 					previouserror = w.CheckError
 				}
 
-				if result != w.CheckResult || checkerror != previouserror {
+				if result != w.CheckResult || checkerror != previouserror || !sameWeight(weight, w.CheckWeight) {
 					log.WithField("checktype", w.Check.Type).WithField("online", result).WithField("error", err).Warn("Healtcheck state changed")
 					// Send the result to the cluster
 					/*
@@ -156,6 +157,7 @@ func (w *Worker) Start() {
 						w.update <- checkresult
 					*/
 					w.CheckResult = result
+					w.CheckWeight = weight
 					w.CheckError = ""
 
 					var errorMsg []string
@@ -200,6 +202,7 @@ func (w *Worker) SendUpdate(result Status, errMsg []string) {
 		WorkerUUID:     w.UUID(),
 		Description:    w.Description(),
 		ErrorMsg:       errMsg,
+		Weight:         w.CheckWeight,
 	}
 
 	w.update <- checkresult
@@ -220,10 +223,17 @@ func (w *Worker) Stop() {
 	w.stop <- true
 }
 
-// executeCheck directs the check to the executioner and returns the result
+// ExecuteCheck directs the check to the executioner and returns the result
 func (w *Worker) ExecuteCheck() (Status, error, string) {
+	result, _, err, description := w.executeCheck()
+	return result, err, description
+}
+
+// executeCheck directs the check to the executioner and returns the result, including the weight reported by the check if any
+func (w *Worker) executeCheck() (Status, *int, error, string) {
 	var err error
 	var result = Offline
+	var weight *int
 	var description string
 
 	switch w.Check.Type {
@@ -231,16 +241,16 @@ func (w *Worker) ExecuteCheck() (Status, error, string) {
 		result, err, description = tcpConnect(w.IP, w.Port, w.SourceIP, w.Check)
 
 	case "tcpdata":
-		result, err, description = tcpData(w.IP, w.Port, w.SourceIP, w.Check)
+		result, weight, err, description = tcpData(w.IP, w.Port, w.SourceIP, w.Check)
 
 	case "ssh":
 		result, err, description = sshAuth(w.IP, w.Port, w.SourceIP, w.Check)
 
 	case "httpget":
-		result, err, description = httpRequest("GET", w.IP, w.Port, w.SourceIP, w.Check)
+		result, weight, err, description = httpRequest("GET", w.IP, w.Port, w.SourceIP, w.Check)
 
 	case "httppost":
-		result, err, description = httpRequest("POST", w.IP, w.Port, w.SourceIP, w.Check)
+		result, weight, err, description = httpRequest("POST", w.IP, w.Port, w.SourceIP, w.Check)
 
 	case "icmpping":
 		result, err, description = ipPing("icmp", w.IP, 0, w.SourceIP, w.Check)
@@ -254,7 +264,7 @@ func (w *Worker) ExecuteCheck() (Status, error, string) {
 	default:
 		result = Online
 	}
-	return result, err, description
+	return result, weight, err, description
 }
 
 func (w *Worker) filterWorker() (n Worker) {
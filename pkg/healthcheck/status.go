@@ -16,6 +16,7 @@ const (
 	Online
 	Offline
 	Maintenance
+	Draining
 )
 
 // StatusType contains the status
@@ -35,7 +36,7 @@ func (s *StatusType) UnmarshalText(text []byte) error {
 	var err error
 	t := strings.Replace(string(text), "\"", "", -1)
 	if _, ok := StringToStatusType[t]; !ok {
-		return fmt.Errorf("unknown status type1: %s (allowed are: automatic, online, offline and maintenance)", text)
+		return fmt.Errorf("unknown status type1: %s (allowed are: automatic, online, offline, maintenance and draining)", text)
 	}
 	s.Status = StringToStatusType[t]
 	return err
@@ -68,6 +69,7 @@ var StatusTypeToString = map[Status]string{
 	Online:      "online",
 	Offline:     "offline",
 	Maintenance: "maintance",
+	Draining:    "draining",
 }
 
 // StringToStatusType converts string to status
@@ -76,6 +78,7 @@ var StringToStatusType = map[string]Status{
 	"online":      Online,
 	"offline":     Offline,
 	"maintenance": Maintenance,
+	"draining":    Draining,
 }
 
 // IntToStatusType converts int to status
@@ -84,4 +87,5 @@ var IntToStatusType = map[int]Status{
 	1: Online,
 	2: Offline,
 	3: Maintenance,
+	4: Draining,
 }
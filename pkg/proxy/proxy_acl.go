@@ -9,22 +9,33 @@ import (
 
 // ACL is used by HTTP proxies for setting/removing headers, cookies or status code
 type ACL struct {
-	Action         string   `json:"action" toml:"action"`                   // remove, replace, add, deny
-	HeaderKey      string   `json:"header_key" toml:"header_key"`           // header key
-	HeaderValue    string   `json:"header_value" toml:"header_value"`       // header value
-	CookieKey      string   `json:"cookie_key" toml:"cookie_key"`           // cookie key
-	CookieValue    string   `json:"cookie_value" toml:"cookie_value"`       // cookie value
-	CookiePath     string   `json:"cookie_path" toml:"cookie_path"`         // cookie path
-	CookieExpire   duration `json:"cookie_expire" toml:"cookie_expire"`     // cookie expiry date
-	CookieSecure   *bool    `json:"cookie_secure" toml:"cookie_secure"`     // cookie secure
-	Cookiehttponly *bool    `json:"cookie_httponly" toml:"cookie_httponly"` // cookie httponly
-	ConditionType  string   `json:"conditiontype" toml:"conditiontype"`     // header, cookie, other?
-	ConditionMatch string   `json:"conditionmatch" toml:"conditionmatch"`   // header text (e.g. /^Content-Type: (.*)/(.*)$/i)
-	URLMatch       string   `json:"urlmatch" toml:"urlmatch"`               // url match #^/(.*)#
-	URLRewrite     string   `json:"urlrewrite" toml:"urlrewrite"`           // url rewrite /Other/Path/$1
-	StatusCode     int      `json:"status_code" toml:"status_code"`         // status code
-	URLPath        string   `json:"url_path" toml:"url_path"`               // request path to match this acl if provided
-	CIDRS          []string `json:"cidrs" toml:"cidrs"`                     // network cidr
+	Action          string            `json:"action" toml:"action"`                     // remove, replace, add, deny
+	HeaderKey       string            `json:"header_key" toml:"header_key"`             // header key
+	HeaderValue     string            `json:"header_value" toml:"header_value"`         // header value
+	CookieKey       string            `json:"cookie_key" toml:"cookie_key"`             // cookie key
+	CookieValue     string            `json:"cookie_value" toml:"cookie_value"`         // cookie value
+	CookiePath      string            `json:"cookie_path" toml:"cookie_path"`           // cookie path
+	CookieExpire    duration          `json:"cookie_expire" toml:"cookie_expire"`       // cookie expiry date
+	CookieSecure    *bool             `json:"cookie_secure" toml:"cookie_secure"`       // cookie secure
+	Cookiehttponly  *bool             `json:"cookie_httponly" toml:"cookie_httponly"`   // cookie httponly
+	ConditionType   string            `json:"conditiontype" toml:"conditiontype"`       // header, cookie, other?
+	ConditionMatch  string            `json:"conditionmatch" toml:"conditionmatch"`     // header text (e.g. /^Content-Type: (.*)/(.*)$/i)
+	URLMatch        string            `json:"urlmatch" toml:"urlmatch"`                 // url match #^/(.*)#
+	URLRewrite      string            `json:"urlrewrite" toml:"urlrewrite"`             // url rewrite /Other/Path/$1
+	StatusCode      int               `json:"status_code" toml:"status_code"`           // status code
+	URLPath         string            `json:"url_path" toml:"url_path"`                 // request path to match this acl if provided
+	CIDRS           []string          `json:"cidrs" toml:"cidrs"`                       // network cidr
+	ClientCert      map[string]string `json:"client_cert" toml:"client_cert"`           // client certificate attribute and regex to match
+	RateLimit       int               `json:"rate_limit" toml:"rate_limit"`             // requests allowed per rate period
+	RatePeriod      duration          `json:"rate_period" toml:"rate_period"`           // period of the rate limit, 1s if not set
+	ConnectionLimit int               `json:"connection_limit" toml:"connection_limit"` // concurrent requests allowed
+	LimitKey        string            `json:"limit_key" toml:"limit_key"`               // header identifying the client for limits, client ip if not set
+	LimitShared     bool              `json:"limit_shared" toml:"limit_shared"`         // share the rate limit counters with the cluster nodes
+	BodyMatch       string            `json:"body_match" toml:"body_match"`             // body regex to replace
+	BodyReplace     string            `json:"body_replace" toml:"body_replace"`         // replacement of the body_match ($1 for groups)
+	BodyInsert      string            `json:"body_insert" toml:"body_insert"`           // text to insert in the body
+	BodyMarker      string            `json:"body_marker" toml:"body_marker"`           // text in the body before which the body_insert is inserted
+	ContentType     string            `json:"content_type" toml:"content_type"`         // content type regex of bodies to rewrite, text, json, javascript and xml if not set
 }
 
 // ACLS contains a list of ACL
@@ -59,17 +70,22 @@ const (
 	removeMatch  = "remove"
 	denyMatch    = "deny"
 	allowMatch   = "allow"
+	limitMatch   = "limit"
+
+	clientCertMatch = "clientcert"
 )
 
 // ProcessRequest processes ACL's for request
 func (acl ACL) ProcessRequest(req *http.Request) (deny bool) {
 
 	// If we have a request path, see if we match this before processing this request
-	if acl.URLPath != "" && req.URL != nil {
-		regex, _ := regexp.Compile(acl.URLPath)
-		if regex.MatchString(req.URL.Path) == false {
-			return false
-		}
+	if !acl.matchURLPath(req) {
+		return false
+	}
+
+	// limits are applied by the backend
+	if acl.Action == limitMatch {
+		return false
 	}
 
 	switch acl.ConditionType {
@@ -79,7 +95,18 @@ func (acl ACL) ProcessRequest(req *http.Request) (deny bool) {
 	case cookieMatch:
 		return acl.processCookie(&req.Header, nil, "Cookie")
 
+	case clientCertMatch:
+		return acl.processClientCert(req)
+
 	default: // always executed
+		if len(acl.ClientCert) > 0 {
+			return acl.processClientCert(req)
+		}
+
+		if acl.BodyMatch != "" || acl.BodyInsert != "" {
+			return acl.processRequestBody(req)
+		}
+
 		if acl.URLMatch != "" {
 			return acl.processURI(req)
 		}
@@ -99,6 +126,18 @@ func (acl ACL) ProcessRequest(req *http.Request) (deny bool) {
 	return false
 }
 
+// matchURLPath returns true if the acl applies to the path of the request
+func (acl ACL) matchURLPath(req *http.Request) bool {
+	if acl.URLPath != "" && req.URL != nil {
+		regex, _ := regexp.Compile(acl.URLPath)
+		if regex.MatchString(req.URL.Path) == false {
+			return false
+		}
+	}
+
+	return true
+}
+
 // ProcessResponse processes ACL's for response
 func (acl ACL) ProcessResponse(res *http.Response) (deny bool) {
 
@@ -132,6 +171,10 @@ func (acl ACL) ProcessResponse(res *http.Response) (deny bool) {
 		return acl.processStatus(res)
 
 	default: // always executed
+		if acl.BodyMatch != "" || acl.BodyInsert != "" {
+			return acl.processResponseBody(res)
+		}
+
 		if acl.HeaderKey != "" {
 			return acl.processHeader(&res.Header)
 		}
@@ -200,5 +243,20 @@ func (acl ACL) String() string {
 	if len(acl.CIDRS) > 0 {
 		output += fmt.Sprintf(" CIDRS:%v", acl.CIDRS)
 	}
+	if len(acl.ClientCert) > 0 {
+		output += fmt.Sprintf(" ClientCert:%v", acl.ClientCert)
+	}
+	if acl.RateLimit > 0 || acl.ConnectionLimit > 0 {
+		output += fmt.Sprintf(" RateLimit:%d RatePeriod:%s ConnectionLimit:%d LimitKey:%s LimitShared:%t", acl.RateLimit, acl.RatePeriod, acl.ConnectionLimit, acl.LimitKey, acl.LimitShared)
+	}
+	if acl.BodyMatch != "" {
+		output += fmt.Sprintf(" Type:Body Match:%s Replace:%s", acl.BodyMatch, acl.BodyReplace)
+	}
+	if acl.BodyInsert != "" {
+		output += fmt.Sprintf(" Type:Body Insert:%s Marker:%s", acl.BodyInsert, acl.BodyMarker)
+	}
+	if acl.ContentType != "" {
+		output += fmt.Sprintf(" ContentType:%s", acl.ContentType)
+	}
 	return output
 }
@@ -0,0 +1,276 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/schubergphilis/mercury/pkg/logging"
+)
+
+const (
+	// bodyRewriteWindow is the longest text a body rewrite can match when streaming
+	bodyRewriteWindow = 4096
+	// bodyRewriteBufferSize is the size up to which bodies of a known length are rewritten in memory, to keep their Content-Length
+	bodyRewriteBufferSize = 1024 * 1024
+	// defaultBodyContentType matches the content types rewritten if no content_type is set
+	defaultBodyContentType = `^(text/|application/(json|javascript|xml|xhtml\+xml))`
+)
+
+// bodyRewriter replaces the matches of a regex in a stream
+// matches are found as long as they are not longer then the window
+type bodyRewriter struct {
+	src     io.ReadCloser
+	regex   *regexp.Regexp
+	replace []byte
+	limit   int // replacements left, -1 for no limit
+	window  int
+	in      []byte
+	out     []byte
+	eof     bool
+	err     error
+}
+
+func newBodyRewriter(src io.ReadCloser, regex *regexp.Regexp, replace string, limit int, window int) *bodyRewriter {
+	return &bodyRewriter{
+		src:     src,
+		regex:   regex,
+		replace: []byte(replace),
+		limit:   limit,
+		window:  window,
+	}
+}
+
+// Read returns the rewritten body
+func (r *bodyRewriter) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.eof {
+			if r.err != nil {
+				return 0, r.err
+			}
+			return 0, io.EOF
+		}
+		r.fill()
+	}
+
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// Close closes the source of the body
+func (r *bodyRewriter) Close() error {
+	return r.src.Close()
+}
+
+// fill reads from the source, and rewrites all text that can no longer be part of a match with text still to read
+func (r *bodyRewriter) fill() {
+	buf := make([]byte, 32*1024)
+	n, err := r.src.Read(buf)
+	r.in = append(r.in, buf[:n]...)
+	if err != nil {
+		r.eof = true
+		if err != io.EOF {
+			r.err = err
+		}
+	}
+
+	if !r.eof && len(r.in) < 2*r.window {
+		return
+	}
+
+	cut := len(r.in)
+	if !r.eof {
+		cut -= r.window
+	}
+
+	pos := 0
+	if r.limit != 0 {
+		for _, m := range r.regex.FindAllSubmatchIndex(r.in, -1) {
+			if m[0] >= cut || r.limit == 0 {
+				break
+			}
+			if m[0] == m[1] {
+				continue
+			}
+
+			r.out = append(r.out, r.in[pos:m[0]]...)
+			r.out = r.regex.Expand(r.out, r.replace, r.in, m)
+			pos = m[1]
+			if r.limit > 0 {
+				r.limit--
+			}
+		}
+	}
+
+	if pos < cut {
+		r.out = append(r.out, r.in[pos:cut]...)
+		pos = cut
+	}
+	r.in = append(r.in[:0], r.in[pos:]...)
+}
+
+// gunzipBody decompresses a body while it is read
+type gunzipBody struct {
+	io.Reader
+	src io.Closer
+}
+
+// Close closes the source of the body
+func (g *gunzipBody) Close() error {
+	return g.src.Close()
+}
+
+// bodyRewrite returns the regex and replacement of the body acl, and the number of replacements to do
+func (acl ACL) bodyRewrite() (*regexp.Regexp, string, int, error) {
+	if acl.BodyMatch != "" {
+		regex, err := regexp.Compile(acl.BodyMatch)
+		return regex, acl.BodyReplace, -1, err
+	}
+
+	if acl.BodyMarker == "" {
+		return nil, "", 0, fmt.Errorf("body_insert requires a body_marker")
+	}
+
+	// insert once, before the first marker
+	regex, err := regexp.Compile(regexp.QuoteMeta(acl.BodyMarker))
+	return regex, strings.Replace(acl.BodyInsert, "$", "$$", -1) + "${0}", 1, err
+}
+
+// rewriteBodyStream returns body with the rewrite applied while it is read, uncompressing and compressing gzip bodies
+func (acl ACL) rewriteBodyStream(body io.ReadCloser, gzipped bool) (io.ReadCloser, error) {
+	regex, replace, limit, err := acl.bodyRewrite()
+	if err != nil {
+		return nil, err
+	}
+
+	if !gzipped {
+		return newBodyRewriter(body, regex, replace, limit, bodyRewriteWindow), nil
+	}
+
+	gr, err := gzip.NewReader(body)
+	if err != nil {
+		return nil, err
+	}
+
+	rewriter := newBodyRewriter(&gunzipBody{Reader: gr, src: body}, regex, replace, limit, bodyRewriteWindow)
+	return newCompressBody(rewriter, "gzip", gzip.DefaultCompression, false, nil), nil
+}
+
+// processBody rewrites a body of a matching content type
+// returns the new body and its length, or -1 if the length is unknown
+func (acl ACL) processBody(header http.Header, body io.ReadCloser, length int64) (io.ReadCloser, int64, bool) {
+	log := logging.For("proxy/aclbody")
+	if body == nil || body == http.NoBody || length == 0 {
+		return body, length, false
+	}
+
+	contentType := acl.ContentType
+	if contentType == "" {
+		contentType = defaultBodyContentType
+	}
+
+	regex, err := regexp.Compile(contentType)
+	if err != nil {
+		log.WithError(err).Warn("unable to parse regex for content_type")
+		return body, length, false
+	}
+
+	if !regex.MatchString(strings.ToLower(header.Get("Content-Type"))) {
+		return body, length, false
+	}
+
+	gzipped := false
+	switch encoding := strings.ToLower(strings.TrimSpace(header.Get("Content-Encoding"))); encoding {
+	case "", "identity":
+	case "gzip", "x-gzip":
+		gzipped = true
+	default:
+		log.WithField("encoding", encoding).Debug("ACL body rewrite skipped, unsupported content encoding")
+		return body, length, false
+	}
+
+	// rewrite small bodies in memory, so we can give their new length
+	if length > 0 && length <= bodyRewriteBufferSize {
+		original, err := ioutil.ReadAll(body)
+		body.Close()
+		if err != nil {
+			log.WithError(err).Warn("unable to read body for rewrite")
+			return ioutil.NopCloser(bytes.NewReader(original)), int64(len(original)), false
+		}
+
+		rewritten, err := acl.rewriteBodyStream(ioutil.NopCloser(bytes.NewReader(original)), gzipped)
+		if err == nil {
+			var data []byte
+			data, err = ioutil.ReadAll(rewritten)
+			if err == nil {
+				log.WithField("length", len(data)).Debug("ACL body rewrite")
+				return ioutil.NopCloser(bytes.NewReader(data)), int64(len(data)), true
+			}
+		}
+
+		log.WithError(err).Warn("unable to rewrite body")
+		return ioutil.NopCloser(bytes.NewReader(original)), length, false
+	}
+
+	rewritten, err := acl.rewriteBodyStream(body, gzipped)
+	if err != nil {
+		log.WithError(err).Warn("unable to rewrite body")
+		return body, length, false
+	}
+
+	log.Debug("ACL body rewrite, streaming")
+	return rewritten, -1, true
+}
+
+// setBodyLength sets the Content-Length header of a rewritten body
+func setBodyLength(header http.Header, length int64) {
+	if length < 0 {
+		header.Del("Content-Length")
+		return
+	}
+
+	header.Set("Content-Length", fmt.Sprintf("%d", length))
+}
+
+// processRequestBody rewrites the body of a request
+func (acl ACL) processRequestBody(req *http.Request) (deny bool) {
+	body, length, ok := acl.processBody(req.Header, req.Body, req.ContentLength)
+	req.Body = body
+	if ok {
+		req.ContentLength = length
+		setBodyLength(req.Header, length)
+	}
+
+	return false
+}
+
+// processResponseBody rewrites the body of a response
+func (acl ACL) processResponseBody(res *http.Response) (deny bool) {
+	// partial and empty replies can not be rewritten
+	switch res.StatusCode {
+	case http.StatusSwitchingProtocols, http.StatusNoContent, http.StatusPartialContent, http.StatusNotModified:
+		return false
+	}
+
+	if res.Request != nil && res.Request.Method == "HEAD" {
+		return false
+	}
+
+	body, length, ok := acl.processBody(res.Header, res.Body, res.ContentLength)
+	res.Body = body
+	if ok {
+		res.ContentLength = length
+		setBodyLength(res.Header, length)
+
+		weakenETag(res.Header)
+		res.Header.Del("Content-MD5")
+	}
+
+	return false
+}
@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/schubergphilis/mercury/pkg/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func newBodyResponse(contentType string, body []byte, length int64) *http.Response {
+	res := &http.Response{
+		StatusCode:    200,
+		Header:        http.Header{},
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: length,
+	}
+	res.Header.Set("Content-Type", contentType)
+	res.Header.Set("ETag", `"abc"`)
+	if length >= 0 {
+		res.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+
+	return res
+}
+
+func TestBodyRewriterStream(t *testing.T) {
+	body := strings.Repeat("see http://backend.local:8080/page and ", 50)
+	expect := strings.Replace(body, "http://backend.local:8080/", "https://www.example.com/", -1)
+	regex := regexp.MustCompile(`http://backend\.local:8080/`)
+
+	// a small window and single byte reads put matches across read boundaries
+	r := newBodyRewriter(ioutil.NopCloser(iotest.OneByteReader(strings.NewReader(body))), regex, "https://www.example.com/", -1, 32)
+	result, err := ioutil.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, expect, string(result))
+
+	r = newBodyRewriter(ioutil.NopCloser(iotest.HalfReader(strings.NewReader(body))), regex, "https://www.example.com/", 1, 32)
+	result, _ = ioutil.ReadAll(r)
+	assert.Equal(t, strings.Replace(body, "http://backend.local:8080/", "https://www.example.com/", 1), string(result))
+}
+
+func TestBodyACL(t *testing.T) {
+	logging.Configure("stdout", "error")
+
+	replace := ACL{Action: "replace", BodyMatch: `http://(backend)\.local`, BodyReplace: "https://$1.example.com"}
+	insert := ACL{Action: "add", BodyInsert: "<div>Maintenance at $5</div>", BodyMarker: "</body>"}
+
+	// rewritten in memory, with the new length
+	body := []byte(`<html><body><a href="http://backend.local/">link</a></body></html>`)
+	res := newBodyResponse("text/html; charset=utf-8", body, int64(len(body)))
+	replace.ProcessResponse(res)
+	insert.ProcessResponse(res)
+	result, _ := ioutil.ReadAll(res.Body)
+	expect := `<html><body><a href="https://backend.example.com/">link</a><div>Maintenance at $5</div></body></html>`
+	assert.Equal(t, expect, string(result))
+	assert.Equal(t, int64(len(expect)), res.ContentLength)
+	assert.Equal(t, strconv.Itoa(len(expect)), res.Header.Get("Content-Length"))
+	assert.Equal(t, `W/"abc"`, res.Header.Get("ETag"))
+
+	// streamed if the length is unknown
+	res = newBodyResponse("text/html", body, -1)
+	replace.ProcessResponse(res)
+	result, _ = ioutil.ReadAll(res.Body)
+	assert.Equal(t, `<html><body><a href="https://backend.example.com/">link</a></body></html>`, string(result))
+	assert.Equal(t, int64(-1), res.ContentLength)
+	assert.Equal(t, "", res.Header.Get("Content-Length"))
+
+	// other content types are untouched
+	res = newBodyResponse("image/png", body, int64(len(body)))
+	replace.ProcessResponse(res)
+	result, _ = ioutil.ReadAll(res.Body)
+	assert.Equal(t, body, result)
+
+	// gzip bodies are uncompressed and compressed again
+	var compressed bytes.Buffer
+	gw := gzip.NewWriter(&compressed)
+	gw.Write(body)
+	gw.Close()
+	for _, length := range []int64{int64(compressed.Len()), -1} {
+		res = newBodyResponse("text/html", compressed.Bytes(), length)
+		res.Header.Set("Content-Encoding", "gzip")
+		replace.ProcessResponse(res)
+		data, _ := ioutil.ReadAll(res.Body)
+		gr, err := gzip.NewReader(bytes.NewReader(data))
+		if assert.Nil(t, err) {
+			result, _ = ioutil.ReadAll(gr)
+			assert.Equal(t, `<html><body><a href="https://backend.example.com/">link</a></body></html>`, string(result))
+		}
+		if length >= 0 {
+			assert.Equal(t, int64(len(data)), res.ContentLength)
+		}
+	}
+
+	// request bodies
+	req, _ := http.NewRequest("POST", "http://www.example.com/api", strings.NewReader(`{"url":"http://backend.local/"}`))
+	req.Header.Set("Content-Type", "application/json")
+	replace.ProcessRequest(req)
+	result, _ = ioutil.ReadAll(req.Body)
+	assert.Equal(t, `{"url":"https://backend.example.com/"}`, string(result))
+	assert.Equal(t, int64(len(result)), req.ContentLength)
+}
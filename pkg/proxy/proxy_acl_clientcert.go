@@ -0,0 +1,173 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/schubergphilis/mercury/pkg/logging"
+)
+
+// clientCertificate returns the client certificate of a request, and if it was verified against the trusted CA's
+func clientCertificate(req *http.Request) (cert *x509.Certificate, verified bool) {
+	if req == nil || req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		return nil, false
+	}
+
+	return req.TLS.PeerCertificates[0], len(req.TLS.VerifiedChains) > 0
+}
+
+// clientCertFingerprint returns the sha256 fingerprint of a certificate in lowercase hex
+func clientCertFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// clientCertAttribute returns the values of a certificate attribute
+func clientCertAttribute(cert *x509.Certificate, attribute string) ([]string, error) {
+	switch attribute {
+	case "subject":
+		return []string{cert.Subject.String()}, nil
+
+	case "subject_cn":
+		return []string{cert.Subject.CommonName}, nil
+
+	case "subject_o":
+		return cert.Subject.Organization, nil
+
+	case "subject_ou":
+		return cert.Subject.OrganizationalUnit, nil
+
+	case "issuer":
+		return []string{cert.Issuer.String()}, nil
+
+	case "issuer_cn":
+		return []string{cert.Issuer.CommonName}, nil
+
+	case "san_dns":
+		return cert.DNSNames, nil
+
+	case "san_email":
+		return cert.EmailAddresses, nil
+
+	case "san_ip":
+		var values []string
+		for _, ip := range cert.IPAddresses {
+			values = append(values, ip.String())
+		}
+		return values, nil
+
+	case "san_uri":
+		var values []string
+		for _, uri := range cert.URIs {
+			values = append(values, uri.String())
+		}
+		return values, nil
+
+	case "san":
+		var values []string
+		for _, san := range []string{"san_dns", "san_email", "san_ip", "san_uri"} {
+			v, _ := clientCertAttribute(cert, san)
+			values = append(values, v...)
+		}
+		return values, nil
+
+	case "fingerprint":
+		return []string{clientCertFingerprint(cert)}, nil
+	}
+
+	return nil, fmt.Errorf("Unknown client certificate attribute: %s", attribute)
+}
+
+// matchClientCert returns true if the client certificate matches all client_cert conditions
+// attributes other then the fingerprint are only trusted if the certificate was verified
+func (acl ACL) matchClientCert(req *http.Request) bool {
+	log := logging.For("proxy/matchclientcert")
+	cert, verified := clientCertificate(req)
+	if cert == nil {
+		return false
+	}
+
+	for attribute, match := range acl.ClientCert {
+		if attribute == "fingerprint" {
+			fingerprint := strings.ToLower(strings.Replace(match, ":", "", -1))
+			if fingerprint != clientCertFingerprint(cert) {
+				return false
+			}
+			continue
+		}
+
+		if !verified {
+			log.WithField("attribute", attribute).Debug("Client certificate is not verified, ignoring its attributes")
+			return false
+		}
+
+		values, err := clientCertAttribute(cert, attribute)
+		if err != nil {
+			log.WithError(err).Warn("Invalid client certificate condition")
+			return false
+		}
+
+		reg, err := regexp.Compile(match)
+		if err != nil {
+			log.WithField("match", match).WithError(err).Warn("Invalid regex while matching client certificate")
+			return false
+		}
+
+		matched := false
+		for _, value := range values {
+			if reg.MatchString(value) {
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+// processClientCert allows/denies on the client certificate, or edits headers if the certificate matches
+// returns true if we match a allow/deny acl
+func (acl ACL) processClientCert(req *http.Request) (match bool) {
+	if !acl.matchClientCert(req) {
+		return false
+	}
+
+	switch acl.Action {
+	case allowMatch, denyMatch:
+		return true
+	}
+
+	if acl.HeaderKey != "" || acl.ConditionMatch != "" {
+		acl.processHeader(&req.Header)
+	}
+
+	return false
+}
+
+// getClientCertAttributeValue returns the values of a client certificate attribute as comma separated string
+func getClientCertAttributeValue(req *http.Request, attribute string) (string, error) {
+	cert, verified := clientCertificate(req)
+	if cert == nil {
+		return "", nil
+	}
+
+	values, err := clientCertAttribute(cert, attribute)
+	if err != nil {
+		return "", err
+	}
+
+	if !verified && attribute != "fingerprint" {
+		return "", nil
+	}
+
+	return strings.Join(values, ","), nil
+}
@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/schubergphilis/mercury/pkg/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientCertACL(t *testing.T) {
+	logging.Configure("stdout", "error")
+
+	verified := httptest.NewRequest("GET", "https://www.example.com/api/foo", nil)
+	verified.TLS = &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{cert},
+		VerifiedChains:   [][]*x509.Certificate{{cert}},
+	}
+
+	unverified := httptest.NewRequest("GET", "https://www.example.com/api/foo", nil)
+	unverified.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+
+	nocert := httptest.NewRequest("GET", "https://www.example.com/api/foo", nil)
+	nocert.TLS = &tls.ConnectionState{}
+
+	fingerprint := clientCertFingerprint(cert)
+
+	testData := []struct {
+		name       string
+		acl        ACL
+		verified   bool
+		unverified bool
+	}{
+		{name: "subject cn", acl: ACL{Action: "allow", ClientCert: map[string]string{"subject_cn": "^www.example.org$"}}, verified: true},
+		{name: "subject o and ou", acl: ACL{Action: "allow", ClientCert: map[string]string{"subject_o": "^Internet Corporation", "subject_ou": "^Technology$"}}, verified: true},
+		{name: "wrong ou", acl: ACL{Action: "allow", ClientCert: map[string]string{"subject_o": "^Internet Corporation", "subject_ou": "^Sales$"}}},
+		{name: "san", acl: ACL{Action: "deny", ClientCert: map[string]string{"san_dns": "^example.edu$"}}, verified: true},
+		{name: "issuer", acl: ACL{Action: "allow", ClientCert: map[string]string{"issuer_cn": "DigiCert"}}, verified: true},
+		{name: "fingerprint", acl: ACL{Action: "allow", ClientCert: map[string]string{"fingerprint": fingerprint}}, verified: true, unverified: true},
+		{name: "other fingerprint", acl: ACL{Action: "allow", ClientCert: map[string]string{"fingerprint": "00:11"}}},
+		{name: "unknown attribute", acl: ACL{Action: "allow", ClientCert: map[string]string{"serial": "1"}}},
+		{name: "any certificate", acl: ACL{Action: "allow", ConditionType: "clientcert"}, verified: true, unverified: true},
+		{name: "other path", acl: ACL{Action: "allow", URLPath: "^/admin/", ClientCert: map[string]string{"subject_cn": "."}}},
+	}
+
+	for _, data := range testData {
+		t.Run(data.name, func(t *testing.T) {
+			assert.Equal(t, data.verified, data.acl.ProcessRequest(verified), "verified certificate")
+			assert.Equal(t, data.unverified, data.acl.ProcessRequest(unverified), "unverified certificate")
+			assert.False(t, data.acl.ProcessRequest(nocert), "no certificate")
+		})
+	}
+}
+
+func TestClientCertACLHeader(t *testing.T) {
+	logging.Configure("stdout", "error")
+
+	req := httptest.NewRequest("GET", "https://www.example.com/", nil)
+	req.TLS = &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{cert},
+		VerifiedChains:   [][]*x509.Certificate{{cert}},
+	}
+
+	value, err := getVariableValue("CLIENT_CERT_SUBJECT_CN", nil, nil, req)
+	assert.Nil(t, err)
+	assert.Equal(t, "www.example.org", value)
+
+	value, err = getVariableValue("CLIENT_CERT_FINGERPRINT", nil, nil, req)
+	assert.Nil(t, err)
+	assert.Equal(t, clientCertFingerprint(cert), value)
+
+	// only add the header to clients with a certificate of the organization
+	acl := ACL{Action: "add", HeaderKey: "X-Client-CN", HeaderValue: value, ClientCert: map[string]string{"subject_o": "^Internet Corporation"}}
+	assert.False(t, acl.ProcessRequest(req))
+	assert.Equal(t, value, req.Header.Get("X-Client-CN"))
+
+	other := httptest.NewRequest("GET", "https://www.example.com/", nil)
+	acl.ProcessRequest(other)
+	assert.Equal(t, "", other.Header.Get("X-Client-CN"))
+}
@@ -0,0 +1,218 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/schubergphilis/mercury/pkg/logging"
+)
+
+// LimitCounter is the number of requests a cluster node counted for a client in a rate limit window
+type LimitCounter struct {
+	Key    string        `json:"key"`
+	Window time.Time     `json:"window"`
+	Period time.Duration `json:"period"`
+	Count  int           `json:"count"`
+}
+
+// limitCounter counts the requests of a client in the current rate limit window
+type limitCounter struct {
+	window time.Time
+	period time.Duration
+	local  int
+	sent   int
+	shared bool
+	remote map[string]int
+}
+
+// limiter keeps the rate and connection limit counters of a backend
+type limiter struct {
+	sync.Mutex
+	counters    map[string]*limitCounter
+	connections map[string]int
+	cleaned     time.Time
+}
+
+func newLimiter() *limiter {
+	return &limiter{
+		counters:    make(map[string]*limitCounter),
+		connections: make(map[string]int),
+	}
+}
+
+// allowRequest counts a request of key, and returns false with the time until the window ends if the limit is reached
+// windows are aligned on the clock, so cluster nodes count in the same windows
+func (l *limiter) allowRequest(key string, limit int, period time.Duration, shared bool, now time.Time) (bool, time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+	l.cleanup(now)
+
+	window := now.Truncate(period)
+	c, ok := l.counters[key]
+	if !ok || c.window.Before(window) {
+		c = &limitCounter{window: window, period: period, shared: shared, remote: make(map[string]int)}
+		l.counters[key] = c
+	}
+
+	total := c.local
+	for _, count := range c.remote {
+		total += count
+	}
+
+	if total >= limit {
+		return false, window.Add(period).Sub(now)
+	}
+
+	c.local++
+	return true, 0
+}
+
+// cleanup removes the counters of windows that ended, once a minute
+func (l *limiter) cleanup(now time.Time) {
+	if now.Sub(l.cleaned) < time.Minute {
+		return
+	}
+
+	for key, c := range l.counters {
+		if c.window.Add(c.period).Before(now) {
+			delete(l.counters, key)
+		}
+	}
+	l.cleaned = now
+}
+
+// acquire adds a connection of key, and returns false if the limit is reached
+func (l *limiter) acquire(key string, limit int) bool {
+	l.Lock()
+	defer l.Unlock()
+	if l.connections[key] >= limit {
+		return false
+	}
+
+	l.connections[key]++
+	return true
+}
+
+// release removes a connection of key
+func (l *limiter) release(key string) {
+	l.Lock()
+	defer l.Unlock()
+	l.connections[key]--
+	if l.connections[key] <= 0 {
+		delete(l.connections, key)
+	}
+}
+
+// LimitCounters returns the counters of shared rate limits that changed since the last call, to send to the cluster nodes
+func (b *Backend) LimitCounters() (counters []LimitCounter) {
+	b.limits.Lock()
+	defer b.limits.Unlock()
+	for key, c := range b.limits.counters {
+		if c.shared && c.local != c.sent {
+			counters = append(counters, LimitCounter{Key: key, Window: c.window, Period: c.period, Count: c.local})
+			c.sent = c.local
+		}
+	}
+
+	return
+}
+
+// AddRemoteLimitCounters adds the counters of a cluster node to the rate limits
+func (b *Backend) AddRemoteLimitCounters(node string, counters []LimitCounter) {
+	b.limits.Lock()
+	defer b.limits.Unlock()
+	for _, counter := range counters {
+		c, ok := b.limits.counters[counter.Key]
+		if !ok || c.window.Before(counter.Window) {
+			c = &limitCounter{window: counter.Window, period: counter.Period, shared: true, remote: make(map[string]int)}
+			b.limits.counters[counter.Key] = c
+		}
+
+		if c.window.Equal(counter.Window) {
+			c.remote[node] = counter.Count
+		}
+	}
+}
+
+// limitReleases contains the connections of a request to release when it is done
+type limitReleases struct {
+	sync.Mutex
+	release []func()
+}
+
+// limitReleasesContextKey is the context key of the connections of a request
+type limitReleasesContextKey struct{}
+
+// releaseLimits releases the connection limits a request acquired when it is done
+func releaseLimits(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		releases := &limitReleases{}
+		next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), limitReleasesContextKey{}, releases)))
+
+		releases.Lock()
+		defer releases.Unlock()
+		for _, release := range releases.release {
+			release()
+		}
+	})
+}
+
+// limitKey returns the key identifying the client, the value of the limit_key header or the client ip
+func (acl ACL) limitKey(req *http.Request) string {
+	if acl.LimitKey != "" {
+		if value := req.Header.Get(acl.LimitKey); value != "" {
+			return "header:" + value
+		}
+	}
+
+	return "ip:" + stringToClientIP(req.RemoteAddr).IP
+}
+
+// processLimits applies the limit ACL's of the backend
+// returns true and the seconds after which the client may retry if the client reached a limit
+func (b *Backend) processLimits(req *http.Request) (bool, int) {
+	log := logging.For("proxy/limits").WithField("clientip", stringToClientIP(req.RemoteAddr).IP).WithField("requestid", requestID(req))
+	releases, _ := req.Context().Value(limitReleasesContextKey{}).(*limitReleases)
+	for id, acl := range b.InboundACL {
+		if acl.Action != limitMatch || !acl.matchURLPath(req) {
+			continue
+		}
+
+		key := fmt.Sprintf("%d:%s", id, acl.limitKey(req))
+		if acl.RateLimit > 0 {
+			period := acl.RatePeriod.Duration
+			if period <= 0 {
+				period = time.Second
+			}
+
+			if ok, retry := b.limits.allowRequest(key, acl.RateLimit, period, acl.LimitShared, time.Now()); !ok {
+				log.WithField("key", key).WithField("limit", acl.RateLimit).WithField("period", period).Info("Client reached rate limit")
+				return true, int(math.Ceil(retry.Seconds()))
+			}
+		}
+
+		// connections can only be limited if they are released when done
+		if acl.ConnectionLimit > 0 && releases != nil {
+			if !b.limits.acquire(key, acl.ConnectionLimit) {
+				log.WithField("key", key).WithField("limit", acl.ConnectionLimit).Info("Client reached connection limit")
+				return true, 1
+			}
+
+			releases.Lock()
+			releases.release = append(releases.release, func() { b.limits.release(key) })
+			releases.Unlock()
+		}
+	}
+
+	return false, 0
+}
+
+// LoadLimitPage preloads the page shown to clients that reached a limit
+func (b *Backend) LoadLimitPage(e ErrorPage) error {
+	b.LimitPage = e
+	return b.LimitPage.load()
+}
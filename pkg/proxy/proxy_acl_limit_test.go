@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/schubergphilis/mercury/pkg/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimit(t *testing.T) {
+	logging.Configure("stdout", "error")
+
+	b := NewBackend("backend", "roundrobin", "http", []string{}, 10, ErrorPage{}, ErrorPage{})
+	b.SetACL("in", []ACL{
+		{Action: "limit", RateLimit: 2, RatePeriod: duration{time.Hour}, LimitKey: "X-API-Key", LimitShared: true},
+		{Action: "limit", URLPath: "^/upload", RateLimit: 1, RatePeriod: duration{time.Hour}},
+	})
+
+	request := func(ip, key, path string) *http.Request {
+		req := httptest.NewRequest("GET", "http://www.example.com"+path, nil)
+		req.RemoteAddr = ip + ":1234"
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		return req
+	}
+
+	for i := 0; i < 2; i++ {
+		limited, _ := b.processLimits(request("192.0.2.1", "", "/"))
+		assert.False(t, limited)
+	}
+
+	limited, retry := b.processLimits(request("192.0.2.1", "", "/"))
+	assert.True(t, limited)
+	assert.True(t, retry > 0)
+
+	// other clients have their own limit
+	limited, _ = b.processLimits(request("192.0.2.2", "", "/"))
+	assert.False(t, limited)
+
+	// clients are identified by their api key if they have one
+	limited, _ = b.processLimits(request("192.0.2.1", "key1", "/"))
+	assert.False(t, limited)
+
+	// limits only apply to their path
+	limited, _ = b.processLimits(request("192.0.2.3", "", "/upload"))
+	assert.False(t, limited)
+	limited, _ = b.processLimits(request("192.0.2.3", "", "/upload"))
+	assert.True(t, limited)
+
+	// only shared counters are sent to the cluster, requests of other nodes count as well
+	counters := b.LimitCounters()
+	assert.Len(t, counters, 4)
+	assert.Len(t, b.LimitCounters(), 0)
+
+	other := NewBackend("backend", "roundrobin", "http", []string{}, 10, ErrorPage{}, ErrorPage{})
+	other.SetACL("in", b.InboundACL)
+	other.AddRemoteLimitCounters("node1", counters)
+	limited, _ = other.processLimits(request("192.0.2.1", "", "/"))
+	assert.True(t, limited)
+	limited, _ = other.processLimits(request("192.0.2.2", "", "/"))
+	assert.False(t, limited)
+}
+
+func TestConnectionLimit(t *testing.T) {
+	logging.Configure("stdout", "error")
+
+	b := NewBackend("backend", "roundrobin", "http", []string{}, 10, ErrorPage{}, ErrorPage{})
+	b.SetACL("in", []ACL{{Action: "limit", ConnectionLimit: 1}})
+
+	release := make(chan bool)
+	statuses := make(chan bool)
+	handler := releaseLimits(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		limited, _ := b.processLimits(req)
+		statuses <- limited
+		if !limited {
+			<-release
+		}
+	}))
+
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://www.example.com/", nil))
+	assert.False(t, <-statuses)
+
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://www.example.com/", nil))
+	assert.True(t, <-statuses, "second concurrent request should be limited")
+
+	release <- true
+	time.Sleep(10 * time.Millisecond)
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://www.example.com/", nil))
+	assert.False(t, <-statuses, "request after the first finished should be allowed")
+	release <- true
+}
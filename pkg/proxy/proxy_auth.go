@@ -0,0 +1,436 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt"
+
+	"github.com/schubergphilis/mercury/pkg/logging"
+)
+
+// BackendAuth is the authentication gateway in front of a backend
+// forward sends every request to an external auth endpoint, oidc logs users in at an OpenID Connect issuer
+type BackendAuth struct {
+	Type            string       `json:"type" toml:"type"`                         // forward or oidc
+	URL             string       `json:"url" toml:"url"`                           // forward: url of the auth endpoint
+	RequestHeaders  []string     `json:"request_headers" toml:"request_headers"`   // forward: client headers sent to the auth endpoint
+	ResponseHeaders []string     `json:"response_headers" toml:"response_headers"` // forward: headers of the auth endpoint reply passed to the backend
+	Timeout         int          `json:"timeout" toml:"timeout"`                   // forward: seconds to wait for the auth endpoint
+	Issuer          string       `json:"issuer" toml:"issuer"`                     // oidc: url of the issuer
+	ClientID        string       `json:"client_id" toml:"client_id"`               // oidc: client id registered at the issuer
+	ClientSecret    string       `json:"-" toml:"client_secret"`                   // oidc: client secret, empty for public clients
+	RedirectURL     string       `json:"redirect_url" toml:"redirect_url"`         // oidc: url on the backend hostname the issuer redirects back to
+	Scopes          []string     `json:"scopes" toml:"scopes"`                     // oidc: scopes to request
+	UsernameClaim   string       `json:"username_claim" toml:"username_claim"`     // oidc: claim containing the username
+	GroupsClaim     string       `json:"groups_claim" toml:"groups_claim"`         // oidc: claim containing the groups
+	AllowedUsers    []string     `json:"allowed_users" toml:"allowed_users"`       // oidc: users allowed to login, all if users and groups are empty
+	AllowedGroups   []string     `json:"allowed_groups" toml:"allowed_groups"`     // oidc: groups allowed to login
+	CookieName      string       `json:"cookie_name" toml:"cookie_name"`           // oidc: name of the session cookie
+	CookieSecret    string       `json:"-" toml:"cookie_secret"`                   // oidc: secret to sign the session cookie, must be the same on all cluster nodes
+	SessionTimeout  int          `json:"session_timeout" toml:"session_timeout"`   // oidc: seconds a login is valid
+	UserHeader      string       `json:"user_header" toml:"user_header"`           // oidc: header containing the username sent to the backend
+	GroupsHeader    string       `json:"groups_header" toml:"groups_header"`       // oidc: header containing the groups sent to the backend
+	Provider        OIDCProvider `json:"-" toml:"-"`                               // oidc: performs the login at the issuer
+}
+
+// OIDCProvider performs the login of users at an OpenID Connect issuer
+type OIDCProvider interface {
+	AuthCodeURL(state, nonce, verifier string) (string, error)
+	Exchange(code, nonce, verifier string) (username string, groups []string, err error)
+}
+
+// AuthGateway authenticates requests before they are sent to a backend
+type AuthGateway struct {
+	BackendAuth
+	client   *http.Client
+	callback string
+}
+
+// authLoginDuration is how long a user has to login at the issuer
+var authLoginDuration = 10 * time.Minute
+
+// Validate validates the auth settings
+func (a BackendAuth) Validate() error {
+	switch a.Type {
+	case "":
+		return nil
+
+	case "forward":
+		if u, err := url.Parse(a.URL); err != nil || !u.IsAbs() {
+			return fmt.Errorf("forward auth requires a valid url")
+		}
+
+	case "oidc":
+		if a.Issuer == "" || a.ClientID == "" {
+			return fmt.Errorf("oidc auth requires an issuer and client_id")
+		}
+
+		if u, err := url.Parse(a.RedirectURL); err != nil || !u.IsAbs() || u.Path == "" {
+			return fmt.Errorf("oidc auth requires a valid redirect_url")
+		}
+
+		if len(a.CookieSecret) < 32 {
+			return fmt.Errorf("oidc auth requires a cookie_secret of atleast 32 characters")
+		}
+
+	default:
+		return fmt.Errorf("unknown auth type: %s", a.Type)
+	}
+
+	return nil
+}
+
+// NewAuthGateway returns the authentication gateway for the auth settings
+func NewAuthGateway(auth BackendAuth) *AuthGateway {
+	a := &AuthGateway{
+		BackendAuth: auth,
+		client: &http.Client{
+			Timeout: time.Duration(auth.Timeout) * time.Second,
+			// the redirects of the auth endpoint are for the client
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+
+	if u, err := url.Parse(auth.RedirectURL); err == nil {
+		a.callback = u.Path
+	}
+
+	return a
+}
+
+// SetAuth sets the authentication gateway of the backend
+func (b *Backend) SetAuth(auth BackendAuth) {
+	b.sync.Lock()
+	defer b.sync.Unlock()
+	if auth.Type == "" {
+		b.Auth = nil
+		return
+	}
+
+	b.Auth = NewAuthGateway(auth)
+}
+
+// authGateway returns the authentication gateway of the backend, nil if there is none
+func (b *Backend) authGateway() *AuthGateway {
+	b.sync.RLock()
+	defer b.sync.RUnlock()
+	return b.Auth
+}
+
+// Authenticate authenticates a request and adds the identity of the user to it
+// returns the response to send to the client if the request may not pass to the backend
+func (a *AuthGateway) Authenticate(req *http.Request) *http.Response {
+	switch a.Type {
+	case "forward":
+		return a.forwardAuth(req)
+
+	case "oidc":
+		return a.oidcAuth(req)
+	}
+
+	return customStatusPage(500, "Internal Server Error - unknown auth type", req)
+}
+
+// forwardAuth asks the auth endpoint if the request is allowed, any other reply then 2xx is sent to the client
+func (a *AuthGateway) forwardAuth(req *http.Request) *http.Response {
+	clientAddr := stringToClientIP(req.RemoteAddr)
+	log := logging.For("proxy/forwardauth").WithField("clientip", clientAddr.IP).WithField("url", a.URL).WithField("requestid", requestID(req))
+
+	authReq, err := http.NewRequest("GET", a.URL, nil)
+	if err != nil {
+		log.WithError(err).Warn("Invalid auth request")
+		return customStatusPage(500, "Internal Server Error - invalid auth request", req)
+	}
+
+	for _, key := range a.RequestHeaders {
+		for _, value := range req.Header[http.CanonicalHeaderKey(key)] {
+			authReq.Header.Add(key, value)
+		}
+	}
+
+	proto := "http"
+	if req.TLS != nil {
+		proto = "https"
+	}
+
+	authReq.Header.Set("X-Forwarded-Method", req.Method)
+	authReq.Header.Set("X-Forwarded-Proto", proto)
+	authReq.Header.Set("X-Forwarded-Host", req.Host)
+	authReq.Header.Set("X-Forwarded-Uri", req.URL.RequestURI())
+	authReq.Header.Set("X-Forwarded-For", clientAddr.IP)
+
+	res, err := a.client.Do(authReq)
+	if err != nil {
+		log.WithError(err).Warn("Auth endpoint unavailable")
+		return customStatusPage(502, "Bad Gateway - authentication unavailable", req)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		// headers of the auth endpoint replace those of the client, so they can not be forged
+		for _, key := range a.ResponseHeaders {
+			req.Header.Del(key)
+			for _, value := range res.Header[http.CanonicalHeaderKey(key)] {
+				req.Header.Add(key, value)
+			}
+		}
+		return nil
+	}
+
+	log.WithField("statuscode", res.StatusCode).Debug("Auth endpoint denied request")
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return customStatusPage(502, "Bad Gateway - authentication unavailable", req)
+	}
+
+	reply := &http.Response{
+		StatusCode:    res.StatusCode,
+		Status:        res.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        res.Header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+	reply.Header.Del("Connection")
+	reply.Header.Del("Transfer-Encoding")
+	reply.Header.Set("Content-Length", fmt.Sprintf("%d", len(body)))
+
+	return reply
+}
+
+// oidcAuth passes requests with a valid session cookie, and sends other clients to the issuer to login
+func (a *AuthGateway) oidcAuth(req *http.Request) *http.Response {
+	log := logging.For("proxy/oidcauth").WithField("clientip", stringToClientIP(req.RemoteAddr).IP).WithField("hostname", req.Host).WithField("requestid", requestID(req))
+	if req.URL.Path == a.callback {
+		return a.oidcCallback(req)
+	}
+
+	if cookie, err := req.Cookie(a.CookieName); err == nil {
+		claims, err := a.parseCookie(cookie.Value, "session")
+		if err == nil {
+			username, _ := claims["sub"].(string)
+			var groups []string
+			if g, ok := claims["groups"].([]interface{}); ok {
+				for _, group := range g {
+					groups = append(groups, fmt.Sprintf("%v", group))
+				}
+			}
+
+			// the allowed users and groups can change during a session
+			if !a.allowed(username, groups) {
+				log.WithField("username", username).Info("User is no longer allowed")
+				return customStatusPage(403, "Forbidden - user not allowed", req)
+			}
+
+			a.setIdentity(req, username, groups)
+			return nil
+		}
+		log.WithError(err).Debug("Invalid session cookie")
+	}
+
+	if req.Method != "GET" && req.Method != "HEAD" {
+		return customStatusPage(401, "Unauthorized - login required", req)
+	}
+
+	state, nonce, verifier := randomString(), randomString(), randomString()
+	authURL, err := a.Provider.AuthCodeURL(state, nonce, verifier)
+	if err != nil {
+		log.WithError(err).Warn("Unable to reach the oidc issuer")
+		return customStatusPage(502, "Bad Gateway - authentication unavailable", req)
+	}
+
+	login, err := a.signCookie(jwt.MapClaims{
+		"typ":      "login",
+		"state":    state,
+		"nonce":    nonce,
+		"verifier": verifier,
+		"uri":      req.URL.RequestURI(),
+		"exp":      time.Now().Add(authLoginDuration).Unix(),
+	})
+	if err != nil {
+		return customStatusPage(500, "Internal Server Error - unable to start login", req)
+	}
+
+	res := redirectResponse(authURL, req)
+	res.Header.Add("Set-Cookie", a.cookie(req, a.CookieName+"_login", login, int(authLoginDuration.Seconds())).String())
+	return res
+}
+
+// oidcCallback finishes the login when the issuer redirects the user back, and sets the session cookie
+func (a *AuthGateway) oidcCallback(req *http.Request) *http.Response {
+	log := logging.For("proxy/oidcauth").WithField("clientip", stringToClientIP(req.RemoteAddr).IP).WithField("hostname", req.Host).WithField("requestid", requestID(req))
+	cookie, err := req.Cookie(a.CookieName + "_login")
+	if err != nil {
+		return customStatusPage(400, "Bad Request - no login in progress", req)
+	}
+
+	login, err := a.parseCookie(cookie.Value, "login")
+	if err != nil {
+		log.WithError(err).Info("Invalid login cookie")
+		return customStatusPage(400, "Bad Request - no login in progress", req)
+	}
+
+	query := req.URL.Query()
+	state, _ := login["state"].(string)
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(query.Get("state"))) != 1 {
+		return customStatusPage(400, "Bad Request - invalid login state", req)
+	}
+
+	if e := query.Get("error"); e != "" {
+		log.WithField("error", e).WithField("description", query.Get("error_description")).Info("Login failed at the oidc issuer")
+		return customStatusPage(403, "Forbidden - login failed", req)
+	}
+
+	nonce, _ := login["nonce"].(string)
+	verifier, _ := login["verifier"].(string)
+	username, groups, err := a.Provider.Exchange(query.Get("code"), nonce, verifier)
+	if err != nil {
+		log.WithError(err).Info("Login failed")
+		return customStatusPage(403, "Forbidden - login failed", req)
+	}
+
+	if !a.allowed(username, groups) {
+		log.WithField("username", username).Info("User is not allowed to login")
+		return customStatusPage(403, "Forbidden - user not allowed", req)
+	}
+
+	session, err := a.signCookie(jwt.MapClaims{
+		"typ":    "session",
+		"sub":    username,
+		"groups": groups,
+		"exp":    time.Now().Add(time.Duration(a.SessionTimeout) * time.Second).Unix(),
+	})
+	if err != nil {
+		return customStatusPage(500, "Internal Server Error - unable to create session", req)
+	}
+
+	// only redirect to a path on this host
+	uri, _ := login["uri"].(string)
+	if !strings.HasPrefix(uri, "/") || strings.HasPrefix(uri, "//") {
+		uri = "/"
+	}
+
+	log.WithField("username", username).Info("User logged in")
+	res := redirectResponse(uri, req)
+	res.Header.Add("Set-Cookie", a.cookie(req, a.CookieName, session, a.SessionTimeout).String())
+	res.Header.Add("Set-Cookie", a.cookie(req, a.CookieName+"_login", "", -1).String())
+	return res
+}
+
+// allowed returns true if the user or one of its groups may login
+func (a *AuthGateway) allowed(username string, groups []string) bool {
+	if len(a.AllowedUsers) == 0 && len(a.AllowedGroups) == 0 {
+		return true
+	}
+
+	for _, user := range a.AllowedUsers {
+		if user == username {
+			return true
+		}
+	}
+
+	for _, allowed := range a.AllowedGroups {
+		for _, group := range groups {
+			if allowed == group {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// setIdentity replaces the identity headers of the client with those of the logged in user, and hides the session cookie from the backend
+func (a *AuthGateway) setIdentity(req *http.Request, username string, groups []string) {
+	req.Header.Del(a.UserHeader)
+	req.Header.Del(a.GroupsHeader)
+	req.Header.Set(a.UserHeader, username)
+	if len(groups) > 0 {
+		req.Header.Set(a.GroupsHeader, strings.Join(groups, ","))
+	}
+
+	cookies := req.Cookies()
+	req.Header.Del("Cookie")
+	for _, cookie := range cookies {
+		if cookie.Name != a.CookieName && cookie.Name != a.CookieName+"_login" {
+			req.AddCookie(cookie)
+		}
+	}
+}
+
+// signCookie returns the signed cookie value containing claims
+// the redirect url is the audience, so backends sharing the cookie secret and name do not accept each others cookies
+func (a *AuthGateway) signCookie(claims jwt.MapClaims) (string, error) {
+	claims["aud"] = a.RedirectURL
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(a.CookieSecret))
+}
+
+// parseCookie validates a signed cookie value of type typ and returns its claims
+func (a *AuthGateway) parseCookie(value, typ string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(value, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(a.CookieSecret), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if claims["typ"] != typ {
+		return nil, fmt.Errorf("cookie is not a %s cookie", typ)
+	}
+
+	if aud, _ := claims["aud"].(string); aud != a.RedirectURL {
+		return nil, fmt.Errorf("cookie is not for %s", a.RedirectURL)
+	}
+
+	if _, ok := claims["exp"]; !ok {
+		return nil, fmt.Errorf("cookie has no expiry")
+	}
+
+	return claims, nil
+}
+
+// cookie returns an auth cookie, a negative maxAge removes it
+func (a *AuthGateway) cookie(req *http.Request, name, value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   req.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// redirectResponse returns a response redirecting the client to location
+func redirectResponse(location string, req *http.Request) *http.Response {
+	res := customStatusPage(http.StatusFound, http.StatusText(http.StatusFound), req)
+	res.Header.Set("Location", location)
+	return res
+}
+
+// randomString returns a random url safe string
+func randomString() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
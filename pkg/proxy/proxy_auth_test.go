@@ -0,0 +1,165 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/schubergphilis/mercury/pkg/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForwardAuth(t *testing.T) {
+	logging.Configure("stdout", "error")
+
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.Header().Set("Location", "https://login.example.com/?rd="+r.Header.Get("X-Forwarded-Uri"))
+			w.WriteHeader(http.StatusFound)
+			return
+		}
+		w.Header().Set("X-Auth-User", "alice")
+	}))
+	defer endpoint.Close()
+
+	auth := NewAuthGateway(BackendAuth{Type: "forward", URL: endpoint.URL, RequestHeaders: []string{"Authorization"}, ResponseHeaders: []string{"X-Auth-User"}, Timeout: 5})
+
+	req := httptest.NewRequest("GET", "https://www.example.com/app?x=1", nil)
+	res := auth.Authenticate(req)
+	if assert.NotNil(t, res) {
+		assert.Equal(t, http.StatusFound, res.StatusCode)
+		assert.Equal(t, "https://login.example.com/?rd=/app?x=1", res.Header.Get("Location"))
+	}
+
+	req = httptest.NewRequest("GET", "https://www.example.com/app", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("X-Auth-User", "mallory")
+	assert.Nil(t, auth.Authenticate(req))
+	assert.Equal(t, []string{"alice"}, req.Header["X-Auth-User"])
+
+	endpoint.Close()
+	res = auth.Authenticate(req)
+	if assert.NotNil(t, res) {
+		assert.Equal(t, http.StatusBadGateway, res.StatusCode)
+	}
+}
+
+// testOIDCProvider logs in alice for any code, if the nonce and verifier match
+type testOIDCProvider struct {
+	nonce    string
+	verifier string
+}
+
+func (p *testOIDCProvider) AuthCodeURL(state, nonce, verifier string) (string, error) {
+	p.nonce, p.verifier = nonce, verifier
+	return "https://issuer.example.com/authorize?state=" + url.QueryEscape(state), nil
+}
+
+func (p *testOIDCProvider) Exchange(code, nonce, verifier string) (string, []string, error) {
+	if nonce != p.nonce || verifier != p.verifier {
+		return "", nil, fmt.Errorf("invalid login")
+	}
+	return "alice", []string{"webteam"}, nil
+}
+
+func TestOIDCAuth(t *testing.T) {
+	logging.Configure("stdout", "error")
+
+	config := BackendAuth{
+		Type:           "oidc",
+		Issuer:         "https://issuer.example.com",
+		ClientID:       "app",
+		RedirectURL:    "https://www.example.com/oauth2/callback",
+		AllowedGroups:  []string{"webteam"},
+		CookieName:     "mercauth",
+		CookieSecret:   "01234567890123456789012345678901",
+		SessionTimeout: 60,
+		UserHeader:     "X-Auth-User",
+		GroupsHeader:   "X-Auth-Groups",
+		Provider:       &testOIDCProvider{},
+	}
+	assert.Nil(t, config.Validate())
+	auth := NewAuthGateway(config)
+
+	// without session, the user is sent to the issuer
+	res := auth.Authenticate(httptest.NewRequest("GET", "https://www.example.com/app?x=1", nil))
+	if !assert.NotNil(t, res) || !assert.Equal(t, http.StatusFound, res.StatusCode) {
+		return
+	}
+	location, _ := url.Parse(res.Header.Get("Location"))
+	login := res.Cookies()[0]
+
+	// the issuer redirects back with the state
+	callback := httptest.NewRequest("GET", "https://www.example.com/oauth2/callback?code=code&state="+url.QueryEscape(location.Query().Get("state")), nil)
+	callback.AddCookie(login)
+	res = auth.Authenticate(callback)
+	if !assert.NotNil(t, res) || !assert.Equal(t, http.StatusFound, res.StatusCode) {
+		return
+	}
+	assert.Equal(t, "/app?x=1", res.Header.Get("Location"))
+	session := res.Cookies()[0]
+	assert.Equal(t, "mercauth", session.Name)
+
+	// a forged state is refused
+	forged := httptest.NewRequest("GET", "https://www.example.com/oauth2/callback?code=code&state=other", nil)
+	forged.AddCookie(login)
+	res = auth.Authenticate(forged)
+	if assert.NotNil(t, res) {
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	}
+
+	// with session, the identity is passed to the backend
+	req := httptest.NewRequest("POST", "https://www.example.com/app", nil)
+	req.Header.Set("X-Auth-User", "mallory")
+	req.AddCookie(session)
+	req.AddCookie(&http.Cookie{Name: "other", Value: "value"})
+	assert.Nil(t, auth.Authenticate(req))
+	assert.Equal(t, []string{"alice"}, req.Header["X-Auth-User"])
+	assert.Equal(t, "webteam", req.Header.Get("X-Auth-Groups"))
+	assert.Equal(t, "other=value", req.Header.Get("Cookie"))
+
+	// a session signed with another secret is refused
+	other := NewAuthGateway(config)
+	other.CookieSecret = "other-secret-other-secret-other-secret"
+	req = httptest.NewRequest("POST", "https://www.example.com/app", nil)
+	req.AddCookie(session)
+	res = other.Authenticate(req)
+	if assert.NotNil(t, res) {
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	}
+
+	// a session of another backend sharing the secret and cookie name is refused
+	other = NewAuthGateway(config)
+	other.RedirectURL = "https://admin.example.com/oauth2/callback"
+	req = httptest.NewRequest("POST", "https://admin.example.com/app", nil)
+	req.AddCookie(session)
+	res = other.Authenticate(req)
+	if assert.NotNil(t, res) {
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	}
+
+	// a session of a user that is no longer allowed is refused
+	other = NewAuthGateway(config)
+	other.AllowedGroups = []string{"admins"}
+	req = httptest.NewRequest("POST", "https://www.example.com/app", nil)
+	req.AddCookie(session)
+	res = other.Authenticate(req)
+	if assert.NotNil(t, res) {
+		assert.Equal(t, http.StatusForbidden, res.StatusCode)
+	}
+}
+
+func TestBackendAuthValidate(t *testing.T) {
+	invalid := []BackendAuth{
+		{Type: "basic"},
+		{Type: "forward", URL: "/auth"},
+		{Type: "oidc", ClientID: "app", RedirectURL: "https://www.example.com/callback", CookieSecret: "01234567890123456789012345678901"},
+		{Type: "oidc", Issuer: "https://issuer", ClientID: "app", RedirectURL: "https://www.example.com/callback", CookieSecret: "short"},
+	}
+
+	for _, auth := range invalid {
+		assert.NotNil(t, auth.Validate(), "%+v", auth)
+	}
+}
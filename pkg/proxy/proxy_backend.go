@@ -28,6 +28,20 @@ type Backend struct {
 	Uptime          time.Time
 	ErrorPage       ErrorPage
 	MaintenancePage ErrorPage
+	LimitPage       ErrorPage
+	DrainTimeout    time.Duration
+	Auth            *AuthGateway
+	Routes          []*Route
+	SplitWeight     int
+	Mirror          MirrorConfig
+	Timeouts        BackendTimeouts
+	Websocket       WebsocketConfig
+	mirrorStats     MirrorStatistics
+	websocketStats  WebsocketStatistics
+	websockets      map[*websocketConn]struct{}
+	statusPages     statusPages
+	routeHits       map[string]int64
+	limits          *limiter
 }
 
 // NewBackend creates a new backend
@@ -42,6 +56,7 @@ func NewBackend(uuid string, balancemode string, connectmode string, hostname []
 		Uptime:          time.Now(),
 		ErrorPage:       errorPage,
 		MaintenancePage: maintenancePage,
+		limits:          newLimiter(),
 	}
 	return b
 }
@@ -62,25 +77,43 @@ func remove(slice []*BackendNode, s int) []*BackendNode {
 	return append(slice[:s], slice[s+1:]...)
 }
 
-// UpdateBackendNode update a backend node with a new status
+// UpdateBackendNode update a backend node with a new status, a node changing to draining will keep its existing connections until the drain timeout
 func (b *Backend) UpdateBackendNode(nodeid int, status healthcheck.Status) {
 	b.sync.Lock()
 	defer b.sync.Unlock()
-	if err := b.Nodes[nodeid]; err != nil {
-		b.Nodes[nodeid].Status = status
+	if node := b.Nodes[nodeid]; node != nil {
+		if status == healthcheck.Draining && node.Status != healthcheck.Draining {
+			go b.drainNode(node, false)
+		}
+		node.Status = status
+	}
+}
+
+// UpdateBackendNodeWeight updates the weight of a backend node used for weighted loadbalancing
+func (b *Backend) UpdateBackendNodeWeight(nodeid int, weight int) {
+	b.sync.Lock()
+	defer b.sync.Unlock()
+	if node := b.Nodes[nodeid]; node != nil {
+		node.Weight = weight
+		node.Statistics.SetWeighted(weight)
 	}
 }
 
-// RemoveBackendNode remove a backend node from the listener
+// RemoveBackendNode remove a backend node from the listener, its existing connections are drained
 func (b *Backend) RemoveBackendNode(nodeid int) {
 	b.sync.Lock()
 	defer b.sync.Unlock()
+	removed := b.Nodes[nodeid]
 	nodes := remove(b.Nodes, nodeid)
 	b.Nodes = nodes
 	// clear statistics on removal
 	for _, node := range b.Nodes {
 		node.Statistics.Reset()
 	}
+
+	if removed.Connections() > 0 {
+		go b.drainNode(removed, true)
+	}
 }
 
 // RemoveNodeByID remove backend node by ID
@@ -143,11 +176,17 @@ func (b *Backend) GetBackendNodeBalanced(backendpool, ip, sticky, balancemode st
 		if n.Status == healthcheck.Online {
 			onlineNodes = append(onlineNodes, n)
 		}
+
+		// draining nodes only accept clients sticky to them
+		if n.Status == healthcheck.Draining && sticky != "" && n.UUID == sticky {
+			log.WithField("uuid", n.UUID).Debug("Returning sticky draining node for client")
+			return n, healthcheck.Draining, nil
+		}
 	}
 
 	switch len(onlineNodes) {
 	case 0: // return error of no nodes
-		if len(b.Nodes) > 0 { // 0 online, but there are nodes. so all nodes are in maintenance
+		if len(b.Nodes) > 0 { // 0 online, but there are nodes. so all nodes are in maintenance or draining
 			return &BackendNode{}, healthcheck.Maintenance, fmt.Errorf("All backend nodes are in Maintenance in backend %s", backendpool)
 		}
 
@@ -19,27 +19,29 @@ type BackendNode struct {
 	Preference     int
 	Weight         int
 	Status         healthcheck.Status
-	LocalTopology  string   `json:"local_topology" toml:"local_topology"` // overrides localnetwork
-	LocalNetwork   []string `json:"local_network" toml:"local_network"`   // used for topology based loadbalancing
-
+	LocalTopology  string             `json:"local_topology" toml:"local_topology"` // overrides localnetwork
+	LocalNetwork   []string           `json:"local_network" toml:"local_network"`   // used for topology based loadbalancing
+	connections    *connectionTracker // open connections used for draining
 }
 
 // NewBackendNode creates a new node for a proxy backend
 func NewBackendNode(UUID string, IP string, hostname string, port int, maxconnections int, topology []string, preference int, weight int, status healthcheck.Status) *BackendNode {
 	b := &BackendNode{
-		UUID:       UUID,
-		IP:         IP,
-		Hostname:   hostname,
-		Port:       port,
-		Uptime:     time.Now(),
-		Statistics: balancer.NewStatistics(UUID, maxconnections),
-		Status:     status,
+		UUID:        UUID,
+		IP:          IP,
+		Hostname:    hostname,
+		Port:        port,
+		Uptime:      time.Now(),
+		Statistics:  balancer.NewStatistics(UUID, maxconnections),
+		Status:      status,
+		connections: newConnectionTracker(),
 	}
 	b.Statistics.Topology = topology
 	b.Statistics.Preference = preference
 	b.Statistics.Weighted = weight
 	b.LocalNetwork = topology
 	b.Preference = preference
+	b.Weight = weight
 	return b
 }
 
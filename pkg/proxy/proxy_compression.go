@@ -0,0 +1,361 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/schubergphilis/mercury/pkg/balancer"
+	"github.com/schubergphilis/mercury/pkg/logging"
+)
+
+// CompressionConfig is the compression of the replies of a pool
+type CompressionConfig struct {
+	Enabled      bool     `json:"enabled" toml:"enabled"`             // compress replies
+	Encodings    []string `json:"encodings" toml:"encodings"`         // encodings in order of preference
+	Level        int      `json:"level" toml:"level"`                 // compression level 1-9, -1 for the default
+	ContentTypes []string `json:"content_types" toml:"content_types"` // content types to compress, type/* for all subtypes
+	MinSize      int64    `json:"min_size" toml:"min_size"`           // minimum size of a reply to compress
+}
+
+// compressWriter is a writer compressing what is written to it
+type compressWriter interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// compressEncoders contains the supported content encodings
+var compressEncoders = map[string]func(w io.Writer, level int) (compressWriter, error){
+	"br": func(w io.Writer, level int) (compressWriter, error) {
+		if level == -1 {
+			level = brotli.DefaultCompression
+		}
+		return brotli.NewWriterLevel(w, level), nil
+	},
+	"gzip": func(w io.Writer, level int) (compressWriter, error) {
+		return gzip.NewWriterLevel(w, level)
+	},
+	"deflate": func(w io.Writer, level int) (compressWriter, error) {
+		// the deflate content encoding is zlib
+		return zlib.NewWriterLevel(w, level)
+	},
+}
+
+// Validate checks the compression settings
+func (c CompressionConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	for _, encoding := range c.Encodings {
+		if _, ok := compressEncoders[encoding]; !ok {
+			return fmt.Errorf("unsupported compression encoding: %s", encoding)
+		}
+	}
+
+	if c.Level < -1 || c.Level > 9 {
+		return fmt.Errorf("compression level must be between 1 and 9, or -1 for the default: %d", c.Level)
+	}
+
+	return nil
+}
+
+// SetCompression sets the compression of the replies of the listener
+func (l *Listener) SetCompression(c CompressionConfig) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	l.Compression = c
+	return nil
+}
+
+// negotiate returns the encoding to use for a client, based on its Accept-Encoding header
+// the encoding with the highest quality is used, and our preference if they are equal
+func (c CompressionConfig) negotiate(acceptEncoding string) string {
+	quality := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if name == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		quality[name] = q
+	}
+
+	best := ""
+	bestQuality := 0.0
+	for _, encoding := range c.Encodings {
+		q, ok := quality[encoding]
+		if !ok {
+			q, ok = quality["*"]
+		}
+
+		if ok && q > bestQuality {
+			best = encoding
+			bestQuality = q
+		}
+	}
+
+	return best
+}
+
+// compressStreamingTypes are the content types of which every part is sent to the client as soon as it is received
+var compressStreamingTypes = []string{"text/event-stream"}
+
+// isStreamingContentType returns true if the content type is streamed to the client
+func isStreamingContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, streaming := range compressStreamingTypes {
+		if mediaType == streaming {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchContentType returns true if the content type is to be compressed
+func (c CompressionConfig) matchContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, match := range c.ContentTypes {
+		match = strings.ToLower(match)
+		if match == mediaType {
+			return true
+		}
+
+		if strings.HasSuffix(match, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(match, "*")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// compressResponse compresses the reply to the client if it accepts one of our encodings, and the reply qualifies
+// the uncompressed and compressed size are added to the statistics once the reply is sent
+func (c CompressionConfig) compressResponse(res *http.Response, stats *balancer.Statistics) {
+	if !c.Enabled || res.Body == nil || res.Body == http.NoBody || res.Request == nil || res.Request.Method == "HEAD" {
+		return
+	}
+
+	switch res.StatusCode {
+	case http.StatusSwitchingProtocols, http.StatusNoContent, http.StatusPartialContent, http.StatusNotModified:
+		return
+	}
+
+	if encoding := res.Header.Get("Content-Encoding"); encoding != "" && !strings.EqualFold(encoding, "identity") {
+		return
+	}
+
+	if strings.Contains(strings.ToLower(res.Header.Get("Cache-Control")), "no-transform") {
+		return
+	}
+
+	if res.ContentLength >= 0 && res.ContentLength < c.MinSize {
+		return
+	}
+
+	if !c.matchContentType(res.Header.Get("Content-Type")) {
+		return
+	}
+
+	// the reply depends on the Accept-Encoding, even if we do not compress it for this client
+	if !strings.Contains(strings.ToLower(strings.Join(res.Header["Vary"], ",")), "accept-encoding") {
+		res.Header.Add("Vary", "Accept-Encoding")
+	}
+	encoding := c.negotiate(res.Request.Header.Get("Accept-Encoding"))
+	if encoding == "" {
+		return
+	}
+
+	level := c.Level
+	if level == 0 {
+		level = -1
+	}
+
+	stream := isStreamingContentType(res.Header.Get("Content-Type"))
+	res.Body = newCompressBody(res.Body, encoding, level, stream, func(in, out int64) {
+		if stats != nil {
+			stats.CompressionAdd(in, out)
+		}
+	})
+	res.ContentLength = -1
+	res.Header.Del("Content-Length")
+	res.Header.Del("Accept-Ranges")
+	res.Header.Set("Content-Encoding", encoding)
+	weakenETag(res.Header)
+
+	logging.For("proxy/compression").WithField("encoding", encoding).Debug("Compressing reply")
+}
+
+// weakenETag makes a strong ETag weak, since the body is no longer byte for byte equal
+func weakenETag(header http.Header) {
+	if etag := header.Get("ETag"); strings.HasPrefix(etag, `"`) {
+		header.Set("ETag", "W/"+etag)
+	}
+}
+
+// compressBody compresses a body while it is read
+type compressBody struct {
+	*io.PipeReader
+	src io.Closer
+}
+
+// countingWriter counts the bytes written
+type countingWriter struct {
+	io.Writer
+	count int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.count += int64(n)
+	return n, err
+}
+
+// compressRead is the result of a read of the body to compress
+type compressRead struct {
+	buf []byte
+	n   int
+	err error
+}
+
+// compressFlushDelay is how long the next read of a body may block before what is compressed is flushed
+const compressFlushDelay = 5 * time.Millisecond
+
+// newCompressBody returns src compressed with encoding
+// src is read ahead, and what is compressed is flushed to the client when the next read of src blocks,
+// so replies are not delayed while a backend is slow, without flushing every read of a chunked reply.
+// replies of a streaming content type are flushed after every read
+// done is called with the uncompressed and compressed size when src is compressed
+func newCompressBody(src io.ReadCloser, encoding string, level int, stream bool, done func(in, out int64)) *compressBody {
+	pr, pw := io.Pipe()
+	reads := make(chan compressRead, 4)
+	stop := make(chan struct{})
+	free := make(chan []byte, cap(reads))
+	for i := 0; i < cap(free); i++ {
+		free <- make([]byte, 32*1024)
+	}
+
+	go func() {
+		for {
+			var buf []byte
+			select {
+			case buf = <-free:
+			case <-stop:
+				return
+			}
+
+			n, err := src.Read(buf)
+			select {
+			case reads <- compressRead{buf: buf, n: n, err: err}:
+			case <-stop:
+				return
+			}
+
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	go func() {
+		defer close(stop)
+		out := &countingWriter{Writer: pw}
+		cw, err := compressEncoders[encoding](out, level)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+
+		var in int64
+		pending := false
+		delay := time.NewTimer(compressFlushDelay)
+		defer delay.Stop()
+		for err == nil {
+			var read compressRead
+			select {
+			case read = <-reads:
+			default:
+				if pending {
+					if !delay.Stop() {
+						select {
+						case <-delay.C:
+						default:
+						}
+					}
+					delay.Reset(compressFlushDelay)
+					select {
+					case read = <-reads:
+					case <-delay.C:
+						// the next read blocks, send what we have to the client while waiting
+						if err = cw.Flush(); err != nil {
+							continue
+						}
+						pending = false
+						read = <-reads
+					}
+				} else {
+					read = <-reads
+				}
+			}
+
+			err = read.err
+			if read.n > 0 {
+				in += int64(read.n)
+				if _, werr := cw.Write(read.buf[:read.n]); werr != nil {
+					err = werr
+				} else if stream {
+					if ferr := cw.Flush(); ferr != nil {
+						err = ferr
+					}
+				} else {
+					pending = true
+				}
+			}
+			free <- read.buf
+		}
+
+		if err == io.EOF {
+			err = cw.Close()
+			if done != nil {
+				done(in, out.count)
+			}
+		}
+		pw.CloseWithError(err)
+	}()
+
+	return &compressBody{PipeReader: pr, src: src}
+}
+
+// Close stops the compression and closes the source of the body
+func (c *compressBody) Close() error {
+	c.PipeReader.Close()
+	return c.src.Close()
+}
@@ -0,0 +1,169 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/schubergphilis/mercury/pkg/balancer"
+	"github.com/schubergphilis/mercury/pkg/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+var testCompression = CompressionConfig{
+	Enabled:      true,
+	Encodings:    []string{"gzip", "deflate", "br"},
+	Level:        -1,
+	ContentTypes: []string{"text/*", "application/json"},
+	MinSize:      100,
+}
+
+func newCompressionResponse(acceptEncoding string, contentType string, body string) *http.Response {
+	req, _ := http.NewRequest("GET", "http://www.example.com/", nil)
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	res := &http.Response{
+		StatusCode:    200,
+		Header:        http.Header{},
+		Body:          ioutil.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+	res.Header.Set("Content-Type", contentType)
+	res.Header.Set("Content-Length", "1")
+
+	return res
+}
+
+func TestCompressionNegotiate(t *testing.T) {
+	assert.Equal(t, "gzip", testCompression.negotiate("gzip, deflate, br"))
+	assert.Equal(t, "deflate", testCompression.negotiate("deflate"))
+	assert.Equal(t, "deflate", testCompression.negotiate("gzip;q=0.5, deflate;q=0.8"))
+	assert.Equal(t, "br", testCompression.negotiate("gzip;q=0, br"))
+	assert.Equal(t, "", testCompression.negotiate("gzip;q=0, compress"))
+	assert.Equal(t, "gzip", testCompression.negotiate("*"))
+	assert.Equal(t, "deflate", testCompression.negotiate("gzip;q=0, *"))
+	assert.Equal(t, "", testCompression.negotiate(""))
+
+	// brotli is used when preferred by us or the client
+	brotliFirst := CompressionConfig{Enabled: true, Encodings: []string{"br", "gzip", "deflate"}}
+	assert.Equal(t, "br", brotliFirst.negotiate("gzip, deflate, br"))
+	assert.Equal(t, "gzip", brotliFirst.negotiate("gzip, deflate"))
+	assert.Equal(t, "br", testCompression.negotiate("gzip;q=0.8, br"))
+	assert.Equal(t, "gzip", brotliFirst.negotiate("br;q=0.5, gzip"))
+
+	assert.True(t, testCompression.matchContentType("text/html; charset=utf-8"))
+	assert.True(t, testCompression.matchContentType("Application/JSON"))
+	assert.False(t, testCompression.matchContentType("image/png"))
+	assert.False(t, testCompression.matchContentType(""))
+
+	assert.NotNil(t, CompressionConfig{Enabled: true, Encodings: []string{"compress"}}.Validate())
+	assert.NotNil(t, CompressionConfig{Enabled: true, Level: 10}.Validate())
+}
+
+func TestCompressResponse(t *testing.T) {
+	logging.Configure("stdout", "error")
+	body := strings.Repeat("<p>Hello World!</p>", 100)
+	stats := balancer.NewStatistics("test", 1)
+
+	readers := map[string]func(r io.Reader) (io.Reader, error){
+		"gzip": func(r io.Reader) (io.Reader, error) {
+			return gzip.NewReader(r)
+		},
+		"deflate": func(r io.Reader) (io.Reader, error) {
+			return zlib.NewReader(r)
+		},
+		"br": func(r io.Reader) (io.Reader, error) {
+			return brotli.NewReader(r), nil
+		},
+	}
+
+	for encoding, reader := range readers {
+		res := newCompressionResponse(encoding, "text/html", body)
+		res.Header.Set("ETag", `"abc"`)
+		testCompression.compressResponse(res, stats)
+		assert.Equal(t, encoding, res.Header.Get("Content-Encoding"))
+		assert.Equal(t, "", res.Header.Get("Content-Length"))
+		assert.Equal(t, "Accept-Encoding", res.Header.Get("Vary"))
+		assert.Equal(t, `W/"abc"`, res.Header.Get("ETag"))
+
+		compressed, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		assert.True(t, len(compressed) < len(body))
+		r, err := reader(bytes.NewReader(compressed))
+		if assert.Nil(t, err) {
+			result, _ := ioutil.ReadAll(r)
+			assert.Equal(t, body, string(result))
+		}
+	}
+
+	in, out := stats.CompressionGet()
+	assert.Equal(t, int64(3*len(body)), in)
+	assert.True(t, out > 0 && out < in)
+	assert.True(t, stats.CompressionRatioGet() > 1)
+
+	// replies that are not compressed
+	skip := map[string]*http.Response{
+		"no accept":  newCompressionResponse("", "text/html", body),
+		"type":       newCompressionResponse("gzip", "image/png", body),
+		"small":      newCompressionResponse("gzip", "text/html", "<p>Hello</p>"),
+		"compressed": newCompressionResponse("gzip", "text/html", body),
+		"transform":  newCompressionResponse("gzip", "text/html", body),
+	}
+	skip["compressed"].Header.Set("Content-Encoding", "br")
+	skip["transform"].Header.Set("Cache-Control", "no-transform")
+	for name, res := range skip {
+		encoding := res.Header.Get("Content-Encoding")
+		testCompression.compressResponse(res, stats)
+		assert.Equal(t, encoding, res.Header.Get("Content-Encoding"), name)
+		assert.Equal(t, "1", res.Header.Get("Content-Length"), name)
+	}
+}
+
+func TestCompressBodyFlush(t *testing.T) {
+	body := strings.Repeat("<p>Hello World!</p>", 100)
+
+	// a reply that is read without blocking is not flushed on every read
+	compressed, err := ioutil.ReadAll(newCompressBody(ioutil.NopCloser(iotest.OneByteReader(strings.NewReader(body))), "gzip", -1, false, nil))
+	assert.Nil(t, err)
+	assert.True(t, len(compressed) < len(body)/4, "compressed size %d", len(compressed))
+
+	// what is received is sent to the client when the backend blocks
+	for _, stream := range []bool{false, true} {
+		src, backend := io.Pipe()
+		cb := newCompressBody(src, "gzip", -1, stream, nil)
+		go backend.Write([]byte("data: hello\n\n"))
+
+		received := make(chan string)
+		go func() {
+			r, err := gzip.NewReader(cb)
+			if err != nil {
+				received <- err.Error()
+				return
+			}
+			buf := make([]byte, 64)
+			n, _ := r.Read(buf)
+			received <- string(buf[:n])
+		}()
+
+		select {
+		case data := <-received:
+			assert.Equal(t, "data: hello\n\n", data)
+		case <-time.After(2 * time.Second):
+			t.Errorf("compressed data not flushed (stream: %t)", stream)
+		}
+
+		backend.Close()
+		cb.Close()
+	}
+
+	assert.True(t, isStreamingContentType("text/event-stream; charset=utf-8"))
+	assert.False(t, isStreamingContentType("text/html"))
+}
@@ -0,0 +1,240 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/schubergphilis/mercury/pkg/healthcheck"
+	"github.com/schubergphilis/mercury/pkg/logging"
+)
+
+// DefaultDrainTimeout is the time a draining node keeps its connections if no timeout is set
+var DefaultDrainTimeout = 300 * time.Second
+
+// drainCheckInterval is the longest interval at which a draining node is checked for remaining connections
+var drainCheckInterval = 1 * time.Second
+
+type backendNodeContextKey struct{}
+
+// connectionTracker keeps track of the open connections and http requests in progress to a backend node
+// pooled http connections are only tracked to close them, an idle connection does not keep a node from being drained
+type connectionTracker struct {
+	sync.Mutex
+	conns    map[net.Conn]bool // true if the connection is pooled by a http transport
+	requests int
+}
+
+func newConnectionTracker() *connectionTracker {
+	return &connectionTracker{
+		conns: make(map[net.Conn]bool),
+	}
+}
+
+func (c *connectionTracker) add(conn net.Conn, pooled bool) {
+	c.Lock()
+	defer c.Unlock()
+	c.conns[conn] = pooled
+}
+
+// request counts a http request in progress, until the returned function is called
+func (c *connectionTracker) request() func() {
+	c.Lock()
+	defer c.Unlock()
+	c.requests++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.Lock()
+			defer c.Unlock()
+			c.requests--
+		})
+	}
+}
+
+func (c *connectionTracker) remove(conn net.Conn) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.conns, conn)
+}
+
+func (c *connectionTracker) count() int {
+	c.Lock()
+	defer c.Unlock()
+	count := c.requests
+	for _, pooled := range c.conns {
+		if !pooled {
+			count++
+		}
+	}
+
+	return count
+}
+
+func (c *connectionTracker) closeAll() int {
+	c.Lock()
+	var conns []net.Conn
+	for conn := range c.conns {
+		conns = append(conns, conn)
+	}
+	c.Unlock()
+
+	for _, conn := range conns {
+		conn.Close()
+	}
+
+	return len(conns)
+}
+
+// trackedConn is a connection which removes itself from the tracker when closed
+type trackedConn struct {
+	net.Conn
+	tracker *connectionTracker
+	once    sync.Once
+}
+
+// Close closes the connection and stops tracking it
+func (t *trackedConn) Close() error {
+	t.once.Do(func() {
+		t.tracker.remove(t)
+	})
+
+	return t.Conn.Close()
+}
+
+// TrackConnection keeps track of a connection to the backend node, so it can be drained
+func (a *BackendNode) TrackConnection(conn net.Conn) net.Conn {
+	return a.trackConnection(conn, false)
+}
+
+// trackConnection keeps track of a connection to the backend node, pooled connections are not counted
+func (a *BackendNode) trackConnection(conn net.Conn, pooled bool) net.Conn {
+	if a.connections == nil {
+		return conn
+	}
+
+	t := &trackedConn{Conn: conn, tracker: a.connections}
+	a.connections.add(t, pooled)
+	return t
+}
+
+// trackRequest counts a http request to the backend node as a connection, until the returned function is called
+func (a *BackendNode) trackRequest() func() {
+	if a == nil || a.connections == nil {
+		return func() {}
+	}
+
+	return a.connections.request()
+}
+
+// Connections returns the number of open connections and http requests in progress to the backend node
+func (a *BackendNode) Connections() int {
+	if a.connections == nil {
+		return 0
+	}
+
+	return a.connections.count()
+}
+
+// CloseConnections closes all open connections to the backend node, including pooled ones, and returns the number closed
+func (a *BackendNode) CloseConnections() int {
+	if a.connections == nil {
+		return 0
+	}
+
+	return a.connections.closeAll()
+}
+
+// SetDrainTimeout sets how long draining nodes of the backend keep their connections
+func (b *Backend) SetDrainTimeout(timeout time.Duration) {
+	b.sync.Lock()
+	defer b.sync.Unlock()
+	b.DrainTimeout = timeout
+}
+
+// drainTimeout returns the drain timeout of the backend
+func (b *Backend) drainTimeout() time.Duration {
+	b.sync.RLock()
+	defer b.sync.RUnlock()
+	if b.DrainTimeout == 0 {
+		return DefaultDrainTimeout
+	}
+
+	return b.DrainTimeout
+}
+
+// drainNode waits for the connections of a node to finish, and closes them once the drain timeout has passed
+// a node that is still part of the backend stops draining if its status is no longer draining
+func (b *Backend) drainNode(node *BackendNode, removed bool) {
+	log := logging.For("proxy/drain").WithField("backend", b.UUID).WithField("node", node.Name()).WithField("port", node.Port).WithField("removed", removed)
+	timeout := b.drainTimeout()
+	log.WithField("connections", node.Connections()).WithField("timeout", timeout).Info("Draining backend node")
+
+	// check more often for short drain timeouts
+	interval := drainCheckInterval
+	if timeout/10 < interval && timeout/10 > 0 {
+		interval = timeout / 10
+	}
+
+	deadline := time.Now().Add(timeout)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !removed && !b.nodeDraining(node) {
+			log.Info("Backend node no longer draining")
+			return
+		}
+
+		if node.Connections() == 0 {
+			log.Info("Backend node drained")
+			return
+		}
+
+		if time.Now().After(deadline) {
+			// clients of websockets get a close frame, before the connection is closed
+			if websockets := b.closeWebsockets(node, websocketGoingAway, "going away"); websockets > 0 {
+				log.WithField("websockets", websockets).Info("Drain timeout reached, closing websockets to backend node")
+				time.Sleep(websocketCloseGrace)
+			}
+			log.WithField("connections", node.CloseConnections()).Warn("Drain timeout reached, closed remaining connections to backend node")
+			return
+		}
+	}
+}
+
+// nodeDraining returns true if the node is still draining
+func (b *Backend) nodeDraining(node *BackendNode) bool {
+	b.sync.RLock()
+	defer b.sync.RUnlock()
+	return node.Status == healthcheck.Draining
+}
+
+// requestConn is an upgraded connection of a http request, which is no longer in progress once closed
+type requestConn struct {
+	io.ReadWriteCloser
+	finished func()
+}
+
+// Close closes the connection, and stops counting the request
+func (r requestConn) Close() error {
+	r.finished()
+	return r.ReadWriteCloser.Close()
+}
+
+// trackingDialer returns a dialer which tracks the pooled connections to the backend node set in the request context
+func trackingDialer(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return conn, err
+		}
+
+		if node, ok := ctx.Value(backendNodeContextKey{}).(*BackendNode); ok {
+			return node.trackConnection(conn, true), nil
+		}
+
+		return conn, nil
+	}
+}
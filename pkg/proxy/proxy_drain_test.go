@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/schubergphilis/mercury/pkg/healthcheck"
+	"github.com/schubergphilis/mercury/pkg/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDrainBalancing(t *testing.T) {
+	logging.Configure("stdout", "error")
+	b := NewBackend("backend", "roundrobin", "http", []string{}, 0, ErrorPage{}, ErrorPage{})
+	b.AddBackendNode(NewBackendNode("online", "127.0.0.1", "online", 80, 0, []string{}, 0, 0, healthcheck.Online))
+	b.AddBackendNode(NewBackendNode("draining", "127.0.0.2", "draining", 80, 0, []string{}, 0, 0, healthcheck.Draining))
+
+	node, _, err := b.GetBackendNodeBalanced("backend", "127.0.0.1", "", "roundrobin")
+	assert.Nil(t, err)
+	assert.Equal(t, "online", node.UUID, "draining node should not receive new clients")
+
+	node, status, err := b.GetBackendNodeBalanced("backend", "127.0.0.1", "draining", "sticky")
+	assert.Nil(t, err)
+	assert.Equal(t, healthcheck.Draining, status)
+
+	b.UpdateBackendNode(0, healthcheck.Draining)
+	_, status, err = b.GetBackendNodeBalanced("backend", "127.0.0.1", "", "roundrobin")
+	assert.NotNil(t, err)
+	assert.Equal(t, healthcheck.Maintenance, status, "backend with only draining nodes should be in maintenance")
+}
+
+func TestDrainTimeout(t *testing.T) {
+	logging.Configure("stdout", "error")
+	b := NewBackend("backend", "roundrobin", "tcp", []string{}, 0, ErrorPage{}, ErrorPage{})
+	b.SetDrainTimeout(50 * time.Millisecond)
+	b.AddBackendNode(NewBackendNode("node", "127.0.0.1", "node", 80, 0, []string{}, 0, 0, healthcheck.Online))
+	node := b.Nodes[0]
+
+	client, server := net.Pipe()
+	defer server.Close()
+	node.TrackConnection(client)
+	assert.Equal(t, 1, node.Connections())
+
+	b.UpdateBackendNode(0, healthcheck.Draining)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, 1, node.Connections(), "connections should be kept while draining")
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 0, node.Connections(), "connections should be closed after the drain timeout")
+
+	// removed nodes are drained too
+	b.AddBackendNode(NewBackendNode("removed", "127.0.0.2", "removed", 80, 0, []string{}, 0, 0, healthcheck.Online))
+	removed := b.Nodes[1]
+	client, server = net.Pipe()
+	defer server.Close()
+	conn := removed.TrackConnection(client)
+	b.RemoveBackendNode(1)
+	assert.Equal(t, 1, removed.Connections())
+
+	conn.Close()
+	assert.Equal(t, 0, removed.Connections(), "closed connections should no longer be tracked")
+}
+
+func TestDrainRequests(t *testing.T) {
+	logging.Configure("stdout", "error")
+	b := NewBackend("backend", "roundrobin", "http", []string{}, 0, ErrorPage{}, ErrorPage{})
+	b.SetDrainTimeout(time.Second)
+	b.AddBackendNode(NewBackendNode("node", "127.0.0.1", "node", 80, 0, []string{}, 0, 0, healthcheck.Online))
+	node := b.Nodes[0]
+
+	// an idle keep-alive connection of the transport does not keep the node busy
+	client, server := net.Pipe()
+	defer server.Close()
+	pooled := node.trackConnection(client, true)
+	defer pooled.Close()
+	assert.Equal(t, 0, node.Connections())
+
+	finished := node.trackRequest()
+	assert.Equal(t, 1, node.Connections())
+
+	b.UpdateBackendNode(0, healthcheck.Draining)
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, 1, node.Connections(), "requests in progress should be kept while draining")
+
+	finished()
+	finished()
+	assert.Equal(t, 0, node.Connections(), "finished requests should no longer be counted")
+}
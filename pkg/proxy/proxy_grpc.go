@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// gRPC status codes used for local replies
+const (
+	grpcUnknown          = 2
+	grpcPermissionDenied = 7
+	grpcUnimplemented    = 12
+	grpcInternal         = 13
+	grpcUnavailable      = 14
+	grpcUnauthenticated  = 16
+)
+
+// grpcStatusCodes maps http status codes to gRPC status codes, as gRPC clients do for http replies
+var grpcStatusCodes = map[int]int{
+	http.StatusBadRequest:         grpcInternal,
+	http.StatusUnauthorized:       grpcUnauthenticated,
+	http.StatusForbidden:          grpcPermissionDenied,
+	http.StatusNotFound:           grpcUnimplemented,
+	http.StatusTooManyRequests:    grpcUnavailable,
+	http.StatusBadGateway:         grpcUnavailable,
+	http.StatusServiceUnavailable: grpcUnavailable,
+	http.StatusGatewayTimeout:     grpcUnavailable,
+}
+
+// isGRPC returns true if the request is a gRPC call
+func isGRPC(req *http.Request) bool {
+	return req != nil && strings.HasPrefix(req.Header.Get("Content-Type"), "application/grpc")
+}
+
+// grpcStatusPage returns a reply to a gRPC call with the status in its headers, since gRPC clients can not read html
+func grpcStatusPage(statusCode int, statusMessage string, req *http.Request) *http.Response {
+	code, ok := grpcStatusCodes[statusCode]
+	if !ok {
+		code = grpcUnknown
+	}
+
+	res := &http.Response{
+		StatusCode:    http.StatusOK,
+		Status:        http.StatusText(http.StatusOK),
+		Proto:         "HTTP/2.0",
+		ProtoMajor:    2,
+		ProtoMinor:    0,
+		Header:        http.Header{},
+		Body:          http.NoBody,
+		ContentLength: 0,
+		Request:       req,
+	}
+	res.Header.Set("Content-Type", "application/grpc")
+	res.Header.Set("Grpc-Status", strconv.Itoa(code))
+	res.Header.Set("Grpc-Message", grpcEncodeMessage(fmt.Sprintf("%d %s", statusCode, statusMessage)))
+
+	return res
+}
+
+// grpcEncodeMessage percent encodes a grpc-message
+func grpcEncodeMessage(msg string) string {
+	var encoded strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&encoded, "%%%02X", c)
+			continue
+		}
+		encoded.WriteByte(c)
+	}
+
+	return encoded.String()
+}
@@ -2,26 +2,27 @@ package proxy
 
 import (
 	"bytes"
-	"crypto/tls"
+	"context"
 	"crypto/x509"
+	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"html"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/httputil"
-	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
-	"golang.org/x/net/http2"
-
 	uuid "github.com/nu7hatch/gouuid"
 	"github.com/rdoorn/gorule"
 
+	"github.com/schubergphilis/mercury/pkg/balancer"
 	"github.com/schubergphilis/mercury/pkg/healthcheck"
 	"github.com/schubergphilis/mercury/pkg/logging"
 )
@@ -30,21 +31,51 @@ const (
 	sessionIDCookie = "mercid"
 )
 
+// authResponseContextKey is the context key of the response of the auth gateway to a client that is not authenticated
+type authResponseContextKey struct{}
+
 type customTransport struct {
 	*http.Transport
-	LocalAddr net.Addr
-	Listener  *Listener
+	LocalAddr  net.Addr
+	Listener   *Listener
+	transports backendTransports
 }
 
 var variableRegex = regexp.MustCompile("###([A-Z_a-z]+)###")
 
 func customStatusPage(statusCode int, statusMessage string, req *http.Request) *http.Response {
-	var body []byte
-	nbody := &bytes.Buffer{}
+	if isGRPC(req) {
+		return grpcStatusPage(statusCode, statusMessage, req)
+	}
+
+	// the client gets the page as html, json or plain text depending on its Accept header
+	contentType := contentTypeHTML
+	if req != nil {
+		if accepted, ok := negotiateContentType(req.Header.Get("Accept"), statusPageTypes); ok {
+			contentType = accepted
+		}
+	}
+
+	// the id of the request is shown, so a client can refer to it
+	var msg []byte
 	t := time.Now()
-	msg := fmt.Sprintf("<head><title>%d %s</title></head><body><h1>%d %s</h1><br>- Generated by Mercury at %s</body>", statusCode, statusMessage, statusCode, statusMessage, t.Format("2006-01-02 15:04:05"))
-	nbody.Write(append(body, []byte(msg)...))
-	b := ioutil.NopCloser(nbody)
+	id := requestID(req)
+	switch contentType {
+	case contentTypeJSON:
+		msg, _ = json.Marshal(map[string]interface{}{"status": statusCode, "message": statusMessage, "time": t.Format(time.RFC3339), "request_id": id})
+	case contentTypeText:
+		msg = []byte(fmt.Sprintf("%d %s\n", statusCode, statusMessage))
+		if id != "" {
+			msg = append(msg, fmt.Sprintf("Request ID: %s\n", id)...)
+		}
+	default:
+		var requestInfo string
+		if id != "" {
+			requestInfo = "<br>- Request ID: " + html.EscapeString(id)
+		}
+		msg = []byte(fmt.Sprintf("<head><title>%d %s</title></head><body><h1>%d %s</h1><br>- Generated by Mercury at %s%s</body>", statusCode, statusMessage, statusCode, statusMessage, t.Format("2006-01-02 15:04:05"), requestInfo))
+	}
+
 	nres := &http.Response{
 		StatusCode: statusCode,
 		Status:     statusMessage,
@@ -52,13 +83,10 @@ func customStatusPage(statusCode int, statusMessage string, req *http.Request) *
 		ProtoMajor: 1,
 		ProtoMinor: 1,
 		Header:     http.Header{},
-		Body:       b,
 		Request:    req,
 	}
 	// Ensure we dont cache custom responses
-	nres.Header.Add("Cache-Control", "no-cache, no-store, must-revalidate")
-	nres.Header.Add("Pragma", "no-cache")
-	nres.Header.Add("Expires", "0")
+	setStatusPageBody(nres, contentType, msg)
 
 	return nres
 
@@ -67,7 +95,7 @@ func customStatusPage(statusCode int, statusMessage string, req *http.Request) *
 // RoundTrip does the actual http sending and receiving for the proxy
 func (t *customTransport) RoundTrip(req *http.Request) (res *http.Response, err error) {
 	remote := stringToClientIP(req.RemoteAddr)
-	log := logging.For("proxy/roundtrip").WithField("clientip", remote.IP)
+	log := logging.For("proxy/roundtrip").WithField("clientip", remote.IP).WithField("requestid", requestID(req))
 	log.WithField("scheme", req.URL).Debug("Roundtrip scheme")
 	starttime := time.Now()
 	originalScheme := req.URL.Scheme
@@ -99,24 +127,101 @@ func (t *customTransport) RoundTrip(req *http.Request) (res *http.Response, err
 		res = customStatusPage(statuscode, statusmessage, req)
 		return res, nil
 
+	case "auth":
+		res, _ = req.Context().Value(authResponseContextKey{}).(*http.Response)
+		if res == nil {
+			res = customStatusPage(500, "Internal Server Error", req)
+		}
+		res.Request = req
+		return res, nil
+
+	case "limit":
+		log.WithField("backend", scheme[1]).Infof("Client reached limit")
+		res = customStatusPage(http.StatusTooManyRequests, scheme[3], req)
+		if len(scheme) > 4 {
+			res.Header.Set("Retry-After", scheme[4])
+		}
+		return res, nil
+
 	case "internal":
 		res = customStatusPage(200, "OK", req)
 
-	default: // http/https
-		req.URL.Scheme = scheme[0]
+	default: // http/https/h2/h2c
+		req.URL.Scheme = backendScheme(scheme[0])
 
-		// process inbound rules only on requests that are passed to the server
-		err = t.Listener.ProcessInboundRules(t.Listener.Backends[scheme[1]].InboundRule, req, res)
-		if err != nil {
-			log.WithError(err).WithField("backend", scheme[1]).Warnf("failed to process inbound rule")
-			return
+		// inspect the request with the web application firewall before it is passed to the server
+		waf := t.Listener.WAF.Inspect(req)
+		if len(waf.Matches) > 0 {
+			log = log.WithField("waf_score", waf.Score).WithField("waf_matches", waf.String()).WithField("waf_blocked", waf.Blocked)
+		}
+
+		if waf.Blocked {
+			res = customStatusPage(403, "Forbidden - request blocked", req)
+		} else {
+			// process inbound rules only on requests that are passed to the server
+			err = t.Listener.ProcessInboundRules(t.Listener.Backends[scheme[1]].InboundRule, req, res)
+			if err != nil {
+				log.WithError(err).WithField("backend", scheme[1]).Warnf("failed to process inbound rule")
+				return
+			}
+		}
+
+		// keep track of the connections made to the node, so they can be drained
+		node, nerr := t.Listener.Backends[scheme[1]].GetBackendNodeByID(scheme[2])
+		if nerr == nil {
+			req = req.WithContext(context.WithValue(req.Context(), backendNodeContextKey{}, node))
+		}
+
+		// websockets are counted separately, and limited per backend
+		websocket := res == nil && isWebsocket(req)
+		wsConfig := t.Listener.Backends[scheme[1]].websocket()
+		if websocket && !t.Listener.Backends[scheme[1]].websocketStart(wsConfig.MaxConnections) {
+			log.WithField("backend", scheme[1]).WithField("max_connections", wsConfig.MaxConnections).Warn("Maximum websocket connections reached")
+			res = customStatusPage(http.StatusServiceUnavailable, "Service Unavailable - too many websocket connections", req)
+			websocket = false
 		}
 
 		if res == nil {
-			res, err = t.Transport.RoundTrip(req)
+			// strip or rewrite the path for the backend, the acls, waf and inbound rules match the path of the client
+			if route, ok := req.Context().Value(routeContextKey{}).(*Route); ok {
+				route.rewritePath(req)
+			}
+
+			// send a copy of the request to the mirror of the backend
+			mirror := t.mirror(req, scheme[1])
+			sendtime := time.Now()
+			var cancel context.CancelFunc
+			req, cancel = withTotalTimeout(req, t.Listener.Backends[scheme[1]].timeouts().Total)
+			// the request keeps a draining node busy until its reply or upgraded connection is closed
+			finished := node.trackRequest()
+			res, err = t.transport(scheme[1]).RoundTrip(req)
 			if err != nil {
-				// We have an error, generate a 500
-				res = customStatusPage(500, err.Error(), req)
+				// We have an error, generate a 504 for timeouts and a 502 for all others
+				cancel()
+				finished()
+				mirror.done(time.Since(sendtime), err)
+				log = log.WithError(err).WithField("timeout", isTimeout(err))
+				res = backendErrorPage(err, req)
+			} else {
+				conn, upgraded := res.Body.(io.ReadWriteCloser)
+				switch {
+				case res.StatusCode != http.StatusSwitchingProtocols || !upgraded:
+					res.Body = cancelBody{ReadCloser: res.Body, cancel: func() {
+						cancel()
+						finished()
+					}}
+				case websocket:
+					res.Body = t.Listener.Backends[scheme[1]].newWebsocket(requestConn{ReadWriteCloser: conn, finished: finished}, node, wsConfig)
+					websocket = false
+				default:
+					res.Body = requestConn{ReadWriteCloser: conn, finished: finished}
+				}
+				mirror.done(time.Since(sendtime), nil)
+			}
+
+			// the node did not upgrade the connection
+			if websocket {
+				t.Listener.Backends[scheme[1]].websocketAbort()
 			}
 		}
 
@@ -136,6 +241,11 @@ func (t *customTransport) RoundTrip(req *http.Request) (res *http.Response, err
 		log = log.WithField("clientid", clientid.Value)
 	}
 
+	// Add the route of the request to logging
+	if route, ok := req.Context().Value(routeContextKey{}).(*Route); ok {
+		log = log.WithField("route", route.Name)
+	}
+
 	// Log request
 	roundtriptime := time.Since(starttime)
 	log = log.WithField("backendnode", req.URL.Hostname()).WithField("forwarded-for", req.Header.Get("X-Forwarded-for")).WithField("hostname", req.Host).WithField("method", req.Method).WithField("url", req.RequestURI)
@@ -179,6 +289,13 @@ func processACLVariables(acl []ACL, l *Listener, backendnode BackendNode, req *h
 			newdata := variableRegex.ReplaceAllStringFunc(acl.CookieValue, fn)
 			acl.CookieValue = newdata
 		}
+		// body replacement and insert
+		if acl.BodyReplace != "" {
+			acl.BodyReplace = variableRegex.ReplaceAllStringFunc(acl.BodyReplace, fn)
+		}
+		if acl.BodyInsert != "" {
+			acl.BodyInsert = variableRegex.ReplaceAllStringFunc(acl.BodyInsert, fn)
+		}
 		// append new line to acl
 		newACL = append(newACL, acl)
 	}
@@ -234,6 +351,14 @@ func getVariableValue(name string, l *Listener, backendnode *BackendNode, req *h
 	case "CLIENT_CERT":
 		return getClientCertValue(req)
 
+	case "CLIENT_CERT_SUBJECT", "CLIENT_CERT_SUBJECT_CN", "CLIENT_CERT_SUBJECT_O", "CLIENT_CERT_SUBJECT_OU",
+		"CLIENT_CERT_ISSUER", "CLIENT_CERT_ISSUER_CN", "CLIENT_CERT_SAN", "CLIENT_CERT_SAN_DNS", "CLIENT_CERT_SAN_EMAIL",
+		"CLIENT_CERT_SAN_IP", "CLIENT_CERT_SAN_URI", "CLIENT_CERT_FINGERPRINT":
+		return getClientCertAttributeValue(req, strings.ToLower(strings.TrimPrefix(name, "CLIENT_CERT_")))
+
+	case "REQUEST_ID":
+		return requestID(req), nil
+
 	case "UUID":
 		id, uerr := uuid.NewV4() // used for sticky cookies
 		if uerr == nil {
@@ -312,11 +437,16 @@ func (l *Listener) NewHTTPProxy() *httputil.ReverseProxy {
 	// - sets the url Scheme to be processed by the RoundTrip handler, and the ModifyResponse handler
 	director := func(req *http.Request) {
 		clientAddr := stringToClientIP(req.RemoteAddr)
+		id := setRequestID(req)
 
-		clog := log.WithField("clientip", clientAddr.IP).WithField("hostname", req.Host)
-		// Update statistics of the Listener
-		l.Statistics.ClientsConnectsAdd(1)
-		l.updateClients()
+		clog := log.WithField("clientip", clientAddr.IP).WithField("hostname", req.Host).WithField("requestid", id)
+		// Update statistics of the Listener, HTTP/3 clients are counted separately
+		if isHTTP3(req) {
+			l.HTTP3Statistics.ClientsConnectsAdd(1)
+		} else {
+			l.Statistics.ClientsConnectsAdd(1)
+			l.updateClients()
+		}
 
 		// Log clients request
 		clientid, err := req.Cookie(sessionIDCookie)
@@ -339,8 +469,15 @@ func (l *Listener) NewHTTPProxy() *httputil.ReverseProxy {
 
 		// we have a host, find it's matching backend
 		reqHost := strings.Split(req.Host, ":")
-		backendname, backend := l.FindBackendByHost(reqHost[0])
+		backendname, backend, route := l.FindBackendByRequest(req)
 		clog = clog.WithField("backend", backendname)
+		if route != nil {
+			clog = clog.WithField("route", route.Name)
+			backend.routeHit(route.Name)
+			*req = *req.WithContext(context.WithValue(req.Context(), routeContextKey{}, route))
+		} else if backend != nil {
+			l.splitAssign(req, backendname, backend)
+		}
 		if backendname == "" {
 			// We don't have a backend match, this could be due to a hostname in the request which is unknown, and only if there is no default
 			other := l.FindAllHostNames()
@@ -365,6 +502,22 @@ func (l *Listener) NewHTTPProxy() *httputil.ReverseProxy {
 			return
 		}
 
+		// Apply the rate and connection limits of the client
+		if limited, retry := backend.processLimits(req); limited {
+			req.URL.Scheme = fmt.Sprintf("limit//%s//429//Too Many Requests//%d", backendname, retry)
+			return
+		}
+
+		// Authenticate the client if the backend requires a login
+		if auth := backend.authGateway(); auth != nil {
+			if res := auth.Authenticate(req); res != nil {
+				clog.WithField("statuscode", res.StatusCode).Info("Client not authenticated")
+				*req = *req.WithContext(context.WithValue(req.Context(), authResponseContextKey{}, res))
+				req.URL.Scheme = fmt.Sprintf("auth//%s//%s", backendname, req.URL.Hostname())
+				return
+			}
+		}
+
 		// Get a Node to balance this request to
 		backendnode, status, err := backend.GetBackendNodeBalanced(backendname, clientAddr.IP, stickyCookie, backend.BalanceMode)
 		if err != nil {
@@ -408,7 +561,7 @@ func (l *Listener) NewHTTPProxy() *httputil.ReverseProxy {
 			return
 		} else if aclAllows == 0 && aclDenies > 0 && aclsHit > 0 { // setting an deny ACL, will deny all who match 1 of the denies
 			clog.Infof("Client matched deny acl")
-			req.URL.Scheme = "error//" + backendname + "403//Access denied - matched DENY ACL"
+			req.URL.Scheme = "error//" + backendname + "//403//Access denied - matched DENY ACL"
 			return
 		}
 
@@ -426,16 +579,28 @@ func (l *Listener) NewHTTPProxy() *httputil.ReverseProxy {
 	}
 
 	modifyresponse := func(res *http.Response) error {
+		// return the id of the request to the client
+		log := log.WithField("requestid", requestID(res.Request))
+		if res.Request != nil {
+			res.Header.Set(requestIDHeader, requestID(res.Request))
+		}
+
 		// Process OutboundACL if we have a valid request (does not apply to errors)
 		localerror := false
 		localmaintenance := false
+		locallimit := false
 		var errorpage []byte
 		var maintenancepage []byte
+		var limitpage []byte
 		var showerrorpage bool
+		var compress bool
+		var compressStats *balancer.Statistics
+		var backendname string
+		var statuspage bool
 		if res.Request != nil {
 			scheme := strings.Split(res.Request.URL.Scheme, "//")
 			proto := scheme[0]
-			backendname := scheme[1]
+			backendname = scheme[1]
 			nodeid := scheme[2]
 
 			// Check if backend has error/maintenance page, if so, keep it
@@ -447,14 +612,26 @@ func (l *Listener) NewHTTPProxy() *httputil.ReverseProxy {
 				if l.Backends[backendname].MaintenancePage.present() {
 					maintenancepage = l.Backends[backendname].MaintenancePage.content
 				}
+				if l.Backends[backendname].LimitPage.present() {
+					limitpage = l.Backends[backendname].LimitPage.content
+				}
 			}
 
 			switch proto {
 			case "maintenance":
 				localmaintenance = true
+				statuspage = true
 			case "error":
 				localerror = true
+				statuspage = true
+			case "limit":
+				locallimit = true
+				statuspage = true
+			case "auth":
+				// replies of the auth gateway are sent as is
 			default:
+				// internal replies such as redirects are sent as is
+				statuspage = proto != "internal" && res.StatusCode != http.StatusSwitchingProtocols
 				if backendname != "localhost" && backendname != "" {
 
 					// apply outbound rules if any
@@ -481,6 +658,10 @@ func (l *Listener) NewHTTPProxy() *httputil.ReverseProxy {
 					for _, acl := range acls {
 						acl.ProcessResponse(res)
 					}
+
+					l.setSplitCookie(res)
+					compress = true
+					compressStats = node.Statistics
 				}
 
 			}
@@ -501,6 +682,34 @@ func (l *Listener) NewHTTPProxy() *httputil.ReverseProxy {
 			}
 		}
 
+		// Load listener limit page if any, and no backend limit page
+		if len(limitpage) == 0 {
+			if l.LimitPage.present() {
+				limitpage = l.LimitPage.content
+			}
+		}
+
+		// gRPC clients get the status of local replies in grpc-status, and can not read pages
+		if isGRPC(res.Request) {
+			return nil
+		}
+
+		// status pages for the status code take precedence over the error, maintenance and limit pages
+		if statuspage && l.renderStatusPage(res, backendname) {
+			return nil
+		}
+
+		if locallimit && len(limitpage) > 0 { // show limit page
+			nbody := &bytes.Buffer{}
+			nbody.Write(limitpage)
+			res.Header.Add("x-statuscode", fmt.Sprintf("%d", res.StatusCode))
+			res.Header.Add("x-statusmessage", res.Status)
+			res.Body = ioutil.NopCloser(nbody)
+			// force content length to new size of limit body
+			res.Header.Set("Content-Length", fmt.Sprintf("%d", len(limitpage)))
+			return nil
+		}
+
 		if localmaintenance {
 			if len(maintenancepage) > 0 { // show maintenance page
 				nbody := &bytes.Buffer{}
@@ -533,15 +742,12 @@ func (l *Listener) NewHTTPProxy() *httputil.ReverseProxy {
 			res.Header.Add("Expires", "0")
 		}
 
-		return nil
-	}
-
-	proxy := func(req *http.Request) (*url.URL, error) {
-		return http.ProxyFromEnvironment(req)
-	}
+		// Compress the reply of the backend
+		if compress {
+			l.Compression.compressResponse(res, compressStats)
+		}
 
-	tlsClientConfig := &tls.Config{
-		InsecureSkipVerify: true,
+		return nil
 	}
 
 	var localAddr *net.IPAddr
@@ -559,34 +765,16 @@ func (l *Listener) NewHTTPProxy() *httputil.ReverseProxy {
 		IP: localAddr.IP,
 	}
 
-	dialer := (&net.Dialer{
-		LocalAddr: &localTCPAddr,
-		Timeout:   10 * time.Second,
-		KeepAlive: 10 * time.Second,
-		DualStack: true,
-	}).DialContext
+	// the transport of requests to unknown backends, backends get their own transport with their timeouts
+	defaultTransport, err := newHTTPTransport(&localTCPAddr, BackendTimeouts{Idle: 10, KeepAlive: 10}, l.HTTPProto != 1)
+	if err != nil {
+		log.Fatalf("failed to prepare transport for HTTP/2: %v", err)
+	}
 
 	transport := &customTransport{
 		LocalAddr: &localTCPAddr,
-		Transport: &http.Transport{
-			Proxy:                 proxy,
-			TLSClientConfig:       tlsClientConfig,
-			DialContext:           dialer,
-			TLSHandshakeTimeout:   10 * time.Second,
-			IdleConnTimeout:       10 * time.Second,
-			MaxIdleConns:          100,
-			ExpectContinueTimeout: 1 * time.Second,
-		},
-		Listener: l,
-	}
-
-	// Websockets are not supported using HTTP/2, so if you use that, force HTTP/1.X
-	if l.HTTPProto != 1 {
-		log.Debugf("enable HTTP/2 transport")
-		err := http2.ConfigureTransport(transport.Transport)
-		if err != nil {
-			log.Fatalf("failed to prepare transport for HTTP/2: %v", err)
-		}
+		Transport: defaultTransport,
+		Listener:  l,
 	}
 
 	reverseproxy := &httputil.ReverseProxy{
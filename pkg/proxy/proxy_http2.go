@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// backendScheme returns the url scheme of the requests to a backend with connect mode
+// h2 is HTTP/2 over TLS, and h2c is HTTP/2 without TLS
+func backendScheme(connectMode string) string {
+	switch connectMode {
+	case "h2":
+		return "https"
+	case "h2c":
+		return "http"
+	}
+
+	return connectMode
+}
+
+// newBackendTransport returns the transport to the nodes of a backend, based on its connect mode
+// h2 always uses HTTP/2, other connect modes only use HTTP/2 with TLS if the listener does
+func newBackendTransport(localAddr net.Addr, connectMode string, t BackendTimeouts, listenerHTTP2 bool) (http.RoundTripper, error) {
+	switch connectMode {
+	case "h2":
+		return newHTTPTransport(localAddr, t, true)
+	case "h2c":
+		return newH2CTransport(localAddr, t), nil
+	}
+
+	return newHTTPTransport(localAddr, t, listenerHTTP2)
+}
+
+// newH2CTransport returns a transport using HTTP/2 without TLS (prior knowledge) to the nodes of a backend
+// requests to a node are multiplexed over its connections, so the idle and connection pool settings do not apply
+func newH2CTransport(localAddr net.Addr, t BackendTimeouts) http.RoundTripper {
+	dial := trackingDialer(t.dialer(localAddr).DialContext)
+	transport := &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return dial(ctx, network, addr)
+		},
+	}
+
+	return &firstByteTransport{RoundTripper: transport, timeout: seconds(t.FirstByte)}
+}
+
+// firstByteTransport cancels requests that do not get a reply within the timeout
+type firstByteTransport struct {
+	http.RoundTripper
+	timeout time.Duration
+}
+
+// errFirstByteTimeout is returned when a node does not reply within the first byte timeout
+type errFirstByteTimeout struct{}
+
+func (errFirstByteTimeout) Error() string   { return "timeout awaiting response headers" }
+func (errFirstByteTimeout) Timeout() bool   { return true }
+func (errFirstByteTimeout) Temporary() bool { return true }
+
+// RoundTrip sends the request, and returns a timeout error if the reply takes too long
+func (t *firstByteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.timeout <= 0 {
+		return t.RoundTripper.RoundTrip(req)
+	}
+
+	ctx, cancel := context.WithCancel(req.Context())
+	var timedOut int32
+	timer := time.AfterFunc(t.timeout, func() {
+		atomic.StoreInt32(&timedOut, 1)
+		cancel()
+	})
+
+	res, err := t.RoundTripper.RoundTrip(req.WithContext(ctx))
+	timer.Stop()
+	if atomic.LoadInt32(&timedOut) == 1 {
+		if err == nil {
+			res.Body.Close()
+		}
+		cancel()
+		return nil, errFirstByteTimeout{}
+	}
+
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	res.Body = cancelBody{ReadCloser: res.Body, cancel: cancel}
+	return res, nil
+}
+
+// CloseIdleConnections closes the unused connections to the nodes
+func (t *firstByteTransport) CloseIdleConnections() {
+	if c, ok := t.RoundTripper.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
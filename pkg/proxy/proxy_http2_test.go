@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func TestH2CTransport(t *testing.T) {
+	server := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(2 * time.Second)
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Write([]byte(r.Proto))
+		w.Header().Set("Grpc-Status", "0")
+	}), &http2.Server{}))
+	defer server.Close()
+
+	l := New("listener-id", "Listener", 999)
+	l.HTTPProto = 1
+	l.AddBackend("grpc-id", "grpc", "roundrobin", "h2c", []string{"grpc.example.com"}, 1, ErrorPage{}, ErrorPage{})
+	l.Backends["grpc"].SetTimeouts(BackendTimeouts{FirstByte: 1})
+	transport := &customTransport{Transport: &http.Transport{}, Listener: l}
+
+	req, _ := http.NewRequest("POST", server.URL+"/service/Method", strings.NewReader("request"))
+	req.Header.Set("Content-Type", "application/grpc")
+	res, err := transport.transport("grpc").RoundTrip(req)
+	if assert.Nil(t, err) {
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		assert.Equal(t, 2, res.ProtoMajor)
+		assert.Equal(t, "HTTP/2.0", string(body))
+		assert.Equal(t, "0", res.Trailer.Get("Grpc-Status"))
+	}
+
+	req, _ = http.NewRequest("POST", server.URL+"/slow", nil)
+	_, err = transport.transport("grpc").RoundTrip(req)
+	if assert.NotNil(t, err) {
+		assert.True(t, isTimeout(err))
+	}
+
+	assert.Equal(t, "http", backendScheme("h2c"))
+	assert.Equal(t, "https", backendScheme("h2"))
+	assert.Equal(t, "https", backendScheme("https"))
+}
+
+func TestGRPCStatusPage(t *testing.T) {
+	req := httptest.NewRequest("POST", "http://grpc.example.com/service/Method", nil)
+	req.Header.Set("Content-Type", "application/grpc+proto")
+
+	res := customStatusPage(503, "Service Unavailable - no backend available", req)
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "application/grpc", res.Header.Get("Content-Type"))
+	assert.Equal(t, "14", res.Header.Get("Grpc-Status"))
+	assert.Equal(t, "503 Service Unavailable - no backend available", res.Header.Get("Grpc-Message"))
+
+	res = customStatusPage(500, "100% broken\n", req)
+	assert.Equal(t, "2", res.Header.Get("Grpc-Status"))
+	assert.Equal(t, "500 100%25 broken%0A", res.Header.Get("Grpc-Message"))
+
+	req.Header.Set("Content-Type", "text/html")
+	res = customStatusPage(503, "Service Unavailable", req)
+	assert.Equal(t, 503, res.StatusCode)
+	assert.Equal(t, "", res.Header.Get("Grpc-Status"))
+}
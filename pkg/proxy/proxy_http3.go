@@ -0,0 +1,47 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+
+	"github.com/schubergphilis/mercury/pkg/logging"
+)
+
+// newHTTP3Server returns the HTTP/3 server of an https listener, using its certificates and handler
+// the QUIC connections of its clients are counted in the HTTP3Statistics of the listener
+func (l *Listener) newHTTP3Server(handler http.Handler) *http3.Server {
+	return &http3.Server{
+		Addr:      fmt.Sprintf("%s:%d", l.IP, l.Port),
+		Handler:   handler,
+		TLSConfig: http3.ConfigureTLSConfig(l.TLSConfig),
+		ConnContext: func(ctx context.Context, c quic.Connection) context.Context {
+			l.HTTP3Statistics.ClientsConnectedAdd(1)
+			go func() {
+				<-c.Context().Done()
+				l.HTTP3Statistics.ClientsConnectedSub(1)
+			}()
+
+			return ctx
+		},
+	}
+}
+
+// altSvc advertises the HTTP/3 server in the Alt-Svc header of the replies of the https listener
+func altSvc(srv *http3.Server, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := srv.SetQUICHeaders(w.Header()); err != nil {
+			logging.For("proxy/http3").WithError(err).Debug("Unable to advertise HTTP/3")
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isHTTP3 returns true if the client sent the request over HTTP/3
+func isHTTP3(req *http.Request) bool {
+	return req.ProtoMajor == 3
+}
@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/schubergphilis/mercury/pkg/healthcheck"
+	"github.com/schubergphilis/mercury/pkg/logging"
+)
+
+func TestHTTP3Listener(t *testing.T) {
+	logging.Configure("stdout", "error")
+
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer node.Close()
+
+	nodeHost, nodePortStr, _ := net.SplitHostPort(node.Listener.Addr().String())
+	nodePort, _ := strconv.Atoi(nodePortStr)
+
+	// the certificate of a tls test server is valid for 127.0.0.1
+	certServer := httptest.NewTLSServer(http.NotFoundHandler())
+	certServer.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(certServer.Certificate())
+
+	// find a port that is free for tcp, and use it for udp as well
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.Nil(t, err) {
+		return
+	}
+	port := free.Addr().(*net.TCPAddr).Port
+	free.Close()
+
+	l := New("listener-id", "Listener", 999)
+	l.SetListener(HTTPS, "", "127.0.0.1", port, 10, &tls.Config{Certificates: certServer.TLS.Certificates}, 10, 10, 2, "no")
+	l.SetHTTP3(true)
+	l.AddBackend("backend-id", "backend", "roundrobin", "http", []string{"default"}, 999, ErrorPage{}, ErrorPage{})
+	l.Backends["backend"].AddBackendNode(NewBackendNode("node-id", nodeHost, "localhost", nodePort, 10, []string{}, 0, 0, healthcheck.Online))
+	go l.Start()
+
+	addr := fmt.Sprintf("127.0.0.1:%d", port)
+	for i := 0; i < 50; i++ {
+		if c, err := net.Dial("tcp", addr); err == nil {
+			c.Close()
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// the https listener advertises HTTP/3
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	res, err := client.Get("https://" + addr + "/")
+	if assert.Nil(t, err) {
+		res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Contains(t, res.Header.Get("Alt-Svc"), fmt.Sprintf(`h3=":%d"`, port))
+	}
+
+	// HTTP/3 requests are routed to the same backend, and counted separately
+	transport := &http3.RoundTripper{TLSClientConfig: &tls.Config{RootCAs: roots}}
+	defer transport.Close()
+	client = &http.Client{Transport: transport, Timeout: 5 * time.Second}
+	res, err = client.Get("https://" + addr + "/")
+	if assert.Nil(t, err) {
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, 3, res.ProtoMajor)
+		assert.Equal(t, "hello", string(body))
+	}
+
+	assert.Equal(t, int64(1), l.Statistics.ClientsConnectsGet())
+	assert.Equal(t, int64(1), l.HTTP3Statistics.ClientsConnectsGet())
+	assert.Equal(t, int64(1), l.HTTP3Statistics.ClientsConnectedGet())
+
+	// closing the tcp socket lets the https server stop, as the limit listener does not close it
+	l.socket.TCPListener.Close()
+	transport.Close()
+	l.Stop()
+	for i := 0; i < 50 && l.HTTP3Statistics.ClientsConnectedGet() > 0; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	assert.Equal(t, int64(0), l.HTTP3Statistics.ClientsConnectedGet())
+}
@@ -9,6 +9,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/quic-go/quic-go/http3"
 	"golang.org/x/net/http2"
 
 	"github.com/schubergphilis/mercury/pkg/balancer"
@@ -42,21 +43,29 @@ type Listener struct {
 	stop            chan bool
 	ErrorPage       ErrorPage
 	MaintenancePage ErrorPage
+	LimitPage       ErrorPage
+	statusPages     statusPages
+	WAF             *WAF
+	Compression     CompressionConfig
+	TrafficSplit    TrafficSplit
 	ReadTimeout     int // Timeout in seconds to wait for the client sending the request - https://blog.cloudflare.com/the-complete-guide-to-golang-net-http-timeouts/
 	WriteTimeout    int // Timeout in seconds to wait for server reply to client
 	Uptime          time.Time
-	OCSPStapling    string // use OCSP Stapling
+	OCSPStapling    string               // use OCSP Stapling
+	HTTP3           bool                 // also serve HTTP/3 over QUIC on the udp port of an https listener
+	HTTP3Statistics *balancer.Statistics // statistics of the HTTP/3 clients, which are not counted in Statistics
 }
 
 // New creates a new proxy for using a listener
 func New(uuid string, name string, maxconnections int) *Listener {
 	return &Listener{
-		UUID:       uuid,
-		Name:       name,
-		Backends:   make(map[string]*Backend),
-		stop:       make(chan bool, 1),
-		Statistics: balancer.NewStatistics(uuid, maxconnections),
-		Uptime:     time.Now(),
+		UUID:            uuid,
+		Name:            name,
+		Backends:        make(map[string]*Backend),
+		stop:            make(chan bool, 1),
+		Statistics:      balancer.NewStatistics(uuid, maxconnections),
+		HTTP3Statistics: balancer.NewStatistics(uuid, maxconnections),
+		Uptime:          time.Now(),
 	}
 }
 
@@ -84,6 +93,8 @@ func (l *Listener) Start() {
 	log.Debug("Starting listener")
 
 	var httpsrv *http.Server
+	var http3srv *http3.Server
+	var udpconn net.PacketConn
 	var tcplistener net.Listener
 	var listener net.Listener
 	var err error
@@ -104,7 +115,7 @@ func (l *Listener) Start() {
 			ReadTimeout:  time.Duration(l.ReadTimeout) * time.Second,
 			WriteTimeout: time.Duration(l.WriteTimeout) * time.Second,
 			Addr:         fmt.Sprintf("%s:%d", l.IP, l.Port),
-			Handler:      proxy,
+			Handler:      releaseLimits(proxy),
 			ErrorLog:     logging.StandardLog("listener/http"),
 		}
 
@@ -135,11 +146,18 @@ func (l *Listener) Start() {
 			return nil, nil
 		}
 
+		handler := releaseLimits(proxy)
+		if l.HTTP3 {
+			http3srv = l.newHTTP3Server(handler)
+			// advertise HTTP/3 to the clients of the https listener
+			handler = altSvc(http3srv, handler)
+		}
+
 		httpsrv = &http.Server{
 			ReadTimeout:  time.Duration(l.ReadTimeout) * time.Second,
 			WriteTimeout: time.Duration(l.WriteTimeout) * time.Second,
 			Addr:         fmt.Sprintf("%s:%d", l.IP, l.Port),
-			Handler:      proxy,
+			Handler:      handler,
 			TLSConfig:    l.TLSConfig,
 			ErrorLog:     logging.StandardLog("listener/https"),
 			//TLSNextProto: make(map[string]func(*http.Server, *tls.Conn, http.Handler), 0),
@@ -166,6 +184,16 @@ func (l *Listener) Start() {
 
 		go httpsrv.Serve(tlsListener)
 
+		if http3srv != nil {
+			udpconn, err = net.ListenPacket("udp", httpsrv.Addr)
+			if err != nil {
+				log.WithField("error", err).Error("Error starting HTTP/3 proxy listener")
+				http3srv = nil
+			} else {
+				go http3srv.Serve(udpconn)
+			}
+		}
+
 	case "udp":
 		// TODO: not implemented yet
 	}
@@ -183,6 +211,12 @@ func (l *Listener) Start() {
 
 			case HTTPS:
 				log.Debug("Stopping HTTP(s) Proxy on request")
+				// websockets are not closed by the shutdown, send their clients a close frame
+				if websockets := l.closeWebsockets(websocketGoingAway, "going away"); websockets > 0 {
+					log.WithField("websockets", websockets).Debug("Closing websockets")
+					time.Sleep(websocketCloseGrace)
+				}
+
 				// create a 10 second context for shutdown, whichever comes first
 				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 				defer cancel()
@@ -192,6 +226,14 @@ func (l *Listener) Start() {
 					listener.Close()
 				}
 
+				if http3srv != nil {
+					log.Debug("Stopping HTTP/3 Proxy on request")
+					if err := http3srv.Shutdown(ctx); err != nil {
+						log.Debugf("Gracefull stop of HTTP/3 Proxy failed: %s", err)
+					}
+					udpconn.Close()
+				}
+
 				if l.OCSPStapling == YES {
 					log.Debug("Stopping of Proxy finished, stopping ocsp")
 					select {
@@ -238,6 +280,70 @@ func (l *Listener) FindBackendByHost(req string) (string, *Backend) {
 	return defaulthost, defaultbackend
 }
 
+// FindBackendByServerName searches for the backend of the TLS server name requested by a tcp client
+// an exact match wins over the longest matching wildcard, and the default backend is used if neither matches
+func (l *Listener) FindBackendByServerName(serverName string) (string, *Backend) {
+	var defaulthost, wildcardhost, wildcard string
+	var defaultbackend, wildcardbackend *Backend
+	for id, backend := range l.Backends {
+		for _, host := range backend.Hostname {
+			switch {
+			case strings.EqualFold(host, serverName):
+				return id, backend
+
+			case matchWildcardHost(host, serverName) && len(host) > len(wildcard):
+				wildcard = host
+				wildcardhost = id
+				wildcardbackend = backend
+
+			case strings.EqualFold(host, "default"):
+				defaulthost = id
+				defaultbackend = backend
+			}
+		}
+	}
+
+	if wildcardbackend != nil {
+		return wildcardhost, wildcardbackend
+	}
+
+	return defaulthost, defaultbackend
+}
+
+// routesServerNames returns true if tcp clients are routed on their TLS server name
+// this requires multiple backends, of which one has hostnames other than default
+func (l *Listener) routesServerNames() bool {
+	if len(l.Backends) < 2 {
+		return false
+	}
+
+	for _, backend := range l.Backends {
+		for _, host := range backend.Hostname {
+			if !strings.EqualFold(host, "default") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// matchWildcardHost returns true if host is a wildcard (*.example.com) matching a single label of req
+func matchWildcardHost(host, req string) bool {
+	if !strings.HasPrefix(host, "*.") {
+		return false
+	}
+
+	suffix := strings.ToLower(host[1:])
+	req = strings.ToLower(req)
+	if !strings.HasSuffix(req, suffix) {
+		return false
+	}
+
+	label := strings.TrimSuffix(req, suffix)
+	return label != "" && !strings.Contains(label, ".")
+}
+
 // FindAllHostNames searches for matching backend by hostname requested
 func (l *Listener) FindAllHostNames() []string {
 	var hostname []string
@@ -282,6 +388,11 @@ func (l *Listener) SetListener(mode string, sourceIP string, ip string, port int
 	l.OCSPStapling = ocspStapling
 }
 
+// SetHTTP3 enables HTTP/3 next to an https listener, it is applied when the listener starts
+func (l *Listener) SetHTTP3(enabled bool) {
+	l.HTTP3 = enabled
+}
+
 // UpdateBackend adds a backend to an existing proxy, or updates an existing one
 func (l *Listener) UpdateBackend(uuid string, name string, balancemode string, connectmode string, hostname []string, maxconnections int, errorPage ErrorPage, maintenancePage ErrorPage) {
 	if backend, ok := l.Backends[name]; ok {
@@ -300,9 +411,10 @@ func (l *Listener) UpdateBackend(uuid string, name string, balancemode string, c
 	}
 }
 
-// RemoveBackend removes a backend from the listener
+// RemoveBackend removes a backend from the listener, the clients of its websockets get a close frame
 func (l *Listener) RemoveBackend(name string) {
-	if _, ok := l.Backends[name]; ok {
+	if backend, ok := l.Backends[name]; ok {
+		backend.closeWebsockets(nil, websocketGoingAway, "going away")
 		delete(l.Backends, name)
 	}
 }
@@ -313,6 +425,12 @@ func (l *Listener) LoadErrorPage(e ErrorPage) error {
 	return l.ErrorPage.load()
 }
 
+// LoadLimitPage preloads the page shown to clients that reached a limit
+func (l *Listener) LoadLimitPage(e ErrorPage) error {
+	l.LimitPage = e
+	return l.LimitPage.load()
+}
+
 // LoadMaintenancePage preloads the error page
 func (l *Listener) LoadMaintenancePage(e ErrorPage) error {
 	l.MaintenancePage = e
@@ -331,6 +449,7 @@ func (l *Listener) GetBackendStats(backendName string) *balancer.Statistics {
 		backendStats.RXAdd(node.Statistics.RXGet())
 		backendStats.RXAdd(node.Statistics.RXGet())
 		backendStats.ResponseTimeValueMerge(node.Statistics.ResponseTimeValueGet())
+		backendStats.CompressionAdd(node.Statistics.CompressionGet())
 	}
 
 	return backendStats
@@ -0,0 +1,363 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/schubergphilis/mercury/pkg/logging"
+)
+
+// defaultMirrorTimeout is the time to wait for the reply of a mirror without a timeout
+const defaultMirrorTimeout = 10 * time.Second
+
+// MirrorConfig sends a copy of the requests of a backend to another backend, without waiting for its reply
+type MirrorConfig struct {
+	Backend     string  `json:"backend" toml:"backend"`             // backend of the same pool receiving the copies
+	SampleRate  float64 `json:"sample_rate" toml:"sample_rate"`     // share of the requests to copy, between 0 and 1
+	MaxBodySize int64   `json:"max_body_size" toml:"max_body_size"` // requests with a larger body are not copied
+	Timeout     int     `json:"timeout" toml:"timeout"`             // seconds to wait for the reply of the mirror
+	MaxPending  int64   `json:"max_pending" toml:"max_pending"`     // copies waiting for a reply, before new requests are not copied
+}
+
+// MirrorStatistics are the statistics of the copies of requests sent to the mirror of a backend
+type MirrorStatistics struct {
+	Requests     int64   `json:"requests"`     // copies sent
+	Errors       int64   `json:"errors"`       // copies that failed, or got a 5xx reply
+	Skipped      int64   `json:"skipped"`      // requests not copied, due to their body size, an upgrade or the pending copies
+	ErrorRate    float64 `json:"error_rate"`   // percentage of the copies that failed
+	LatencyDiff  float64 `json:"latency_diff"` // average seconds the mirror replied slower than the backend
+	pending      int64
+	latencyCount int64
+	latencyTotal float64
+}
+
+// mirrorRequest is a copy of a request sent to a mirror
+type mirrorRequest struct {
+	primary chan mirrorPrimary
+}
+
+// mirrorPrimary is the result of the request to the backend
+type mirrorPrimary struct {
+	took time.Duration
+	err  error
+}
+
+// Validate checks the mirror settings
+func (c MirrorConfig) Validate() error {
+	if c.Backend == "" {
+		return nil
+	}
+
+	if c.SampleRate < 0 || c.SampleRate > 1 {
+		return fmt.Errorf("mirror sample_rate must be between 0 and 1: %f", c.SampleRate)
+	}
+
+	if c.MaxBodySize < 0 || c.Timeout < 0 || c.MaxPending < 0 {
+		return fmt.Errorf("mirror max_body_size, timeout and max_pending can not be negative")
+	}
+
+	return nil
+}
+
+// SetMirror sets the mirror of the backend, an empty backend disables mirroring
+func (b *Backend) SetMirror(c MirrorConfig) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	b.sync.Lock()
+	defer b.sync.Unlock()
+	b.Mirror = c
+	return nil
+}
+
+// mirror returns the mirror of the backend
+func (b *Backend) mirror() MirrorConfig {
+	b.sync.RLock()
+	defer b.sync.RUnlock()
+	return b.Mirror
+}
+
+// mirrorStart counts a copy that is sent, or a skipped request if too many copies are pending
+func (b *Backend) mirrorStart(maxPending int64) bool {
+	b.sync.Lock()
+	defer b.sync.Unlock()
+	if maxPending > 0 && b.mirrorStats.pending >= maxPending {
+		b.mirrorStats.Skipped++
+		return false
+	}
+
+	b.mirrorStats.pending++
+	b.mirrorStats.Requests++
+	return true
+}
+
+// mirrorSkip counts a request that was not copied
+func (b *Backend) mirrorSkip() {
+	b.sync.Lock()
+	defer b.sync.Unlock()
+	b.mirrorStats.Skipped++
+}
+
+// mirrorDone counts a finished copy, and how much slower the mirror replied if both replied
+func (b *Backend) mirrorDone(failed bool, diff *time.Duration) {
+	b.sync.Lock()
+	defer b.sync.Unlock()
+	b.mirrorStats.pending--
+	if failed {
+		b.mirrorStats.Errors++
+	}
+
+	if diff != nil {
+		b.mirrorStats.latencyCount++
+		b.mirrorStats.latencyTotal += diff.Seconds()
+	}
+}
+
+// MirrorStatistics returns the statistics of the copies sent to the mirror of the backend
+func (b *Backend) MirrorStatistics() MirrorStatistics {
+	b.sync.RLock()
+	defer b.sync.RUnlock()
+	stats := b.mirrorStats
+	if stats.Requests > 0 {
+		stats.ErrorRate = math.Round(float64(stats.Errors)/float64(stats.Requests)*10000) / 100
+	}
+
+	if stats.latencyCount > 0 {
+		stats.LatencyDiff = math.Round(stats.latencyTotal/float64(stats.latencyCount)*10000) / 10000
+	}
+
+	return stats
+}
+
+// mirror sends a copy of the request to the mirror of the backend, if it has one
+// the body of the request is copied to the mirror while the backend reads it, the request itself is not delayed by the mirror
+// done must be called on the result with the time the backend took to reply, to compare the latency
+func (t *customTransport) mirror(req *http.Request, backendname string) *mirrorRequest {
+	backend, ok := t.Listener.Backends[backendname]
+	if !ok {
+		return nil
+	}
+
+	c := backend.mirror()
+	if c.Backend == "" || rand.Float64() >= c.SampleRate {
+		return nil
+	}
+
+	log := logging.For("proxy/mirror").WithField("backend", backendname).WithField("mirror", c.Backend).WithField("requestid", requestID(req))
+	target, ok := t.Listener.Backends[c.Backend]
+	if !ok {
+		log.Debug("Mirror backend not found")
+		return nil
+	}
+
+	// upgraded connections, like websockets, are not copied
+	if req.Header.Get("Upgrade") != "" {
+		backend.mirrorSkip()
+		return nil
+	}
+
+	hasBody := req.Body != nil && req.Body != http.NoBody
+	if hasBody && (req.ContentLength < 0 || req.ContentLength > c.MaxBodySize) {
+		backend.mirrorSkip()
+		return nil
+	}
+
+	if !backend.mirrorStart(c.MaxPending) {
+		return nil
+	}
+
+	remote := stringToClientIP(req.RemoteAddr)
+	m := &mirrorRequest{primary: make(chan mirrorPrimary, 1)}
+	mreq := req.Clone(context.Background())
+	removeHopHeaders(mreq.Header)
+	var body *mirrorBody
+	if hasBody {
+		body = newMirrorBody(req.ContentLength)
+		req.Body = teeBody{ReadCloser: req.Body, copy: body}
+		mreq.Body = &mirrorBodyReader{body: body}
+	} else {
+		mreq.Body = nil
+	}
+
+	go func() {
+		timeout := time.Duration(c.Timeout) * time.Second
+		if timeout <= 0 {
+			timeout = defaultMirrorTimeout
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if body != nil {
+			// the body of the copy stops when it times out, even if the backend is still reading it
+			stop := context.AfterFunc(ctx, func() {
+				body.finish(ctx.Err())
+			})
+			defer stop()
+		}
+
+		node, _, err := target.GetBackendNodeBalanced(c.Backend, remote.IP, "", target.BalanceMode)
+		if err != nil {
+			log.WithError(err).Debug("No mirror node available")
+			backend.mirrorDone(true, nil)
+			return
+		}
+
+		mreq = mreq.WithContext(ctx)
+		mreq.URL.Scheme = backendScheme(target.ConnectMode)
+		mreq.URL.Host = fmt.Sprintf("%s:%d", node.IP, node.Port)
+
+		start := time.Now()
+		res, err := t.transport(c.Backend).RoundTrip(mreq)
+		took := time.Since(start)
+		if err != nil {
+			log.WithError(err).Debug("Mirror request failed")
+			backend.mirrorDone(true, nil)
+			return
+		}
+		io.Copy(ioutil.Discard, res.Body)
+		res.Body.Close()
+
+		failed := res.StatusCode >= 500
+		select {
+		case primary := <-m.primary:
+			if primary.err != nil {
+				backend.mirrorDone(failed, nil)
+				return
+			}
+
+			diff := took - primary.took
+			backend.mirrorDone(failed, &diff)
+		case <-ctx.Done():
+			backend.mirrorDone(failed, nil)
+		}
+	}()
+
+	return m
+}
+
+// hopHeaders are the headers of a single connection, which are not sent to the mirror
+var hopHeaders = []string{"Connection", "Proxy-Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization", "Te", "Trailer", "Transfer-Encoding", "Upgrade"}
+
+// removeHopHeaders removes the hop-by-hop headers, and the headers listed in the Connection header
+func removeHopHeaders(header http.Header) {
+	for _, value := range header["Connection"] {
+		for _, key := range strings.Split(value, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				header.Del(key)
+			}
+		}
+	}
+
+	for _, key := range hopHeaders {
+		header.Del(key)
+	}
+}
+
+// done passes the time the backend took to reply to the copy of the request, or the error of the backend
+// the latency is only compared if the backend replied
+func (m *mirrorRequest) done(took time.Duration, err error) {
+	if m == nil {
+		return
+	}
+
+	m.primary <- mirrorPrimary{took: took, err: err}
+}
+
+// mirrorBody is the body of a request, as far as it is read by the backend
+type mirrorBody struct {
+	lock sync.Mutex
+	read *sync.Cond
+	data []byte
+	size int64
+	err  error // set once the body is read completely, or not read any further
+}
+
+// newMirrorBody returns an empty copy of a body of size bytes
+func newMirrorBody(size int64) *mirrorBody {
+	b := &mirrorBody{data: make([]byte, 0, size), size: size}
+	b.read = sync.NewCond(&b.lock)
+	return b
+}
+
+// write adds what the backend read of the body
+func (b *mirrorBody) write(p []byte) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.err != nil {
+		return
+	}
+
+	b.data = append(b.data, p...)
+	if int64(len(b.data)) >= b.size {
+		b.err = io.EOF
+	}
+	b.read.Broadcast()
+}
+
+// finish ends the body with err, unless it already ended
+func (b *mirrorBody) finish(err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.err == nil {
+		b.err = err
+	}
+	b.read.Broadcast()
+}
+
+// teeBody is the body of a request to the backend, copying what is read to the mirror
+type teeBody struct {
+	io.ReadCloser
+	copy *mirrorBody
+}
+
+func (t teeBody) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	t.copy.write(p[:n])
+	if err != nil {
+		t.copy.finish(err)
+	}
+
+	return n, err
+}
+
+// Close closes the body, the copy ends early if the backend did not read the whole body
+func (t teeBody) Close() error {
+	t.copy.finish(io.ErrUnexpectedEOF)
+	return t.ReadCloser.Close()
+}
+
+// mirrorBodyReader reads the copy of a body, waiting for the backend to read more of it
+type mirrorBodyReader struct {
+	body   *mirrorBody
+	offset int
+}
+
+func (r *mirrorBodyReader) Read(p []byte) (int, error) {
+	r.body.lock.Lock()
+	defer r.body.lock.Unlock()
+	for r.offset == len(r.body.data) && r.body.err == nil {
+		r.body.read.Wait()
+	}
+
+	if r.offset < len(r.body.data) {
+		n := copy(p, r.body.data[r.offset:])
+		r.offset += n
+		return n, nil
+	}
+
+	return 0, r.body.err
+}
+
+// Close stops reading the copy of the body
+func (r *mirrorBodyReader) Close() error {
+	return nil
+}
@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/schubergphilis/mercury/pkg/healthcheck"
+	"github.com/schubergphilis/mercury/pkg/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMirror(t *testing.T) {
+	logging.Configure("stdout", "error")
+	received := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get("X-Hop") != "" || r.Header.Get("Proxy-Connection") != "" {
+			received <- "hop-by-hop headers copied"
+			return
+		}
+		received <- r.Method + " " + r.Host + r.URL.Path + " " + string(body)
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(500)
+		}
+	}))
+	defer server.Close()
+
+	host, portString, _ := net.SplitHostPort(server.Listener.Addr().String())
+	port, _ := strconv.Atoi(portString)
+
+	l := New("listener-id", "Listener", 999)
+	l.AddBackend("web-id", "web", "roundrobin", "http", []string{"www.example.com"}, 1, ErrorPage{}, ErrorPage{})
+	l.AddBackend("shadow-id", "shadow", "roundrobin", "http", []string{"shadow.example.com"}, 1, ErrorPage{}, ErrorPage{})
+	l.Backends["shadow"].AddBackendNode(NewBackendNode("shadow-node", host, host, port, 10, []string{}, 0, 0, healthcheck.Online))
+	assert.Nil(t, l.Backends["web"].SetMirror(MirrorConfig{Backend: "shadow", SampleRate: 1, MaxBodySize: 10, Timeout: 5}))
+	transport := &customTransport{Transport: &http.Transport{}, Listener: l}
+
+	// the request is copied, and its body can still be read
+	req := httptest.NewRequest("POST", "http://www.example.com/post", strings.NewReader("hello"))
+	req.Header.Set("Connection", "X-Hop")
+	req.Header.Set("X-Hop", "1")
+	req.Header.Set("Proxy-Connection", "keep-alive")
+	m := transport.mirror(req, "web")
+	if assert.NotNil(t, m) {
+		body, _ := ioutil.ReadAll(req.Body)
+		assert.Equal(t, "hello", string(body))
+		m.done(time.Millisecond, nil)
+		assert.Equal(t, "POST www.example.com/post hello", <-received)
+	}
+
+	// failures are counted
+	m = transport.mirror(httptest.NewRequest("GET", "http://www.example.com/fail", nil), "web")
+	m.done(time.Millisecond, nil)
+	assert.Equal(t, "GET www.example.com/fail ", <-received)
+
+	// the body is copied while the backend reads it
+	client, clientBody := io.Pipe()
+	req = httptest.NewRequest("POST", "http://www.example.com/stream", client)
+	req.ContentLength = 5
+	mirrored := make(chan *mirrorRequest)
+	go func() {
+		mirrored <- transport.mirror(req, "web")
+	}()
+	select {
+	case m = <-mirrored:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the mirror waited for the body of the request")
+	}
+	go func() {
+		clientBody.Write([]byte("hello"))
+		clientBody.Close()
+	}()
+	body, _ := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	assert.Equal(t, "hello", string(body))
+	m.done(time.Millisecond, nil)
+	assert.Equal(t, "POST www.example.com/stream hello", <-received)
+
+	// the latency is not compared if the backend failed, and the copy does not wait for it
+	m = transport.mirror(httptest.NewRequest("GET", "http://www.example.com/ok", nil), "web")
+	m.done(time.Millisecond, errors.New("backend failed"))
+	assert.Equal(t, "GET www.example.com/ok ", <-received)
+
+	// large bodies are not copied
+	req = httptest.NewRequest("POST", "http://www.example.com/post", strings.NewReader("hello world!"))
+	assert.Nil(t, transport.mirror(req, "web"))
+	body, _ = ioutil.ReadAll(req.Body)
+	assert.Equal(t, "hello world!", string(body))
+
+	// upgraded connections are not copied
+	req = httptest.NewRequest("GET", "http://www.example.com/ws", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	assert.Nil(t, transport.mirror(req, "web"))
+
+	// backends without a mirror
+	assert.Nil(t, transport.mirror(httptest.NewRequest("GET", "http://shadow.example.com/", nil), "shadow"))
+
+	for i := 0; i < 50; i++ {
+		stats := l.Backends["web"].MirrorStatistics()
+		if stats.pending == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stats := l.Backends["web"].MirrorStatistics()
+	assert.Equal(t, int64(0), stats.pending)
+	assert.Equal(t, int64(4), stats.Requests)
+	assert.Equal(t, int64(1), stats.Errors)
+	assert.Equal(t, int64(2), stats.Skipped)
+	assert.Equal(t, int64(3), stats.latencyCount)
+	assert.Equal(t, float64(25), stats.ErrorRate)
+
+	assert.NotNil(t, MirrorConfig{Backend: "shadow", SampleRate: 2}.Validate())
+	assert.NotNil(t, MirrorConfig{Backend: "shadow", SampleRate: 1, Timeout: -1}.Validate())
+	assert.Nil(t, MirrorConfig{SampleRate: 2}.Validate())
+}
@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	uuid "github.com/nu7hatch/gouuid"
+)
+
+// requestIDHeader is the header with the id of a request, sent to the backend and back to the client
+const requestIDHeader = "X-Request-Id"
+
+// requestIDRegex matches request ids of clients that are used as is, printable ascii without spaces
+var requestIDRegex = regexp.MustCompile(`^[\x21-\x7e]{1,128}$`)
+
+// traceparentRegex matches a W3C traceparent header, the trace id is used as request id
+var traceparentRegex = regexp.MustCompile(`^[0-9a-f]{2}-([0-9a-f]{32})-[0-9a-f]{16}-[0-9a-f]{2}$`)
+
+// setRequestID sets the id of a request in its X-Request-Id header, and returns it
+// the id is the X-Request-Id of the client, the trace id of its traceparent header, or a newly generated id
+func setRequestID(req *http.Request) string {
+	id := req.Header.Get(requestIDHeader)
+	if !requestIDRegex.MatchString(id) {
+		id = ""
+		if match := traceparentRegex.FindStringSubmatch(req.Header.Get("Traceparent")); match != nil && match[1] != "00000000000000000000000000000000" {
+			id = match[1]
+		}
+	}
+
+	if id == "" {
+		id = newRequestID()
+	}
+
+	req.Header.Set(requestIDHeader, id)
+	return id
+}
+
+// newRequestID returns a new random request id
+func newRequestID() string {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+
+	return id.String()
+}
+
+// requestID returns the id of a request
+func requestID(req *http.Request) string {
+	if req == nil {
+		return ""
+	}
+
+	return req.Header.Get(requestIDHeader)
+}
@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/schubergphilis/mercury/pkg/healthcheck"
+	"github.com/schubergphilis/mercury/pkg/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetRequestID(t *testing.T) {
+	// the id of the client is used if valid
+	req := httptest.NewRequest("GET", "http://www.example.com/", nil)
+	req.Header.Set("X-Request-Id", "client-id-1")
+	assert.Equal(t, "client-id-1", setRequestID(req))
+
+	// the trace id of a traceparent is used
+	req = httptest.NewRequest("GET", "http://www.example.com/", nil)
+	req.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", setRequestID(req))
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", req.Header.Get("X-Request-Id"))
+
+	// invalid ids are replaced
+	req = httptest.NewRequest("GET", "http://www.example.com/", nil)
+	req.Header.Set("X-Request-Id", "id with spaces")
+	req.Header.Set("Traceparent", "00-00000000000000000000000000000000-00f067aa0ba902b7-01")
+	id := setRequestID(req)
+	assert.Len(t, id, 36)
+	assert.Equal(t, id, requestID(req))
+	assert.NotEqual(t, id, setRequestID(httptest.NewRequest("GET", "http://www.example.com/", nil)))
+
+	value, err := getVariableValue("REQUEST_ID", nil, nil, req)
+	assert.Nil(t, err)
+	assert.Equal(t, id, value)
+}
+
+func TestRequestIDPropagation(t *testing.T) {
+	logging.Configure("stdout", "error")
+	var received string
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get("X-Request-Id")
+	}))
+	defer node.Close()
+
+	nodeHost, nodePortStr, _ := net.SplitHostPort(node.Listener.Addr().String())
+	nodePort, _ := strconv.Atoi(nodePortStr)
+
+	l := New("listener-id", "Listener", 999)
+	l.HTTPProto = 1
+	l.AddBackend("backend-id", "backend", "roundrobin", "http", []string{"www.example.com"}, 999, ErrorPage{}, ErrorPage{})
+	l.Backends["backend"].AddBackendNode(NewBackendNode("node-id", nodeHost, "localhost", nodePort, 10, []string{}, 0, 0, healthcheck.Online))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.Nil(t, err) {
+		return
+	}
+	l.socket = limitListenerConnections(listener.(*net.TCPListener), 10)
+	srv := &http.Server{Handler: l.NewHTTPProxy()}
+	go srv.Serve(l.socket)
+	defer srv.Close()
+	defer listener.Close()
+
+	// the backend and the client get the same id
+	req, _ := http.NewRequest("GET", "http://"+listener.Addr().String()+"/", nil)
+	req.Host = "www.example.com"
+	res, err := http.DefaultClient.Do(req)
+	if assert.Nil(t, err) {
+		res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.NotEmpty(t, received)
+		assert.Equal(t, received, res.Header.Get("X-Request-Id"))
+	}
+
+	// local replies get the id of the client
+	req, _ = http.NewRequest("GET", "http://"+listener.Addr().String()+"/", nil)
+	req.Host = "unknown.example.com"
+	req.Header.Set("X-Request-Id", "client-id")
+	res, err = http.DefaultClient.Do(req)
+	if assert.Nil(t, err) {
+		res.Body.Close()
+		assert.NotEqual(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, "client-id", res.Header.Get("X-Request-Id"))
+	}
+}
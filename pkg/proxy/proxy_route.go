@@ -0,0 +1,280 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Route sends the requests matching all of its conditions to a backend
+type Route struct {
+	Name        string            `json:"name" toml:"name"`                 // name of the route in logs and statistics
+	Priority    int               `json:"priority" toml:"priority"`         // routes with a higher priority are evaluated first
+	PathPrefix  string            `json:"path_prefix" toml:"path_prefix"`   // request path prefix to match
+	PathRegex   string            `json:"path_regex" toml:"path_regex"`     // request path regex to match
+	Methods     []string          `json:"methods" toml:"methods"`           // request methods to match
+	Headers     map[string]string `json:"headers" toml:"headers"`           // header and regex to match
+	Cookies     map[string]string `json:"cookies" toml:"cookies"`           // cookie and regex to match
+	Query       map[string]string `json:"query" toml:"query"`               // query parameter and regex to match
+	StripPrefix bool              `json:"strip_prefix" toml:"strip_prefix"` // remove the path_prefix before sending the request to the backend
+	Rewrite     string            `json:"rewrite" toml:"rewrite"`           // replaces the path_prefix, or the path_regex match ($1 for groups)
+	pathRegex   *regexp.Regexp
+	headers     map[string]*regexp.Regexp
+	cookies     map[string]*regexp.Regexp
+	query       map[string]*regexp.Regexp
+}
+
+// routeContextKey is the context key of the route of a request
+type routeContextKey struct{}
+
+// compileRouteRegexes compiles the regexes of a route condition
+func compileRouteRegexes(condition string, matches map[string]string) (map[string]*regexp.Regexp, error) {
+	regexes := make(map[string]*regexp.Regexp)
+	for key, match := range matches {
+		regex, err := regexp.Compile(match)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s regex for %s: %s", condition, key, err)
+		}
+		regexes[key] = regex
+	}
+
+	return regexes, nil
+}
+
+// compile compiles the regexes of the route
+func (r *Route) compile() (err error) {
+	if r.PathRegex != "" {
+		if r.pathRegex, err = regexp.Compile(r.PathRegex); err != nil {
+			return fmt.Errorf("invalid path_regex: %s", err)
+		}
+	}
+
+	if r.StripPrefix && r.PathPrefix == "" {
+		return fmt.Errorf("strip_prefix requires a path_prefix")
+	}
+
+	if r.headers, err = compileRouteRegexes("header", r.Headers); err != nil {
+		return err
+	}
+
+	if r.cookies, err = compileRouteRegexes("cookie", r.Cookies); err != nil {
+		return err
+	}
+
+	r.query, err = compileRouteRegexes("query", r.Query)
+	return err
+}
+
+// Validate checks the conditions of the route
+func (r Route) Validate() error {
+	return r.compile()
+}
+
+// match returns true if the request matches all conditions of the route
+func (r *Route) match(req *http.Request) bool {
+	if r.PathPrefix != "" && !strings.HasPrefix(req.URL.Path, r.PathPrefix) {
+		return false
+	}
+
+	if r.pathRegex != nil && !r.pathRegex.MatchString(req.URL.Path) {
+		return false
+	}
+
+	if len(r.Methods) > 0 {
+		matched := false
+		for _, method := range r.Methods {
+			if strings.EqualFold(method, req.Method) {
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			return false
+		}
+	}
+
+	for header, regex := range r.headers {
+		if !matchAnyValue(regex, req.Header[http.CanonicalHeaderKey(header)]) {
+			return false
+		}
+	}
+
+	for name, regex := range r.cookies {
+		cookie, err := req.Cookie(name)
+		if err != nil || !regex.MatchString(cookie.Value) {
+			return false
+		}
+	}
+
+	if len(r.query) > 0 {
+		query := req.URL.Query()
+		for key, regex := range r.query {
+			if !matchAnyValue(regex, query[key]) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// matchAnyValue returns true if the regex matches any of the values
+func matchAnyValue(regex *regexp.Regexp, values []string) bool {
+	for _, value := range values {
+		if regex.MatchString(value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// rewritePath strips or rewrites the path of the request for the backend
+func (r *Route) rewritePath(req *http.Request) {
+	path := req.URL.Path
+	switch {
+	case r.pathRegex != nil && r.Rewrite != "":
+		path = r.pathRegex.ReplaceAllString(path, r.Rewrite)
+
+	case r.PathPrefix != "" && (r.StripPrefix || r.Rewrite != ""):
+		path = r.Rewrite + strings.TrimPrefix(path, r.PathPrefix)
+
+	default:
+		return
+	}
+
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	req.URL.Path = path
+	req.URL.RawPath = ""
+}
+
+// SetRoutes sets the routes of the backend
+func (b *Backend) SetRoutes(routes []Route) error {
+	var compiled []*Route
+	for id, route := range routes {
+		r := route
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("route%d", id+1)
+		}
+
+		if err := r.compile(); err != nil {
+			return fmt.Errorf("route %s: %s", r.Name, err)
+		}
+		compiled = append(compiled, &r)
+	}
+
+	b.sync.Lock()
+	defer b.sync.Unlock()
+	b.Routes = compiled
+	return nil
+}
+
+// routes returns the routes of the backend
+func (b *Backend) routes() []*Route {
+	b.sync.RLock()
+	defer b.sync.RUnlock()
+	return b.Routes
+}
+
+// routeHit counts a request sent to the backend by a route
+func (b *Backend) routeHit(name string) {
+	b.sync.Lock()
+	defer b.sync.Unlock()
+	if b.routeHits == nil {
+		b.routeHits = make(map[string]int64)
+	}
+	b.routeHits[name]++
+}
+
+// RouteStatistics returns the number of requests sent to the backend by each of its routes
+func (b *Backend) RouteStatistics() map[string]int64 {
+	b.sync.RLock()
+	defer b.sync.RUnlock()
+	hits := make(map[string]int64)
+	for name, count := range b.routeHits {
+		hits[name] = count
+	}
+
+	return hits
+}
+
+// hostMatch returns how well the hostnames of a backend match the requested host
+// 2 for an exact match, 1 for the default backend and 0 if it does not match
+func hostMatch(hostnames []string, host string) (score int) {
+	for _, hostname := range hostnames {
+		switch {
+		case strings.EqualFold(hostname, host):
+			return 2
+		case strings.EqualFold(hostname, "default") && score < 1:
+			score = 1
+		}
+	}
+
+	return
+}
+
+// FindBackendByRequest searches for the backend of a request, by its host and the routes of the backends
+// of the backends matching the host best, the route with the highest priority matching the request wins
+// if no route matches, the backend without routes is used, or the backends matching the host less are tried
+// multiple backends without routes share the requests by their split weight
+func (l *Listener) FindBackendByRequest(req *http.Request) (string, *Backend, *Route) {
+	type candidate struct {
+		name    string
+		backend *Backend
+		route   *Route
+	}
+
+	host := strings.Split(req.Host, ":")[0]
+	for score := 2; score > 0; score-- {
+		var routed, plain []candidate
+		for name, backend := range l.Backends {
+			if hostMatch(backend.Hostname, host) != score {
+				continue
+			}
+
+			routes := backend.routes()
+			if len(routes) == 0 {
+				plain = append(plain, candidate{name: name, backend: backend})
+			}
+
+			for _, route := range routes {
+				routed = append(routed, candidate{name: name, backend: backend, route: route})
+			}
+		}
+
+		sort.SliceStable(routed, func(i, j int) bool {
+			if routed[i].route.Priority != routed[j].route.Priority {
+				return routed[i].route.Priority > routed[j].route.Priority
+			}
+			return routed[i].name < routed[j].name
+		})
+
+		for _, c := range routed {
+			if c.route.match(req) {
+				return c.name, c.backend, c.route
+			}
+		}
+
+		if len(plain) > 0 {
+			sort.Slice(plain, func(i, j int) bool { return plain[i].name < plain[j].name })
+			if len(plain) == 1 {
+				return plain[0].name, plain[0].backend, nil
+			}
+
+			names := make([]string, len(plain))
+			for i, c := range plain {
+				names[i] = c.name
+			}
+			name := l.splitBackend(req, names)
+			return name, l.Backends[name], nil
+		}
+	}
+
+	return "", nil, nil
+}
@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/schubergphilis/mercury/pkg/healthcheck"
+	"github.com/schubergphilis/mercury/pkg/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindBackendByRequest(t *testing.T) {
+	l := New("listener-id", "Listener", 999)
+	l.AddBackend("web-id", "web", "roundrobin", "http", []string{"www.example.com"}, 1, ErrorPage{}, ErrorPage{})
+	l.AddBackend("api-id", "api", "roundrobin", "http", []string{"www.example.com"}, 1, ErrorPage{}, ErrorPage{})
+	l.AddBackend("beta-id", "beta", "roundrobin", "http", []string{"www.example.com"}, 1, ErrorPage{}, ErrorPage{})
+	l.AddBackend("tenants-id", "tenants", "roundrobin", "http", []string{"www.example.org"}, 1, ErrorPage{}, ErrorPage{})
+	l.AddBackend("default-id", "default", "roundrobin", "http", []string{"default"}, 1, ErrorPage{}, ErrorPage{})
+
+	assert.Nil(t, l.Backends["api"].SetRoutes([]Route{
+		{Name: "api", Priority: 10, PathPrefix: "/api/", StripPrefix: true},
+		{Name: "v1", Priority: 20, PathRegex: "^/v1/(.*)$", Rewrite: "/api/v1/$1", Methods: []string{"GET"}},
+	}))
+	assert.Nil(t, l.Backends["beta"].SetRoutes([]Route{
+		{Name: "beta", Priority: 30, PathPrefix: "/api/", Headers: map[string]string{"X-Beta": "^(1|true)$"}},
+		{Name: "beta-cookie", Cookies: map[string]string{"beta": "^yes$"}},
+		{Name: "beta-query", Query: map[string]string{"beta": "^1$"}},
+	}))
+	assert.Nil(t, l.Backends["tenants"].SetRoutes([]Route{
+		{Name: "admin", PathPrefix: "/admin"},
+	}))
+
+	tests := []struct {
+		method  string
+		url     string
+		header  map[string]string
+		backend string
+		route   string
+		path    string
+	}{
+		{method: "GET", url: "http://www.example.com/", backend: "web"},
+		{method: "GET", url: "http://www.example.com/api/users", backend: "api", route: "api", path: "/users"},
+		{method: "GET", url: "http://www.example.com/v1/users", backend: "api", route: "v1", path: "/api/v1/users"},
+		{method: "POST", url: "http://www.example.com/v1/users", backend: "web"},
+		{method: "GET", url: "http://www.example.com/api/users", header: map[string]string{"X-Beta": "true"}, backend: "beta", route: "beta", path: "/api/users"},
+		{method: "GET", url: "http://www.example.com/", header: map[string]string{"Cookie": "beta=yes"}, backend: "beta", route: "beta-cookie", path: "/"},
+		{method: "GET", url: "http://www.example.com/?beta=1", backend: "beta", route: "beta-query", path: "/"},
+		{method: "GET", url: "http://www.example.org/admin/users", backend: "tenants", route: "admin", path: "/admin/users"},
+		{method: "GET", url: "http://www.example.org/", backend: "default"},
+		{method: "GET", url: "http://www.example.net/", backend: "default"},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.url, nil)
+		for key, value := range test.header {
+			req.Header.Set(key, value)
+		}
+
+		name, backend, route := l.FindBackendByRequest(req)
+		assert.Equal(t, test.backend, name, test.url)
+		assert.Equal(t, l.Backends[test.backend], backend, test.url)
+		if test.route == "" {
+			assert.Nil(t, route, test.url)
+			continue
+		}
+
+		if assert.NotNil(t, route, test.url) {
+			assert.Equal(t, test.route, route.Name, test.url)
+			route.rewritePath(req)
+			assert.Equal(t, test.path, req.URL.Path, test.url)
+		}
+	}
+}
+
+func TestRouteValidate(t *testing.T) {
+	assert.Nil(t, Route{PathRegex: "^/api/(.*)$", Headers: map[string]string{"X-Test": "."}}.Validate())
+	assert.NotNil(t, Route{PathRegex: "^/api/(.*$"}.Validate())
+	assert.NotNil(t, Route{Query: map[string]string{"id": "("}}.Validate())
+	assert.NotNil(t, Route{StripPrefix: true}.Validate())
+
+	b := NewBackend("id", "roundrobin", "http", []string{}, 1, ErrorPage{}, ErrorPage{})
+	assert.Nil(t, b.SetRoutes([]Route{{PathPrefix: "/"}}))
+	assert.Equal(t, "route1", b.routes()[0].Name)
+	b.routeHit("route1")
+	b.routeHit("route1")
+	assert.Equal(t, map[string]int64{"route1": 2}, b.RouteStatistics())
+
+	req, _ := http.NewRequest("GET", "http://www.example.com/", nil)
+	assert.True(t, b.routes()[0].match(req))
+}
+
+func TestRouteRewriteAfterACL(t *testing.T) {
+	logging.Configure("stdout", "error")
+	received := make(chan string, 10)
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.URL.Path
+	}))
+	defer node.Close()
+
+	nodeHost, nodePortStr, _ := net.SplitHostPort(node.Listener.Addr().String())
+	nodePort, _ := strconv.Atoi(nodePortStr)
+
+	l := New("listener-id", "Listener", 999)
+	l.HTTPProto = 1
+	l.AddBackend("api-id", "api", "roundrobin", "http", []string{"www.example.com"}, 999, ErrorPage{}, ErrorPage{})
+	backend := l.Backends["api"]
+	backend.AddBackendNode(NewBackendNode("node-id", nodeHost, "localhost", nodePort, 10, []string{}, 0, 0, healthcheck.Online))
+	assert.Nil(t, backend.SetRoutes([]Route{{Name: "api", PathPrefix: "/api/", StripPrefix: true}}))
+	backend.InboundACL = ACLS{{Action: "deny", URLPath: "^/api/admin", CIDRS: []string{"127.0.0.0/8"}}}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.Nil(t, err) {
+		return
+	}
+	l.socket = limitListenerConnections(listener.(*net.TCPListener), 10)
+	srv := &http.Server{Handler: l.NewHTTPProxy()}
+	go srv.Serve(l.socket)
+	defer srv.Close()
+	defer listener.Close()
+
+	get := func(path string) int {
+		req, _ := http.NewRequest("GET", "http://"+listener.Addr().String()+path, nil)
+		req.Host = "www.example.com"
+		res, err := http.DefaultClient.Do(req)
+		if !assert.Nil(t, err) {
+			return 0
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	// the acl matches the path of the client, not the stripped path of the backend
+	assert.Equal(t, http.StatusForbidden, get("/api/admin/users"))
+	assert.Equal(t, http.StatusOK, get("/api/users"))
+	assert.Equal(t, "/users", <-received)
+	assert.Len(t, received, 0)
+}
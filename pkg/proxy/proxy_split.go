@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+)
+
+// TrafficSplit is how requests are split over the backends serving the same hostnames
+type TrafficSplit struct {
+	Cookie         string `json:"cookie" toml:"cookie"`                   // cookie keeping a client on the backend it was sent to
+	CookieTTL      int    `json:"cookie_ttl" toml:"cookie_ttl"`           // seconds the sticky cookie is valid, 0 for a session cookie
+	OverrideHeader string `json:"override_header" toml:"override_header"` // header naming the backend to use
+	OverrideCookie string `json:"override_cookie" toml:"override_cookie"` // cookie naming the backend to use
+}
+
+// splitCookieContextKey is the context key of the backend a client is assigned to by the traffic split
+type splitCookieContextKey struct{}
+
+// SetTrafficSplit sets how requests are split over the backends of the listener
+func (l *Listener) SetTrafficSplit(s TrafficSplit) {
+	l.TrafficSplit = s
+}
+
+// SetSplitWeight sets the share of the requests the backend gets of the backends serving the same hostnames
+func (b *Backend) SetSplitWeight(weight int) {
+	b.sync.Lock()
+	defer b.sync.Unlock()
+	b.SplitWeight = weight
+}
+
+// splitWeight returns the split weight of the backend
+func (b *Backend) splitWeight() int {
+	b.sync.RLock()
+	defer b.sync.RUnlock()
+	return b.SplitWeight
+}
+
+// splitBackend selects one of the backends serving the same hostnames, names must be sorted
+// a backend named in the override header or cookie is always used, and otherwise the backend in the sticky cookie
+// new clients are assigned by the weight of the backends, if none has a weight the first backend is used
+func (l *Listener) splitBackend(req *http.Request, names []string) string {
+	weights := make(map[string]int)
+	total := 0
+	for _, name := range names {
+		if weight := l.Backends[name].splitWeight(); weight > 0 {
+			weights[name] = weight
+			total += weight
+		}
+	}
+
+	if total == 0 {
+		return names[0]
+	}
+
+	if l.TrafficSplit.OverrideHeader != "" {
+		if name := req.Header.Get(l.TrafficSplit.OverrideHeader); containsString(names, name) {
+			return name
+		}
+	}
+
+	if l.TrafficSplit.OverrideCookie != "" {
+		if cookie, err := req.Cookie(l.TrafficSplit.OverrideCookie); err == nil && containsString(names, cookie.Value) {
+			return cookie.Value
+		}
+	}
+
+	if l.TrafficSplit.Cookie != "" {
+		if cookie, err := req.Cookie(l.TrafficSplit.Cookie); err == nil && weights[cookie.Value] > 0 {
+			return cookie.Value
+		}
+	}
+
+	pick := rand.Intn(total)
+	for _, name := range names {
+		if pick < weights[name] {
+			return name
+		}
+		pick -= weights[name]
+	}
+
+	return names[0]
+}
+
+// splitAssign remembers the backend of a request in its context, if the client is to get a new sticky cookie for it
+func (l *Listener) splitAssign(req *http.Request, backendname string, backend *Backend) {
+	if l.TrafficSplit.Cookie == "" || backend.splitWeight() == 0 {
+		return
+	}
+
+	if cookie, err := req.Cookie(l.TrafficSplit.Cookie); err == nil && cookie.Value == backendname {
+		return
+	}
+
+	*req = *req.WithContext(context.WithValue(req.Context(), splitCookieContextKey{}, backendname))
+}
+
+// setSplitCookie sets the sticky cookie on the reply, if the client was assigned to a backend
+func (l *Listener) setSplitCookie(res *http.Response) {
+	if res.Request == nil {
+		return
+	}
+
+	backendname, ok := res.Request.Context().Value(splitCookieContextKey{}).(string)
+	if !ok {
+		return
+	}
+
+	cookie := &http.Cookie{
+		Name:     l.TrafficSplit.Cookie,
+		Value:    backendname,
+		Path:     "/",
+		MaxAge:   l.TrafficSplit.CookieTTL,
+		HttpOnly: true,
+		Secure:   res.Request.TLS != nil,
+	}
+	res.Header.Add("Set-Cookie", cookie.String())
+}
+
+// containsString returns true if value is one of values
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
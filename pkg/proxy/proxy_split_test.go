@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newSplitListener() *Listener {
+	l := New("listener-id", "Listener", 999)
+	l.AddBackend("stable-id", "stable", "roundrobin", "http", []string{"www.example.com"}, 1, ErrorPage{}, ErrorPage{})
+	l.AddBackend("canary-id", "canary", "roundrobin", "http", []string{"www.example.com"}, 1, ErrorPage{}, ErrorPage{})
+	l.AddBackend("old-id", "old", "roundrobin", "http", []string{"www.example.com"}, 1, ErrorPage{}, ErrorPage{})
+	l.SetTrafficSplit(TrafficSplit{Cookie: "mercsplit", CookieTTL: 3600, OverrideHeader: "X-Mercury-Backend", OverrideCookie: "mercbackend"})
+	return l
+}
+
+func TestSplitBackend(t *testing.T) {
+	l := newSplitListener()
+
+	// without weights the first backend is used
+	req := httptest.NewRequest("GET", "http://www.example.com/", nil)
+	name, _, _ := l.FindBackendByRequest(req)
+	assert.Equal(t, "canary", name)
+
+	l.Backends["stable"].SetSplitWeight(90)
+	l.Backends["canary"].SetSplitWeight(10)
+	hits := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		name, _, _ := l.FindBackendByRequest(httptest.NewRequest("GET", "http://www.example.com/", nil))
+		hits[name]++
+	}
+	assert.Equal(t, 0, hits["old"])
+	assert.True(t, hits["stable"] > 800, "stable: %d", hits["stable"])
+	assert.True(t, hits["canary"] > 50, "canary: %d", hits["canary"])
+
+	tests := []struct {
+		header  map[string]string
+		backend string
+	}{
+		{header: map[string]string{"Cookie": "mercsplit=canary"}, backend: "canary"},
+		{header: map[string]string{"X-Mercury-Backend": "old"}, backend: "old"},
+		{header: map[string]string{"Cookie": "mercbackend=old; mercsplit=canary"}, backend: "old"},
+	}
+
+	for _, test := range tests {
+		for i := 0; i < 10; i++ {
+			req := httptest.NewRequest("GET", "http://www.example.com/", nil)
+			for key, value := range test.header {
+				req.Header.Set(key, value)
+			}
+			name, _, _ := l.FindBackendByRequest(req)
+			assert.Equal(t, test.backend, name, test.header)
+		}
+	}
+
+	// a sticky cookie of a backend without weight is ignored
+	l.Backends["canary"].SetSplitWeight(0)
+	req = httptest.NewRequest("GET", "http://www.example.com/", nil)
+	req.Header.Set("Cookie", "mercsplit=canary")
+	name, _, _ = l.FindBackendByRequest(req)
+	assert.Equal(t, "stable", name)
+}
+
+func TestSplitCookie(t *testing.T) {
+	l := newSplitListener()
+	l.Backends["stable"].SetSplitWeight(50)
+	l.Backends["canary"].SetSplitWeight(50)
+
+	// new clients get a cookie
+	req := httptest.NewRequest("GET", "http://www.example.com/", nil)
+	l.splitAssign(req, "stable", l.Backends["stable"])
+	res := &http.Response{Header: http.Header{}, Request: req}
+	l.setSplitCookie(res)
+	assert.Equal(t, "mercsplit=stable; Path=/; Max-Age=3600; HttpOnly", res.Header.Get("Set-Cookie"))
+
+	// clients with a cookie keep it
+	req = httptest.NewRequest("GET", "http://www.example.com/", nil)
+	req.Header.Set("Cookie", "mercsplit=stable")
+	l.splitAssign(req, "stable", l.Backends["stable"])
+	assert.Nil(t, req.Context().Value(splitCookieContextKey{}))
+
+	// backends without weight do not set a cookie
+	req = httptest.NewRequest("GET", "http://www.example.com/", nil)
+	l.splitAssign(req, "old", l.Backends["old"])
+	assert.Nil(t, req.Context().Value(splitCookieContextKey{}))
+
+	res = &http.Response{Header: http.Header{}, Request: req.WithContext(context.Background())}
+	l.setSplitCookie(res)
+	assert.Equal(t, "", res.Header.Get("Set-Cookie"))
+}
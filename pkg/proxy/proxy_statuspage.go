@@ -0,0 +1,330 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/schubergphilis/mercury/pkg/logging"
+)
+
+// content types of status pages, in order of preference if the client accepts any of them
+const (
+	contentTypeHTML = "text/html"
+	contentTypeJSON = "application/json"
+	contentTypeText = "text/plain"
+)
+
+var statusPageTypes = []string{contentTypeHTML, contentTypeJSON, contentTypeText}
+
+// StatusPage contains the template files of the page to show for a status code or range, per content type
+type StatusPage struct {
+	HTML string `json:"html" toml:"html"` // template file for clients accepting html
+	JSON string `json:"json" toml:"json"` // template file for clients accepting json
+	Text string `json:"text" toml:"text"` // template file for clients accepting plain text
+}
+
+// StatusPages are the status pages by status code (404), range (500-599) or class (5xx)
+type StatusPages map[string]StatusPage
+
+// StatusPageData is the data available to the templates of status pages
+type StatusPageData struct {
+	StatusCode int       // status code of the reply
+	Status     string    // status message of the reply
+	RequestID  string    // id of the request
+	Pool       string    // name of the pool
+	Backend    string    // name of the backend, if the request matched one
+	ClientIP   string    // ip of the client
+	Host       string    // requested hostname
+	Path       string    // requested path
+	Time       time.Time // time of the reply
+}
+
+// statusTemplate is a parsed html or text template
+type statusTemplate interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+// statusPage is a status page with its parsed templates
+type statusPage struct {
+	from      int
+	to        int
+	templates map[string]statusTemplate // by content type
+}
+
+// statusPages are the parsed status pages of a listener or backend, which can be replaced while in use
+type statusPages struct {
+	sync.RWMutex
+	pages []statusPage
+}
+
+// statusTemplateFuncs are the functions available to the templates of status pages
+var statusTemplateFuncs = map[string]interface{}{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// Validate checks the status codes of the status pages, and parses their templates
+func (p StatusPages) Validate() error {
+	_, err := p.parse()
+	return err
+}
+
+// parse returns the status pages with their parsed templates
+func (p StatusPages) parse() ([]statusPage, error) {
+	var pages []statusPage
+	for key, page := range p {
+		from, to, err := parseStatusRange(key)
+		if err != nil {
+			return nil, err
+		}
+
+		parsed := statusPage{from: from, to: to, templates: make(map[string]statusTemplate)}
+		for contentType, file := range map[string]string{contentTypeHTML: page.HTML, contentTypeJSON: page.JSON, contentTypeText: page.Text} {
+			if file == "" {
+				continue
+			}
+
+			if parsed.templates[contentType], err = parseStatusTemplate(contentType, file); err != nil {
+				return nil, fmt.Errorf("status page %s: %s", key, err)
+			}
+		}
+
+		if len(parsed.templates) == 0 {
+			return nil, fmt.Errorf("status page %s has no html, json or text template", key)
+		}
+
+		pages = append(pages, parsed)
+	}
+
+	return pages, nil
+}
+
+// parseStatusTemplate parses a template file, html templates escape their data
+func parseStatusTemplate(contentType, file string) (statusTemplate, error) {
+	if contentType == contentTypeHTML {
+		return htmltemplate.New(filepath.Base(file)).Funcs(statusTemplateFuncs).ParseFiles(file)
+	}
+
+	return template.New(filepath.Base(file)).Funcs(statusTemplateFuncs).ParseFiles(file)
+}
+
+// parseStatusRange returns the status codes of a status page key: a status code, a range (500-599) or a class (5xx)
+func parseStatusRange(key string) (int, int, error) {
+	var from, to int
+	var err error
+	switch {
+	case len(key) == 3 && strings.HasSuffix(strings.ToLower(key), "xx"):
+		from, err = strconv.Atoi(key[:1])
+		from, to = from*100, from*100+99
+
+	case strings.Contains(key, "-"):
+		parts := strings.SplitN(key, "-", 2)
+		if from, err = strconv.Atoi(parts[0]); err == nil {
+			to, err = strconv.Atoi(parts[1])
+		}
+
+	default:
+		from, err = strconv.Atoi(key)
+		to = from
+	}
+
+	if err != nil || from < 100 || to > 599 || from > to {
+		return 0, 0, fmt.Errorf("invalid status code or range for status page: %s", key)
+	}
+
+	return from, to, nil
+}
+
+// load replaces the status pages
+func (s *statusPages) load(p StatusPages) error {
+	pages, err := p.parse()
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	s.pages = pages
+	return nil
+}
+
+// find returns the status page with the narrowest range matching the status code
+func (s *statusPages) find(statusCode int) *statusPage {
+	s.RLock()
+	defer s.RUnlock()
+	var found *statusPage
+	for i, page := range s.pages {
+		if statusCode < page.from || statusCode > page.to {
+			continue
+		}
+
+		if found == nil || page.to-page.from < found.to-found.from {
+			found = &s.pages[i]
+		}
+	}
+
+	return found
+}
+
+// LoadStatusPages loads the status pages of the listener, replacing the existing ones
+func (l *Listener) LoadStatusPages(p StatusPages) error {
+	return l.statusPages.load(p)
+}
+
+// LoadStatusPages loads the status pages of the backend, replacing the existing ones
+func (b *Backend) LoadStatusPages(p StatusPages) error {
+	return b.statusPages.load(p)
+}
+
+// renderStatusPage replaces the body of a reply with the status page of the backend or listener for its status code
+// it returns false if there is no status page for the status code, or the client does not accept any of its types
+func (l *Listener) renderStatusPage(res *http.Response, backendname string) bool {
+	page := l.statusPages.find(res.StatusCode)
+	backend, ok := l.Backends[backendname]
+	if ok {
+		if backendPage := backend.statusPages.find(res.StatusCode); backendPage != nil {
+			page = backendPage
+		}
+	} else {
+		backendname = ""
+	}
+
+	if page == nil {
+		return false
+	}
+
+	var offers []string
+	for _, contentType := range statusPageTypes {
+		if _, ok := page.templates[contentType]; ok {
+			offers = append(offers, contentType)
+		}
+	}
+
+	contentType, ok := negotiateContentType(res.Request.Header.Get("Accept"), offers)
+	if !ok {
+		return false
+	}
+
+	data := StatusPageData{
+		StatusCode: res.StatusCode,
+		Status:     strings.TrimPrefix(res.Status, strconv.Itoa(res.StatusCode)+" "),
+		RequestID:  requestID(res.Request),
+		Pool:       l.Name,
+		Backend:    backendname,
+		ClientIP:   stringToClientIP(res.Request.RemoteAddr).IP,
+		Host:       res.Request.Host,
+		Path:       res.Request.URL.Path,
+		Time:       time.Now(),
+	}
+
+	body := &bytes.Buffer{}
+	if err := page.templates[contentType].Execute(body, data); err != nil {
+		logging.For("proxy/statuspage").WithField("pool", l.Name).WithField("requestid", data.RequestID).WithField("backend", backendname).WithField("statuscode", res.StatusCode).WithError(err).Warn("Unable to render status page")
+		return false
+	}
+
+	if res.Body != nil {
+		res.Body.Close()
+	}
+
+	setStatusPageBody(res, contentType, body.Bytes())
+	return true
+}
+
+// setStatusPageBody replaces the body of a reply with a status page that is not cached
+func setStatusPageBody(res *http.Response, contentType string, body []byte) {
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+	res.ContentLength = int64(len(body))
+	res.Header.Del("Content-Encoding")
+	res.Header.Del("Transfer-Encoding")
+	res.Header.Set("Content-Type", contentType+"; charset=utf-8")
+	res.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	res.Header.Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	res.Header.Set("Pragma", "no-cache")
+	res.Header.Set("Expires", "0")
+}
+
+// negotiateContentType returns the offered content type the client prefers based on its Accept header
+// each offer gets the quality of the most specific media type matching it, on equal quality the most specific match wins,
+// and then the first offer. Without an Accept header the first offer is returned, ok is false if none are accepted
+func negotiateContentType(accept string, offers []string) (string, bool) {
+	if len(offers) == 0 {
+		return "", false
+	}
+
+	if strings.TrimSpace(accept) == "" {
+		return offers[0], true
+	}
+
+	type acceptRange struct {
+		mediaType string
+		q         float64
+	}
+
+	var ranges []acceptRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		ranges = append(ranges, acceptRange{mediaType: mediaType, q: q})
+	}
+
+	best := ""
+	bestQ := 0.0
+	bestSpecificity := -1
+	for _, offer := range offers {
+		q := 0.0
+		specificity := -1
+		for _, r := range ranges {
+			if s := mediaTypeSpecificity(r.mediaType, offer); s > specificity {
+				q = r.q
+				specificity = s
+			}
+		}
+
+		if q > bestQ || (q == bestQ && q > 0 && specificity > bestSpecificity) {
+			best = offer
+			bestQ = q
+			bestSpecificity = specificity
+		}
+	}
+
+	return best, best != ""
+}
+
+// mediaTypeSpecificity returns how specific a media type of an Accept header matches an offered type
+// 2 for an exact match, 1 for type/*, 0 for */* and -1 if it does not match
+func mediaTypeSpecificity(mediaType, offer string) int {
+	switch {
+	case mediaType == offer:
+		return 2
+	case strings.HasSuffix(mediaType, "/*") && strings.HasPrefix(offer, strings.TrimSuffix(mediaType, "*")):
+		return 1
+	case mediaType == "*/*":
+		return 0
+	}
+
+	return -1
+}
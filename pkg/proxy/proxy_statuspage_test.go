@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateContentType(t *testing.T) {
+	tests := map[string]string{
+		"":                                "text/html",
+		"*/*":                             "text/html",
+		"application/json":                "application/json",
+		"application/json, */*":           "application/json",
+		"*/*;q=0.8, text/plain":           "text/plain",
+		"text/*;q=0.5, application/json":  "application/json",
+		"text/html;q=0.1, text/plain;q=1": "text/plain",
+		"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8": "text/html",
+	}
+
+	for accept, expect := range tests {
+		contentType, ok := negotiateContentType(accept, statusPageTypes)
+		assert.True(t, ok, accept)
+		assert.Equal(t, expect, contentType, accept)
+	}
+
+	_, ok := negotiateContentType("image/png", statusPageTypes)
+	assert.False(t, ok)
+	_, ok = negotiateContentType("application/json;q=0", []string{"application/json"})
+	assert.False(t, ok)
+}
+
+func TestStatusPages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "statuspages")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name, content string) string {
+		file := filepath.Join(dir, name)
+		assert.Nil(t, ioutil.WriteFile(file, []byte(content), 0644))
+		return file
+	}
+
+	html := write("5xx.html", "<h1>{{.StatusCode}} {{.Status}}</h1>{{.Pool}}/{{.Backend}} {{.RequestID}} {{.Path}}")
+	json := write("5xx.json", `{"status":{{.StatusCode}},"message":{{json .Status}}}`)
+	notFound := write("404.txt", "{{.Host}} not found")
+
+	assert.NotNil(t, StatusPages{"6xx": {HTML: html}}.Validate())
+	assert.NotNil(t, StatusPages{"599-500": {HTML: html}}.Validate())
+	assert.NotNil(t, StatusPages{"404": {}}.Validate())
+	assert.NotNil(t, StatusPages{"404": {HTML: filepath.Join(dir, "missing.html")}}.Validate())
+	assert.NotNil(t, StatusPages{"404": {HTML: write("broken.html", "{{.Status")}}.Validate())
+
+	l := New("listener-id", "Listener", 999)
+	l.AddBackend("web-id", "web", "roundrobin", "http", []string{"www.example.com"}, 1, ErrorPage{}, ErrorPage{})
+	assert.Nil(t, l.LoadStatusPages(StatusPages{"5xx": {HTML: html, JSON: json}, "404": {Text: notFound}}))
+	assert.Nil(t, l.Backends["web"].LoadStatusPages(StatusPages{"503": {JSON: json}}))
+
+	reply := func(statusCode int, status, accept, backend string) *http.Response {
+		req := httptest.NewRequest("GET", "http://www.example.com/path?q=1", nil)
+		req.Header.Set("Accept", accept)
+		req.Header.Set("X-Request-Id", "abc")
+		res := &http.Response{StatusCode: statusCode, Status: status, Header: http.Header{}, Body: http.NoBody, Request: req}
+		if !l.renderStatusPage(res, backend) {
+			return nil
+		}
+
+		return res
+	}
+
+	body := func(res *http.Response) string {
+		b, _ := ioutil.ReadAll(res.Body)
+		return string(b)
+	}
+
+	// the listener page is rendered with the data of the request, html is escaped
+	res := reply(502, "502 Bad <Gateway>", "text/html", "web")
+	if assert.NotNil(t, res) {
+		expect := "<h1>502 Bad &lt;Gateway&gt;</h1>Listener/web abc /path"
+		assert.Equal(t, expect, body(res))
+		assert.Equal(t, "text/html; charset=utf-8", res.Header.Get("Content-Type"))
+		assert.Equal(t, int64(len(expect)), res.ContentLength)
+	}
+
+	// the backend page wins over the listener page
+	res = reply(503, "Service Unavailable - no backend available", "*/*", "web")
+	if assert.NotNil(t, res) {
+		assert.Equal(t, `{"status":503,"message":"Service Unavailable - no backend available"}`, body(res))
+		assert.Equal(t, "application/json; charset=utf-8", res.Header.Get("Content-Type"))
+	}
+
+	// unknown backends only get the listener page
+	res = reply(503, "Service Unavailable", "text/html", "unknown")
+	if assert.NotNil(t, res) {
+		assert.Equal(t, "<h1>503 Service Unavailable</h1>Listener/ abc /path", body(res))
+	}
+
+	res = reply(404, "404 Not Found", "", "web")
+	if assert.NotNil(t, res) {
+		assert.Equal(t, "www.example.com not found", body(res))
+	}
+
+	// no page for the status code, or the client accepts none of the templates
+	assert.Nil(t, reply(403, "Forbidden", "text/html", "web"))
+	assert.Nil(t, reply(404, "Not Found", "application/json", "web"))
+
+	// reloading replaces the pages
+	assert.Nil(t, l.LoadStatusPages(StatusPages{}))
+	assert.Nil(t, reply(502, "Bad Gateway", "text/html", "web"))
+}
+
+func TestCustomStatusPageContentType(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://www.example.com/", nil)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Request-Id", "abc")
+	res := customStatusPage(503, "Service Unavailable", req)
+	b, _ := ioutil.ReadAll(res.Body)
+	assert.Equal(t, "application/json; charset=utf-8", res.Header.Get("Content-Type"))
+	assert.Contains(t, string(b), `"message":"Service Unavailable","request_id":"abc","status":503`)
+
+	req.Header.Set("Accept", "text/plain")
+	res = customStatusPage(503, "Service Unavailable", req)
+	b, _ = ioutil.ReadAll(res.Body)
+	assert.Equal(t, "503 Service Unavailable\nRequest ID: abc\n", string(b))
+
+	req.Header.Set("Accept", "image/png")
+	res = customStatusPage(503, "Service Unavailable", req)
+	b, _ = ioutil.ReadAll(res.Body)
+	assert.Equal(t, "text/html; charset=utf-8", res.Header.Get("Content-Type"))
+	assert.Contains(t, string(b), "- Request ID: abc</body>")
+	assert.Equal(t, "no-cache, no-store, must-revalidate", res.Header.Get("Cache-Control"))
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"strings"
 	"time"
 
 	"github.com/schubergphilis/mercury/pkg/healthcheck"
@@ -62,7 +63,6 @@ func (l *Listener) Handler(client net.Conn) {
 	l.updateClients()
 	defer l.updateClients()
 
-	// for TCP we only accept 1 backend, so return the first (any only) entry
 	backend, err := l.GetBackend()
 	if err != nil {
 		log.WithField("connecttime", 0).WithField("transfertime", 0).WithError(err).Error("Forwarding TCP aborted")
@@ -70,6 +70,20 @@ func (l *Listener) Handler(client net.Conn) {
 		return
 	}
 
+	// with backends for specific hostnames, route TLS clients on the server name they request, without terminating TLS
+	// clients of other listeners are not read, so protocols in which the server speaks first are not delayed
+	if l.routesServerNames() {
+		var serverName, backendname string
+		serverName, client = peekServerName(client, sniPeekTimeout)
+		backendname, backend = l.FindBackendByServerName(serverName)
+		log = log.WithField("servername", serverName).WithField("backend", backendname)
+		if backend == nil {
+			log.WithField("available", strings.Join(l.FindAllHostNames(), ", ")).WithField("connecttime", 0).WithField("transfertime", 0).Error("Forwarding TCP aborted, unable to find backend for requested server name")
+			client.Close()
+			return
+		}
+	}
+
 	// ACL
 	aclAllows := backend.InboundACL.CountActions("allow")
 	aclDenies := backend.InboundACL.CountActions("deny")
@@ -127,27 +141,36 @@ func (l *Listener) Handler(client net.Conn) {
 		IP: localAddr.IP,
 	}
 
-	// Custom dialer with timeouts
-	dialer := &net.Dialer{
-		LocalAddr: &localTCPAddr,
-		Timeout:   60 * time.Second,
-		//Deadline:  time.Now().Add(60 * time.Second),
-		DualStack: true,
-	}
+	// Custom dialer with the timeouts of the backend
+	timeouts := backend.timeouts()
+	dialer := timeouts.dialer(&localTCPAddr)
 
 	remote, err := dialer.Dial("tcp", fmt.Sprintf("%s:%d", node.IP, node.Port))
 	if err != nil {
-		clog.WithField("connecttime", 0).WithField("transfertime", 0).WithError(err).Error("Forwarding TCP aborted")
+		clog.WithField("connecttime", 0).WithField("transfertime", 0).WithField("timeout", isTimeout(err)).WithError(err).Error("Forwarding TCP aborted")
 		client.Close()
 		return
 	}
 
+	// track the connection, so it can be closed when the node is drained
+	remote = node.TrackConnection(remote)
+
+	// close the connections when the node does not reply in time, or either side is idle for too long
+	var activity int64
+	remoteTimeout := newTimeoutConn(remote, timeouts, true, &activity)
+	clientTimeout := newTimeoutConn(client, timeouts, false, &activity)
+
 	connecttime := time.Since(starttime)
 	node.Statistics.ClientsConnectsAdd(1)
 	node.Statistics.ClientsConnectedAdd(1)
 
 	// do the copy of data
-	in, out, firstByte := netPipe(client, remote)
+	in, out, firstByte := netPipe(clientTimeout, remoteTimeout)
+	if remoteTimeout.timeout != "" {
+		clog = clog.WithField("timeout", remoteTimeout.timeout)
+	} else if clientTimeout.timeout != "" {
+		clog = clog.WithField("timeout", "client_"+clientTimeout.timeout)
+	}
 
 	// only add first byte if its non nil
 	if firstByte != nil {
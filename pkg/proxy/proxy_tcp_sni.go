@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// sniPeekTimeout is the time a client has to send its TLS ClientHello
+const sniPeekTimeout = 10 * time.Second
+
+var errSNIFound = errors.New("client hello received")
+
+// sniConn is a read only connection, used to read the ClientHello of a client without replying
+type sniConn struct {
+	net.Conn
+	r io.Reader
+}
+
+// Read reads from the client
+func (c sniConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// Write discards what the TLS server replies
+func (c sniConn) Write(p []byte) (int, error) {
+	return 0, io.EOF
+}
+
+// peekedConn is a connection from which data was read, and that returns this data again before reading further
+type peekedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+// Read reads the peeked data, and then from the connection
+func (c *peekedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// peekServerName reads the TLS ClientHello of a client, and returns the server name it requested
+// the returned connection replays the ClientHello, so it can be passed to the backend as is
+// a client that does not start a TLS handshake within the timeout has no server name
+func peekServerName(client net.Conn, timeout time.Duration) (string, net.Conn) {
+	peeked := &bytes.Buffer{}
+	var serverName string
+
+	client.SetReadDeadline(time.Now().Add(timeout))
+	tls.Server(sniConn{Conn: client, r: io.TeeReader(client, peeked)}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName = hello.ServerName
+			return nil, errSNIFound
+		},
+	}).Handshake()
+	client.SetReadDeadline(time.Time{})
+
+	return serverName, &peekedConn{Conn: client, r: io.MultiReader(peeked, client)}
+}
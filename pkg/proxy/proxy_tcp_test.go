@@ -1,12 +1,17 @@
 package proxy
 
 import (
+	"crypto/tls"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/schubergphilis/mercury/pkg/healthcheck"
+	"github.com/schubergphilis/mercury/pkg/logging"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -53,3 +58,142 @@ func TestTCPProxyCopy(t *testing.T) {
 	log.Printf("wait group finished\n")
 
 }
+
+func TestTCPProxySNI(t *testing.T) {
+	logging.Configure("stdout", "error")
+
+	newProxy := New("UUIDP2", "tcpSNIProxy", 10)
+	newProxy.SetListener("tcp", "", "127.0.0.1", 32325, 10, &tls.Config{}, 10, 10, 2, "yes")
+	backends := map[string][]string{
+		"exact":    {"a.example.com"},
+		"wildcard": {"*.example.org"},
+		"default":  {"default"},
+	}
+	for name, hostnames := range backends {
+		server, err := net.Listen("tcp", "127.0.0.1:0")
+		if !assert.Nil(t, err) {
+			return
+		}
+		defer server.Close()
+		go sniDummyServer(server, name)
+
+		port := server.Addr().(*net.TCPAddr).Port
+		newProxy.AddBackend("UUID"+name, name, "leastconnected", "tcp", hostnames, 10, ErrorPage{}, ErrorPage{})
+		newProxy.Backends[name].AddBackendNode(NewBackendNode("UUIDN"+name, "127.0.0.1", "127.0.0.1", port, 10, []string{}, 0, 0, healthcheck.Online))
+	}
+	go newProxy.Start()
+	defer newProxy.Stop()
+
+	time.Sleep(100 * time.Millisecond) // give server time to start
+
+	tests := map[string]string{
+		"a.example.com":   "exact",
+		"b.example.org":   "wildcard",
+		"a.b.example.org": "default",
+		"www.example.net": "default",
+	}
+	for serverName, expect := range tests {
+		received, err := sniDummyClient("127.0.0.1:32325", clientHello(serverName))
+		assert.Nil(t, err)
+		assert.Equal(t, expect, received, serverName)
+	}
+
+	// clients not using TLS go to the default backend
+	received, err := sniDummyClient("127.0.0.1:32325", []byte("plain text"))
+	assert.Nil(t, err)
+	assert.Equal(t, "default", received)
+
+	// wildcards only match server names, not the hostnames of http requests
+	name, _ := newProxy.FindBackendByHost("b.example.org")
+	assert.Equal(t, "default", name)
+	name, _ = newProxy.FindBackendByServerName("b.example.org")
+	assert.Equal(t, "wildcard", name)
+}
+
+func TestTCPProxyServerFirst(t *testing.T) {
+	logging.Configure("stdout", "error")
+
+	// backends without hostnames do not wait for the client to speak first
+	newProxy := New("UUIDP3", "tcpServerFirstProxy", 10)
+	newProxy.SetListener("tcp", "", "127.0.0.1", 32326, 10, &tls.Config{}, 10, 10, 2, "yes")
+	for _, name := range []string{"first", "second"} {
+		server, err := net.Listen("tcp", "127.0.0.1:0")
+		if !assert.Nil(t, err) {
+			return
+		}
+		defer server.Close()
+		go func(l net.Listener) {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				conn.Write([]byte("220 ready\r\n"))
+				conn.Close()
+			}
+		}(server)
+
+		port := server.Addr().(*net.TCPAddr).Port
+		newProxy.AddBackend("UUID"+name, name, "leastconnected", "tcp", []string{"default"}, 10, ErrorPage{}, ErrorPage{})
+		newProxy.Backends[name].AddBackendNode(NewBackendNode("UUIDN"+name, "127.0.0.1", "127.0.0.1", port, 10, []string{}, 0, 0, healthcheck.Online))
+	}
+	assert.False(t, newProxy.routesServerNames())
+	go newProxy.Start()
+	defer newProxy.Stop()
+
+	time.Sleep(100 * time.Millisecond) // give server time to start
+
+	conn, err := net.Dial("tcp", "127.0.0.1:32326")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	greeting, err := ioutil.ReadAll(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, "220 ready\r\n", string(greeting))
+}
+
+// clientHello returns the TLS ClientHello of a client requesting serverName
+func clientHello(serverName string) []byte {
+	client, server := net.Pipe()
+	defer server.Close()
+	go tls.Client(client, &tls.Config{ServerName: serverName, InsecureSkipVerify: true}).Handshake()
+
+	buf := make([]byte, 4096)
+	n, _ := server.Read(buf)
+	return buf[:n]
+}
+
+// sniDummyClient sends data, and returns the reply
+func sniDummyClient(addr string, data []byte) (string, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write(data); err != nil {
+		return "", err
+	}
+
+	reply, err := ioutil.ReadAll(conn)
+	return string(reply), err
+}
+
+// sniDummyServer replies with its name, if the client sent a ClientHello or plain text
+func sniDummyServer(l net.Listener, name string) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		buf := make([]byte, 4096)
+		n, _ := conn.Read(buf)
+		if n > 0 && (buf[0] == 0x16 || string(buf[:n]) == "plain text") {
+			conn.Write([]byte(name))
+		}
+		conn.Close()
+	}
+}
@@ -0,0 +1,261 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// BackendTimeouts are the timeouts and connection pooling of the connections to the nodes of a backend
+type BackendTimeouts struct {
+	Connect            int `json:"connect_timeout" toml:"connect_timeout"`           // seconds to wait for a connection to a node
+	FirstByte          int `json:"first_byte_timeout" toml:"first_byte_timeout"`     // seconds to wait for the reply of a node, 0 for no limit
+	Idle               int `json:"idle_timeout" toml:"idle_timeout"`                 // seconds a connection without traffic is kept, 0 for no limit
+	Total              int `json:"total_timeout" toml:"total_timeout"`               // seconds a request or tcp connection may take in total, 0 for no limit
+	MaxIdleConnections int `json:"max_idle_connections" toml:"max_idle_connections"` // http connections per node kept for reuse
+	MaxConnections     int `json:"max_connections" toml:"max_connections"`           // http connections per node, 0 for no limit
+	KeepAlive          int `json:"tcp_keepalive" toml:"tcp_keepalive"`               // seconds between tcp keepalives, -1 to disable
+}
+
+// defaultConnectTimeout is the connect timeout of backends without one
+const defaultConnectTimeout = 10 * time.Second
+
+// Validate checks the timeouts
+func (t BackendTimeouts) Validate() error {
+	if t.Connect < 0 || t.FirstByte < 0 || t.Idle < 0 || t.Total < 0 || t.MaxIdleConnections < 0 || t.MaxConnections < 0 {
+		return fmt.Errorf("timeouts and connections can not be negative")
+	}
+
+	if t.KeepAlive < -1 {
+		return fmt.Errorf("tcp_keepalive must be -1 to disable, or 0 or more: %d", t.KeepAlive)
+	}
+
+	return nil
+}
+
+// SetTimeouts sets the timeouts of the connections to the nodes of the backend
+func (b *Backend) SetTimeouts(t BackendTimeouts) {
+	b.sync.Lock()
+	defer b.sync.Unlock()
+	b.Timeouts = t
+}
+
+// timeouts returns the timeouts of the backend
+func (b *Backend) timeouts() BackendTimeouts {
+	b.sync.RLock()
+	defer b.sync.RUnlock()
+	return b.Timeouts
+}
+
+// seconds returns a number of seconds as duration
+func seconds(s int) time.Duration {
+	return time.Duration(s) * time.Second
+}
+
+// dialer returns a dialer using the connect timeout and tcp keepalive
+func (t BackendTimeouts) dialer(localAddr net.Addr) *net.Dialer {
+	connect := seconds(t.Connect)
+	if connect <= 0 {
+		connect = defaultConnectTimeout
+	}
+
+	return &net.Dialer{
+		LocalAddr: localAddr,
+		Timeout:   connect,
+		KeepAlive: seconds(t.KeepAlive),
+		DualStack: true,
+	}
+}
+
+// newHTTPTransport returns a transport to the nodes of a backend with the timeouts and connection pooling
+func newHTTPTransport(localAddr net.Addr, t BackendTimeouts, enableHTTP2 bool) (*http.Transport, error) {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+		},
+		DialContext:           trackingDialer(t.dialer(localAddr).DialContext),
+		TLSHandshakeTimeout:   10 * time.Second,
+		IdleConnTimeout:       seconds(t.Idle),
+		ResponseHeaderTimeout: seconds(t.FirstByte),
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   t.MaxIdleConnections,
+		MaxConnsPerHost:       t.MaxConnections,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+
+	// Websockets are not supported using HTTP/2, so if you use that, force HTTP/1.X
+	if enableHTTP2 {
+		if err := http2.ConfigureTransport(transport); err != nil {
+			return nil, err
+		}
+	}
+
+	return transport, nil
+}
+
+// backendTransport is the transport to the nodes of a backend, with the connect mode and timeouts it was created with
+type backendTransport struct {
+	connectMode string
+	timeouts    BackendTimeouts
+	transport   http.RoundTripper
+}
+
+// backendTransports contains the transports of the backends of a listener
+type backendTransports struct {
+	sync.Mutex
+	backends map[string]*backendTransport
+}
+
+// transport returns the transport to the nodes of a backend, which is recreated when its connect mode or timeouts change
+func (t *customTransport) transport(backendname string) http.RoundTripper {
+	backend, ok := t.Listener.Backends[backendname]
+	if !ok {
+		return t.Transport
+	}
+
+	timeouts := backend.timeouts()
+	connectMode := backend.ConnectMode
+	t.transports.Lock()
+	defer t.transports.Unlock()
+	if t.transports.backends == nil {
+		t.transports.backends = make(map[string]*backendTransport)
+	}
+
+	if existing, ok := t.transports.backends[backendname]; ok {
+		if existing.timeouts == timeouts && existing.connectMode == connectMode {
+			return existing.transport
+		}
+
+		if c, ok := existing.transport.(interface{ CloseIdleConnections() }); ok {
+			c.CloseIdleConnections()
+		}
+	}
+
+	transport, err := newBackendTransport(t.LocalAddr, connectMode, timeouts, t.Listener.HTTPProto != 1)
+	if err != nil {
+		return t.Transport
+	}
+
+	t.transports.backends[backendname] = &backendTransport{connectMode: connectMode, timeouts: timeouts, transport: transport}
+	return transport
+}
+
+// withTotalTimeout limits the time a request may take, including reading the reply
+// the returned cancel function must be called once the reply is read
+// upgraded connections such as websockets are not limited
+func withTotalTimeout(req *http.Request, total int) (*http.Request, context.CancelFunc) {
+	if total <= 0 || req.Header.Get("Upgrade") != "" {
+		return req, func() {}
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), seconds(total))
+	return req.WithContext(ctx), cancel
+}
+
+// cancelBody cancels the context of a request when the body of its reply is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close closes the body, and cancels the request
+func (b cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// isTimeout returns true if the error is caused by a timeout
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var nerr net.Error
+	return errors.As(err, &nerr) && nerr.Timeout()
+}
+
+// backendErrorPage returns the reply to a client for a request that failed at the backend
+// 504 if the backend did not reply in time, and 502 for all other errors
+func backendErrorPage(err error, req *http.Request) *http.Response {
+	if isTimeout(err) {
+		return customStatusPage(http.StatusGatewayTimeout, "Gateway Timeout - "+err.Error(), req)
+	}
+
+	return customStatusPage(http.StatusBadGateway, "Bad Gateway - "+err.Error(), req)
+}
+
+// timeoutConn is a connection to a node or client that applies the idle, first byte and total timeouts
+type timeoutConn struct {
+	net.Conn
+	idle      time.Duration
+	activity  *int64    // time of the last data read on either side of the proxied connection, in unix nanoseconds
+	firstByte time.Time // time the first byte must be read, zero if there is no limit
+	deadline  time.Time // time the connection must be finished, zero if there is no limit
+	timeout   string    // reason of the timeout if one occurred
+}
+
+// newTimeoutConn applies the timeouts on a connection, firstByte is only used for the connection to the node
+// connections sharing activity are only idle if there is no data on either of them
+func newTimeoutConn(conn net.Conn, t BackendTimeouts, firstByte bool, activity *int64) *timeoutConn {
+	now := time.Now()
+	atomic.StoreInt64(activity, now.UnixNano())
+	c := &timeoutConn{Conn: conn, idle: seconds(t.Idle), activity: activity}
+	if firstByte && t.FirstByte > 0 {
+		c.firstByte = now.Add(seconds(t.FirstByte))
+	}
+
+	if t.Total > 0 {
+		c.deadline = now.Add(seconds(t.Total))
+		conn.SetWriteDeadline(c.deadline)
+	}
+
+	return c
+}
+
+// Read reads from the connection, until the earliest of the timeouts
+func (c *timeoutConn) Read(p []byte) (int, error) {
+	for {
+		var deadline time.Time
+		reason := ""
+		set := func(t time.Time, r string) {
+			if !t.IsZero() && (deadline.IsZero() || t.Before(deadline)) {
+				deadline = t
+				reason = r
+			}
+		}
+
+		if c.idle > 0 {
+			set(time.Unix(0, atomic.LoadInt64(c.activity)).Add(c.idle), "idle")
+		}
+		set(c.firstByte, "first_byte")
+		set(c.deadline, "total")
+		c.Conn.SetReadDeadline(deadline)
+
+		n, err := c.Conn.Read(p)
+		if n > 0 {
+			c.firstByte = time.Time{}
+			atomic.StoreInt64(c.activity, time.Now().UnixNano())
+		}
+
+		if nerr, ok := err.(net.Error); ok && nerr.Timeout() && reason != "" {
+			// the other side of the connection received data in the mean time
+			if reason == "idle" && time.Since(time.Unix(0, atomic.LoadInt64(c.activity))) < c.idle {
+				continue
+			}
+			c.timeout = reason
+		}
+
+		return n, err
+	}
+}
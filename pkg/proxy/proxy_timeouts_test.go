@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackendTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(2 * time.Second)
+	}))
+	defer server.Close()
+
+	l := New("listener-id", "Listener", 999)
+	l.HTTPProto = 1
+	l.AddBackend("web-id", "web", "roundrobin", "http", []string{"www.example.com"}, 1, ErrorPage{}, ErrorPage{})
+	l.Backends["web"].SetTimeouts(BackendTimeouts{Connect: 1, FirstByte: 1})
+	transport := &customTransport{Transport: &http.Transport{}, Listener: l}
+
+	// the transport is reused until the timeouts change
+	web := transport.transport("web")
+	assert.True(t, web == transport.transport("web"))
+	assert.Equal(t, time.Second, web.(*http.Transport).ResponseHeaderTimeout)
+	assert.True(t, transport.Transport == transport.transport("unknown"))
+
+	// a node replying too late is a timeout
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	_, err := web.RoundTrip(req)
+	if assert.NotNil(t, err) {
+		assert.True(t, isTimeout(err))
+		assert.Equal(t, http.StatusGatewayTimeout, backendErrorPage(err, req).StatusCode)
+	}
+
+	l.Backends["web"].SetTimeouts(BackendTimeouts{Connect: 1})
+	assert.True(t, web != transport.transport("web"))
+
+	// a node refusing the connection is not
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	listener.Close()
+	req, _ = http.NewRequest("GET", "http://"+listener.Addr().String(), nil)
+	_, err = transport.transport("web").RoundTrip(req)
+	if assert.NotNil(t, err) {
+		assert.False(t, isTimeout(err))
+		assert.Equal(t, http.StatusBadGateway, backendErrorPage(err, req).StatusCode)
+	}
+
+	assert.NotNil(t, BackendTimeouts{Idle: -1}.Validate())
+	assert.NotNil(t, BackendTimeouts{KeepAlive: -2}.Validate())
+	assert.Nil(t, BackendTimeouts{KeepAlive: -1}.Validate())
+}
+
+func TestTimeoutConn(t *testing.T) {
+	buf := make([]byte, 10)
+
+	// the node does not send its first byte in time
+	remote, _ := net.Pipe()
+	var activity int64
+	conn := newTimeoutConn(remote, BackendTimeouts{FirstByte: 1, Idle: 5}, true, &activity)
+	_, err := conn.Read(buf)
+	assert.NotNil(t, err)
+	assert.Equal(t, "first_byte", conn.timeout)
+
+	// the client is idle, but the node is not
+	client, _ := net.Pipe()
+	remote, node := net.Pipe()
+	activity = 0
+	clientConn := newTimeoutConn(client, BackendTimeouts{Idle: 1}, false, &activity)
+	remoteConn := newTimeoutConn(remote, BackendTimeouts{Idle: 1}, true, &activity)
+	go func() {
+		for i := 0; i < 5; i++ {
+			node.Write([]byte("data"))
+			time.Sleep(300 * time.Millisecond)
+		}
+	}()
+	go func() {
+		for {
+			if _, err := remoteConn.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	start := time.Now()
+	_, err = clientConn.Read(buf)
+	assert.NotNil(t, err)
+	assert.Equal(t, "idle", clientConn.timeout)
+	assert.True(t, time.Since(start) > 2*time.Second, "timed out after %s", time.Since(start))
+}
@@ -0,0 +1,564 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// WAFConfig is the web application firewall of a pool
+type WAFConfig struct {
+	Mode             string   `json:"mode" toml:"mode"`                           // off, detect or block
+	AnomalyThreshold int      `json:"anomaly_threshold" toml:"anomaly_threshold"` // score at which a request is blocked
+	MaxBodySize      int      `json:"max_body_size" toml:"max_body_size"`         // bytes of the request body to inspect
+	Rules            []string `json:"rules" toml:"rules"`                         // SecRule lines
+	RuleFiles        []string `json:"rule_files" toml:"rule_files"`               // files containing SecRule lines
+}
+
+// WAF inspects requests using a subset of the ModSecurity rule language
+type WAF struct {
+	WAFConfig
+	rules []wafRule
+}
+
+// WAFMatch is a rule matching a request
+type WAFMatch struct {
+	ID       int    // id of the rule
+	Msg      string // message of the rule
+	Variable string // variable that matched
+	Value    string // value that matched, after transformations
+}
+
+// WAFResult is the result of inspecting a request
+type WAFResult struct {
+	Matches []WAFMatch
+	Score   int
+	Blocked bool
+}
+
+// wafRule is a parsed SecRule
+type wafRule struct {
+	id         int
+	msg        string
+	score      int
+	deny       bool
+	variables  []wafVariable
+	operator   string
+	argument   string
+	negate     bool
+	regex      *regexp.Regexp
+	phrases    []string
+	transforms []string
+}
+
+// wafVariable is a variable of a SecRule, with an optional key (e.g. ARGS:id)
+type wafVariable struct {
+	name string
+	key  string
+}
+
+// wafSeverity is the anomaly score of the severity of a rule
+var wafSeverity = map[string]int{
+	"CRITICAL": 5,
+	"ERROR":    4,
+	"WARNING":  3,
+	"NOTICE":   2,
+}
+
+var wafVariables = map[string]bool{
+	"REQUEST_LINE":          true,
+	"REQUEST_METHOD":        true,
+	"REQUEST_URI":           true,
+	"REQUEST_FILENAME":      true,
+	"QUERY_STRING":          true,
+	"REQUEST_HEADERS":       true,
+	"REQUEST_HEADERS_NAMES": true,
+	"REQUEST_COOKIES":       true,
+	"REQUEST_COOKIES_NAMES": true,
+	"ARGS":                  true,
+	"ARGS_NAMES":            true,
+	"REQUEST_BODY":          true,
+}
+
+var wafTransforms = map[string]bool{
+	"none":               true,
+	"lowercase":          true,
+	"urlDecode":          true,
+	"urlDecodeUni":       true,
+	"htmlEntityDecode":   true,
+	"compressWhitespace": true,
+	"removeWhitespace":   true,
+	"trim":               true,
+}
+
+var wafWhitespace = regexp.MustCompile(`\s+`)
+
+// Validate validates the waf settings and rules
+func (c WAFConfig) Validate() error {
+	switch c.Mode {
+	case "", "off", "detect", "block":
+	default:
+		return fmt.Errorf("unknown waf mode: %s", c.Mode)
+	}
+
+	_, err := NewWAF(c)
+	return err
+}
+
+// NewWAF returns the web application firewall, parsing its rules
+func NewWAF(c WAFConfig) (*WAF, error) {
+	if c.AnomalyThreshold < 1 {
+		c.AnomalyThreshold = 5
+	}
+
+	if c.MaxBodySize < 1 {
+		c.MaxBodySize = 131072
+	}
+
+	w := &WAF{WAFConfig: c}
+	lines := append([]string{}, c.Rules...)
+	for _, file := range c.RuleFiles {
+		fileLines, err := readWAFRuleFile(file)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, fileLines...)
+	}
+
+	ids := make(map[int]bool)
+	for _, line := range lines {
+		rule, err := parseWAFRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid waf rule %q: %s", line, err)
+		}
+
+		if ids[rule.id] {
+			return nil, fmt.Errorf("duplicate waf rule id: %d", rule.id)
+		}
+		ids[rule.id] = true
+		w.rules = append(w.rules, rule)
+	}
+
+	return w, nil
+}
+
+// readWAFRuleFile reads the rules of a file, joining continued lines and skipping comments
+func readWAFRuleFile(file string) (lines []string, err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read waf rules: %s", err)
+	}
+	defer f.Close()
+
+	var current string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if current == "" && (line == "" || strings.HasPrefix(line, "#")) {
+			continue
+		}
+
+		if strings.HasSuffix(line, "\\") {
+			current += strings.TrimSuffix(line, "\\") + " "
+			continue
+		}
+
+		lines = append(lines, current+line)
+		current = ""
+	}
+
+	if current != "" {
+		lines = append(lines, current)
+	}
+
+	return lines, scanner.Err()
+}
+
+// splitWAFRule splits a rule in its space separated parts, keeping quoted strings together
+func splitWAFRule(line string) (parts []string, err error) {
+	var part strings.Builder
+	quoted := false
+	inPart := false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\\' && quoted && i+1 < len(line) && line[i+1] == '"':
+			part.WriteByte('"')
+			i++
+
+		case c == '"':
+			quoted = !quoted
+			inPart = true
+
+		case (c == ' ' || c == '\t') && !quoted:
+			if inPart {
+				parts = append(parts, part.String())
+				part.Reset()
+				inPart = false
+			}
+
+		default:
+			part.WriteByte(c)
+			inPart = true
+		}
+	}
+
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+
+	if inPart {
+		parts = append(parts, part.String())
+	}
+
+	return parts, nil
+}
+
+// splitWAFActions splits the comma separated actions of a rule, keeping single quoted strings together
+func splitWAFActions(actions string) (result []string) {
+	var action strings.Builder
+	quoted := false
+	for _, c := range actions {
+		switch {
+		case c == '\'':
+			quoted = !quoted
+
+		case c == ',' && !quoted:
+			result = append(result, strings.TrimSpace(action.String()))
+			action.Reset()
+
+		default:
+			action.WriteRune(c)
+		}
+	}
+
+	return append(result, strings.TrimSpace(action.String()))
+}
+
+// parseWAFRule parses a rule in the format: SecRule VARIABLES "OPERATOR" "ACTIONS"
+func parseWAFRule(line string) (rule wafRule, err error) {
+	parts, err := splitWAFRule(line)
+	if err != nil {
+		return rule, err
+	}
+
+	if len(parts) != 4 || parts[0] != "SecRule" {
+		return rule, fmt.Errorf("expected: SecRule VARIABLES \"OPERATOR\" \"ACTIONS\"")
+	}
+
+	for _, v := range strings.Split(parts[1], "|") {
+		variable := wafVariable{name: v}
+		if i := strings.Index(v, ":"); i > 0 {
+			variable = wafVariable{name: v[:i], key: v[i+1:]}
+		}
+
+		if !wafVariables[variable.name] {
+			return rule, fmt.Errorf("unsupported variable: %s", variable.name)
+		}
+		rule.variables = append(rule.variables, variable)
+	}
+
+	operator := parts[2]
+	if strings.HasPrefix(operator, "!") {
+		rule.negate = true
+		operator = operator[1:]
+	}
+
+	rule.operator = "rx"
+	rule.argument = operator
+	if strings.HasPrefix(operator, "@") {
+		fields := strings.SplitN(operator[1:], " ", 2)
+		rule.operator = fields[0]
+		rule.argument = ""
+		if len(fields) > 1 {
+			rule.argument = fields[1]
+		}
+	}
+
+	switch rule.operator {
+	case "rx":
+		if rule.regex, err = regexp.Compile(rule.argument); err != nil {
+			return rule, err
+		}
+
+	case "pm":
+		rule.phrases = strings.Fields(strings.ToLower(rule.argument))
+
+	case "eq", "gt", "lt":
+		if _, err := strconv.Atoi(rule.argument); err != nil {
+			return rule, fmt.Errorf("operator @%s requires a number", rule.operator)
+		}
+
+	case "contains", "streq", "beginsWith", "endsWith":
+
+	default:
+		return rule, fmt.Errorf("unsupported operator: @%s", rule.operator)
+	}
+
+	rule.score = wafSeverity["CRITICAL"]
+	for _, action := range splitWAFActions(parts[3]) {
+		fields := strings.SplitN(action, ":", 2)
+		value := ""
+		if len(fields) > 1 {
+			value = fields[1]
+		}
+
+		switch fields[0] {
+		case "id":
+			if rule.id, err = strconv.Atoi(value); err != nil {
+				return rule, fmt.Errorf("invalid id: %s", value)
+			}
+
+		case "msg":
+			rule.msg = value
+
+		case "severity":
+			score, ok := wafSeverity[strings.ToUpper(value)]
+			if !ok {
+				return rule, fmt.Errorf("unknown severity: %s", value)
+			}
+			rule.score = score
+
+		case "t":
+			if !wafTransforms[value] {
+				return rule, fmt.Errorf("unsupported transformation: %s", value)
+			}
+
+			if value == "none" {
+				rule.transforms = nil
+				continue
+			}
+			rule.transforms = append(rule.transforms, value)
+
+		case "deny":
+			rule.deny = true
+
+		case "pass", "block", "log", "nolog", "phase", "rev", "tag", "ver", "capture", "":
+			// accepted for compatibility, the rule is evaluated the same
+
+		default:
+			return rule, fmt.Errorf("unsupported action: %s", fields[0])
+		}
+	}
+
+	if rule.id == 0 {
+		return rule, fmt.Errorf("rule requires an id")
+	}
+
+	return rule, nil
+}
+
+// wafRequest contains the variables of a request
+type wafRequest struct {
+	values map[string][]wafValue
+}
+
+// wafValue is the value of a variable with its key (e.g. the name of a header)
+type wafValue struct {
+	key   string
+	value string
+}
+
+// newWAFRequest collects the variables of a request, reading up to maxBody bytes of the body
+func newWAFRequest(req *http.Request, maxBody int) *wafRequest {
+	r := &wafRequest{values: make(map[string][]wafValue)}
+	add := func(name, key, value string) {
+		r.values[name] = append(r.values[name], wafValue{key: key, value: value})
+	}
+
+	uri := req.URL.RequestURI()
+	add("REQUEST_METHOD", "", req.Method)
+	add("REQUEST_URI", "", uri)
+	add("REQUEST_FILENAME", "", req.URL.Path)
+	add("QUERY_STRING", "", req.URL.RawQuery)
+	add("REQUEST_LINE", "", fmt.Sprintf("%s %s %s", req.Method, uri, req.Proto))
+
+	for key, values := range req.Header {
+		add("REQUEST_HEADERS_NAMES", key, key)
+		for _, value := range values {
+			add("REQUEST_HEADERS", key, value)
+		}
+	}
+
+	for _, cookie := range req.Cookies() {
+		add("REQUEST_COOKIES_NAMES", cookie.Name, cookie.Name)
+		add("REQUEST_COOKIES", cookie.Name, cookie.Value)
+	}
+
+	args, _ := url.ParseQuery(req.URL.RawQuery)
+	if req.Body != nil && req.Body != http.NoBody {
+		body, _ := ioutil.ReadAll(io.LimitReader(req.Body, int64(maxBody)))
+		// the body is passed on in full to the backend
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+		add("REQUEST_BODY", "", string(body))
+
+		if strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+			if form, err := url.ParseQuery(string(body)); err == nil {
+				for key, values := range form {
+					args[key] = append(args[key], values...)
+				}
+			}
+		}
+	}
+
+	for key, values := range args {
+		add("ARGS_NAMES", key, key)
+		for _, value := range values {
+			add("ARGS", key, value)
+		}
+	}
+
+	return r
+}
+
+// transform applies the transformations of a rule to a value
+func (rule wafRule) transform(value string) string {
+	for _, t := range rule.transforms {
+		switch t {
+		case "lowercase":
+			value = strings.ToLower(value)
+
+		case "urlDecode", "urlDecodeUni":
+			if decoded, err := url.QueryUnescape(value); err == nil {
+				value = decoded
+			}
+
+		case "htmlEntityDecode":
+			value = html.UnescapeString(value)
+
+		case "compressWhitespace":
+			value = wafWhitespace.ReplaceAllString(value, " ")
+
+		case "removeWhitespace":
+			value = wafWhitespace.ReplaceAllString(value, "")
+
+		case "trim":
+			value = strings.TrimSpace(value)
+		}
+	}
+
+	return value
+}
+
+// match applies the operator of a rule to a value
+func (rule wafRule) match(value string) bool {
+	var result bool
+	switch rule.operator {
+	case "rx":
+		result = rule.regex.MatchString(value)
+
+	case "pm":
+		lower := strings.ToLower(value)
+		for _, phrase := range rule.phrases {
+			if strings.Contains(lower, phrase) {
+				result = true
+				break
+			}
+		}
+
+	case "contains":
+		result = strings.Contains(value, rule.argument)
+
+	case "streq":
+		result = value == rule.argument
+
+	case "beginsWith":
+		result = strings.HasPrefix(value, rule.argument)
+
+	case "endsWith":
+		result = strings.HasSuffix(value, rule.argument)
+
+	case "eq", "gt", "lt":
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return false
+		}
+		arg, _ := strconv.Atoi(rule.argument)
+		result = (rule.operator == "eq" && n == arg) || (rule.operator == "gt" && n > arg) || (rule.operator == "lt" && n < arg)
+	}
+
+	return result != rule.negate
+}
+
+// evaluate returns the match of a rule on the request, if any
+func (rule wafRule) evaluate(r *wafRequest) (WAFMatch, bool) {
+	for _, variable := range rule.variables {
+		for _, v := range r.values[variable.name] {
+			if variable.key != "" && !strings.EqualFold(variable.key, v.key) {
+				continue
+			}
+
+			value := rule.transform(v.value)
+			if rule.match(value) {
+				name := variable.name
+				if v.key != "" && variable.name != "REQUEST_HEADERS_NAMES" && variable.name != "ARGS_NAMES" && variable.name != "REQUEST_COOKIES_NAMES" {
+					name += ":" + v.key
+				}
+
+				if len(value) > 64 {
+					value = value[:64]
+				}
+				return WAFMatch{ID: rule.id, Msg: rule.msg, Variable: name, Value: value}, true
+			}
+		}
+	}
+
+	return WAFMatch{}, false
+}
+
+// Inspect evaluates all rules on a request, and returns the matches and if the request should be blocked
+func (w *WAF) Inspect(req *http.Request) (result WAFResult) {
+	if w == nil || w.Mode == "" || w.Mode == "off" || len(w.rules) == 0 {
+		return
+	}
+
+	r := newWAFRequest(req, w.MaxBodySize)
+	deny := false
+	for _, rule := range w.rules {
+		if match, ok := rule.evaluate(r); ok {
+			result.Matches = append(result.Matches, match)
+			result.Score += rule.score
+			deny = deny || rule.deny
+		}
+	}
+
+	result.Blocked = w.Mode == "block" && (deny || result.Score >= w.AnomalyThreshold)
+	return
+}
+
+// String returns the matches of a result for logging
+func (r WAFResult) String() string {
+	var matches []string
+	for _, m := range r.Matches {
+		matches = append(matches, fmt.Sprintf("%d:%s=%q %s", m.ID, m.Variable, m.Value, m.Msg))
+	}
+	return strings.Join(matches, "; ")
+}
+
+// SetWAF sets the web application firewall of the listener
+func (l *Listener) SetWAF(c WAFConfig) error {
+	if c.Mode == "" || c.Mode == "off" {
+		l.WAF = nil
+		return nil
+	}
+
+	waf, err := NewWAF(c)
+	if err != nil {
+		return err
+	}
+
+	l.WAF = waf
+	return nil
+}
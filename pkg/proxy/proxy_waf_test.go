@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testWAFRules = []string{
+	`SecRule ARGS|REQUEST_BODY "@rx (?i)union\s+select" "id:1001,phase:2,block,severity:CRITICAL,t:urlDecode,msg:'SQL injection'"`,
+	`SecRule REQUEST_HEADERS:User-Agent "@pm sqlmap nikto" "id:1002,severity:WARNING,msg:'Scanner, detected'"`,
+	`SecRule REQUEST_FILENAME "@contains ../" "id:1003,severity:CRITICAL,msg:'Path traversal'"`,
+	`SecRule ARGS:debug "@streq 1" "id:1004,deny,severity:NOTICE,msg:'Debug requested'"`,
+}
+
+func TestWAFInspect(t *testing.T) {
+	waf, err := NewWAF(WAFConfig{Mode: "block", Rules: testWAFRules})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	req := httptest.NewRequest("GET", "http://www.example.com/search?q=books", nil)
+	result := waf.Inspect(req)
+	assert.Len(t, result.Matches, 0)
+	assert.False(t, result.Blocked)
+
+	req = httptest.NewRequest("GET", "http://www.example.com/search?q=1%20UNION%20SELECT%20password", nil)
+	result = waf.Inspect(req)
+	if assert.Len(t, result.Matches, 1) {
+		assert.Equal(t, 1001, result.Matches[0].ID)
+		assert.Equal(t, "ARGS:q", result.Matches[0].Variable)
+	}
+	assert.True(t, result.Blocked)
+
+	// scores below the threshold are allowed
+	req = httptest.NewRequest("GET", "http://www.example.com/", nil)
+	req.Header.Set("User-Agent", "sqlmap/1.0")
+	result = waf.Inspect(req)
+	assert.Equal(t, 3, result.Score)
+	assert.False(t, result.Blocked)
+	assert.Contains(t, result.String(), "Scanner, detected")
+
+	// deny blocks regardless of the score
+	req = httptest.NewRequest("GET", "http://www.example.com/?debug=1", nil)
+	assert.True(t, waf.Inspect(req).Blocked)
+
+	// form bodies are inspected, and passed on in full
+	body := "name=x&q=union+select+1&pad=" + strings.Repeat("a", 100)
+	req = httptest.NewRequest("POST", "http://www.example.com/form", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	assert.True(t, waf.Inspect(req).Blocked)
+	passed, _ := ioutil.ReadAll(req.Body)
+	assert.Equal(t, body, string(passed))
+
+	// detect only logs
+	detect, _ := NewWAF(WAFConfig{Mode: "detect", Rules: testWAFRules})
+	req = httptest.NewRequest("GET", "http://www.example.com/?debug=1", nil)
+	result = detect.Inspect(req)
+	assert.Len(t, result.Matches, 1)
+	assert.False(t, result.Blocked)
+}
+
+func TestWAFRules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "waf")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "rules.conf")
+	ioutil.WriteFile(file, []byte("# comment\nSecRule REQUEST_METHOD \"!@rx ^(GET|POST)$\" \\\n  \"id:2001,msg:'Method not allowed'\"\n"), 0644)
+	waf, err := NewWAF(WAFConfig{Mode: "block", RuleFiles: []string{file}})
+	if assert.Nil(t, err) {
+		assert.True(t, waf.Inspect(httptest.NewRequest("DELETE", "http://www.example.com/", nil)).Blocked)
+		assert.False(t, waf.Inspect(httptest.NewRequest("GET", "http://www.example.com/", nil)).Blocked)
+	}
+
+	invalid := []string{
+		`SecRule ARGS "@rx a"`,
+		`SecRule FILES "@rx a" "id:1"`,
+		`SecRule ARGS "@detectSQLi" "id:1"`,
+		`SecRule ARGS "@rx (" "id:1"`,
+		`SecRule ARGS "@rx a" "msg:'no id'"`,
+		`SecRule ARGS "@rx a" "id:1,exec:/bin/sh"`,
+	}
+	for _, rule := range invalid {
+		_, err := NewWAF(WAFConfig{Mode: "block", Rules: []string{rule}})
+		assert.NotNil(t, err, rule)
+	}
+
+	assert.NotNil(t, WAFConfig{Mode: "log"}.Validate())
+	assert.NotNil(t, WAFConfig{Mode: "block", Rules: []string{testWAFRules[0], testWAFRules[0]}}.Validate())
+}
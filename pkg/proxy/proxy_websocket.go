@@ -0,0 +1,534 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/schubergphilis/mercury/pkg/logging"
+)
+
+// websocket frame opcodes and close codes used for the frames sent to clients
+const (
+	websocketOpClose      = 0x8
+	websocketOpPing       = 0x9
+	websocketOpPong       = 0xa
+	websocketNormalClose  = 1000
+	websocketGoingAway    = 1001
+	websocketReadBuffer   = 32 * 1024
+	websocketMaxFrameSize = 125
+)
+
+// websocketCloseGrace is the time closing websockets get to send their close frame, before their connections are closed
+var websocketCloseGrace = 1 * time.Second
+
+// WebsocketConfig are the limits of the websocket connections to a backend
+type WebsocketConfig struct {
+	MaxConnections int `json:"max_connections" toml:"max_connections"` // websockets open at the same time, 0 for no limit
+	IdleTimeout    int `json:"idle_timeout" toml:"idle_timeout"`       // seconds without data frames in either direction before a websocket is closed, 0 for no limit
+	PingInterval   int `json:"ping_interval" toml:"ping_interval"`     // seconds between pings sent to the client, 0 to disable
+	PingTimeout    int `json:"ping_timeout" toml:"ping_timeout"`       // seconds the client has to answer a ping before the websocket is closed, 0 for no limit
+}
+
+// WebsocketStatistics are the statistics of the websocket connections to a backend
+type WebsocketStatistics struct {
+	Connected     int64   `json:"connected"`   // websockets currently open
+	Connections   int64   `json:"connections"` // websockets opened
+	Rejected      int64   `json:"rejected"`    // websockets refused due to max_connections
+	BytesIn       int64   `json:"bytes_in"`    // bytes sent by clients to the nodes of closed websockets
+	BytesOut      int64   `json:"bytes_out"`   // bytes sent by the nodes to clients of closed websockets
+	Duration      float64 `json:"duration"`    // average seconds a closed websocket was open
+	closed        int64
+	durationTotal float64
+}
+
+// Validate checks the websocket settings
+func (c WebsocketConfig) Validate() error {
+	if c.MaxConnections < 0 || c.IdleTimeout < 0 || c.PingInterval < 0 || c.PingTimeout < 0 {
+		return fmt.Errorf("websocket max_connections, idle_timeout, ping_interval and ping_timeout can not be negative")
+	}
+
+	if c.PingTimeout > 0 && c.PingInterval == 0 {
+		return fmt.Errorf("websocket ping_timeout requires a ping_interval")
+	}
+
+	return nil
+}
+
+// SetWebsocket sets the limits of the websocket connections to the backend
+func (b *Backend) SetWebsocket(c WebsocketConfig) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	b.sync.Lock()
+	defer b.sync.Unlock()
+	b.Websocket = c
+	return nil
+}
+
+// websocket returns the limits of the websocket connections to the backend
+func (b *Backend) websocket() WebsocketConfig {
+	b.sync.RLock()
+	defer b.sync.RUnlock()
+	return b.Websocket
+}
+
+// websocketStart reserves a websocket connection, or counts a rejected one if the maximum is reached
+func (b *Backend) websocketStart(maxConnections int) bool {
+	b.sync.Lock()
+	defer b.sync.Unlock()
+	if maxConnections > 0 && b.websocketStats.Connected >= int64(maxConnections) {
+		b.websocketStats.Rejected++
+		return false
+	}
+
+	b.websocketStats.Connected++
+	return true
+}
+
+// websocketAbort releases a reserved websocket connection that was not upgraded by the node
+func (b *Backend) websocketAbort() {
+	b.sync.Lock()
+	defer b.sync.Unlock()
+	b.websocketStats.Connected--
+}
+
+// websocketOpen keeps track of an upgraded websocket connection
+func (b *Backend) websocketOpen(w *websocketConn) {
+	b.sync.Lock()
+	defer b.sync.Unlock()
+	if b.websockets == nil {
+		b.websockets = make(map[*websocketConn]struct{})
+	}
+
+	b.websockets[w] = struct{}{}
+	b.websocketStats.Connections++
+}
+
+// websocketClosed stops tracking a websocket connection, and counts its duration and traffic
+func (b *Backend) websocketClosed(w *websocketConn) {
+	b.sync.Lock()
+	defer b.sync.Unlock()
+	delete(b.websockets, w)
+	b.websocketStats.Connected--
+	b.websocketStats.closed++
+	b.websocketStats.durationTotal += time.Since(w.start).Seconds()
+	b.websocketStats.BytesIn += atomic.LoadInt64(&w.bytesIn)
+	b.websocketStats.BytesOut += atomic.LoadInt64(&w.bytesOut)
+}
+
+// WebsocketStatistics returns the statistics of the websocket connections to the backend
+func (b *Backend) WebsocketStatistics() WebsocketStatistics {
+	b.sync.RLock()
+	defer b.sync.RUnlock()
+	stats := b.websocketStats
+	if stats.closed > 0 {
+		stats.Duration = math.Round(stats.durationTotal/float64(stats.closed)*100) / 100
+	}
+
+	return stats
+}
+
+// closeWebsockets sends a close frame to the clients of the websockets to a node, or of all websockets if node is nil
+// it returns the number of websockets that are closing
+func (b *Backend) closeWebsockets(node *BackendNode, code int, reason string) int {
+	b.sync.RLock()
+	var closing []*websocketConn
+	for w := range b.websockets {
+		if node == nil || w.node == node {
+			closing = append(closing, w)
+		}
+	}
+	b.sync.RUnlock()
+
+	for _, w := range closing {
+		w.closeWith(code, reason)
+	}
+
+	return len(closing)
+}
+
+// closeWebsockets sends a close frame to the clients of all websockets of the listener
+func (l *Listener) closeWebsockets(code int, reason string) int {
+	closing := 0
+	for _, backend := range l.Backends {
+		closing += backend.closeWebsockets(nil, code, reason)
+	}
+
+	return closing
+}
+
+// isWebsocket returns true if the request is a websocket upgrade
+func isWebsocket(req *http.Request) bool {
+	return strings.EqualFold(req.Header.Get("Upgrade"), "websocket")
+}
+
+// websocketChunk is data read from the node of a websocket
+type websocketChunk struct {
+	data []byte
+	err  error
+}
+
+// websocketConn is the upgraded connection to the node of a websocket
+// it passes on the frames of the node to the client, and adds ping and close frames in between them
+type websocketConn struct {
+	activity int64 // time of the last data frame on either side of the websocket, in unix nanoseconds
+	pong     int64 // time of the last pong of the client, in unix nanoseconds
+	bytesIn  int64
+	bytesOut int64
+	io.ReadWriteCloser
+	backend   *Backend
+	node      *BackendNode
+	config    WebsocketConfig
+	start     time.Time
+	chunks    chan websocketChunk
+	wake      chan struct{}
+	done      chan struct{}
+	ping      *time.Ticker
+	closeOnce sync.Once
+
+	// state of the data read from the node, only used by Read
+	frames   websocketFrames
+	data     []byte
+	control  []byte
+	err      error
+	pingDue  bool
+	pingSent time.Time // time of the oldest ping not answered by the client
+	finished bool
+
+	// frames of the client, only used by Write
+	clientFrames websocketFrames
+
+	lock        sync.Mutex
+	closeCode   int
+	closeReason string
+}
+
+// newWebsocket starts tracking an upgraded websocket connection to a node
+func (b *Backend) newWebsocket(conn io.ReadWriteCloser, node *BackendNode, c WebsocketConfig) *websocketConn {
+	now := time.Now()
+	w := &websocketConn{
+		ReadWriteCloser: conn,
+		backend:         b,
+		node:            node,
+		config:          c,
+		start:           now,
+		activity:        now.UnixNano(),
+		chunks:          make(chan websocketChunk),
+		wake:            make(chan struct{}, 1),
+		done:            make(chan struct{}),
+	}
+
+	if c.PingInterval > 0 {
+		w.ping = time.NewTicker(seconds(c.PingInterval))
+	}
+
+	b.websocketOpen(w)
+	go w.pump()
+	return w
+}
+
+// pump reads the data of the node, until the websocket is closed
+func (w *websocketConn) pump() {
+	for {
+		buf := make([]byte, websocketReadBuffer)
+		n, err := w.ReadWriteCloser.Read(buf)
+		select {
+		case w.chunks <- websocketChunk{data: buf[:n], err: err}:
+		case <-w.done:
+			return
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Read returns the data of the node for the client, with ping and close frames added between its frames
+// once a close frame is sent, the websocket is finished and io.EOF is returned
+func (w *websocketConn) Read(p []byte) (int, error) {
+	for {
+		if len(w.control) > 0 {
+			n := copy(p, w.control)
+			w.control = w.control[n:]
+			return n, nil
+		}
+
+		if len(w.data) > 0 {
+			// never pass on more than a single frame at once, so frames can be added after it
+			n := w.frames.advance(p[:copy(p, w.data)])
+			w.data = w.data[n:]
+			if data, _ := w.frames.seen(); data {
+				atomic.StoreInt64(&w.activity, time.Now().UnixNano())
+			}
+			atomic.AddInt64(&w.bytesOut, int64(n))
+			return n, nil
+		}
+
+		if w.finished {
+			return 0, io.EOF
+		}
+
+		if w.err != nil {
+			return 0, w.err
+		}
+
+		if w.frames.boundary() {
+			if code, reason := w.closeRequest(); code != 0 {
+				w.control = websocketCloseFrame(code, reason)
+				w.finished = true
+				continue
+			}
+
+			if w.pingDue {
+				w.control = websocketFrame(websocketOpPing, nil)
+				w.pingDue = false
+				if w.pingSent.IsZero() {
+					w.pingSent = time.Now()
+				}
+				continue
+			}
+		}
+
+		w.wait()
+	}
+}
+
+// wait waits for data of the node, a ping, a close request, the idle timeout or the timeout of a ping
+func (w *websocketConn) wait() {
+	var idle <-chan time.Time
+	if w.config.IdleTimeout > 0 {
+		timer := time.NewTimer(time.Until(w.lastActivity().Add(seconds(w.config.IdleTimeout))))
+		defer timer.Stop()
+		idle = timer.C
+	}
+
+	if !w.pingSent.IsZero() && !w.lastPong().Before(w.pingSent) {
+		w.pingSent = time.Time{}
+	}
+
+	var pingTimeout <-chan time.Time
+	if w.config.PingTimeout > 0 && !w.pingSent.IsZero() {
+		timer := time.NewTimer(time.Until(w.pingSent.Add(seconds(w.config.PingTimeout))))
+		defer timer.Stop()
+		pingTimeout = timer.C
+	}
+
+	var ping <-chan time.Time
+	if w.ping != nil {
+		ping = w.ping.C
+	}
+
+	select {
+	case chunk := <-w.chunks:
+		w.data = chunk.data
+		w.err = chunk.err
+
+	case <-ping:
+		w.pingDue = true
+
+	case <-idle:
+		// the client sent data in the mean time
+		if time.Since(w.lastActivity()) < seconds(w.config.IdleTimeout) {
+			return
+		}
+
+		logging.For("proxy/websocket").WithField("backend", w.backend.UUID).WithField("duration", time.Since(w.start).Seconds()).Info("Closing idle websocket")
+		w.closeWith(websocketNormalClose, "idle timeout")
+
+	case <-pingTimeout:
+		// the client answered in the mean time
+		if !w.lastPong().Before(w.pingSent) {
+			return
+		}
+
+		logging.For("proxy/websocket").WithField("backend", w.backend.UUID).WithField("duration", time.Since(w.start).Seconds()).Info("Closing websocket of which the client does not answer pings")
+		w.closeWith(websocketNormalClose, "ping timeout")
+
+	case <-w.wake:
+	}
+}
+
+// Write passes on the data of the client to the node
+// only data frames of the client count as activity, pongs are the answers to our pings
+func (w *websocketConn) Write(p []byte) (int, error) {
+	for b := p; len(b) > 0; {
+		b = b[w.clientFrames.advance(b):]
+	}
+
+	data, pong := w.clientFrames.seen()
+	if data {
+		atomic.StoreInt64(&w.activity, time.Now().UnixNano())
+	}
+	if pong {
+		atomic.StoreInt64(&w.pong, time.Now().UnixNano())
+	}
+
+	n, err := w.ReadWriteCloser.Write(p)
+	atomic.AddInt64(&w.bytesIn, int64(n))
+	return n, err
+}
+
+// Close closes the connection to the node, and stops tracking the websocket
+func (w *websocketConn) Close() error {
+	w.closeOnce.Do(func() {
+		close(w.done)
+		if w.ping != nil {
+			w.ping.Stop()
+		}
+		w.backend.websocketClosed(w)
+	})
+
+	return w.ReadWriteCloser.Close()
+}
+
+// closeWith requests a close frame to be sent to the client, after the frame of the node it is receiving
+func (w *websocketConn) closeWith(code int, reason string) {
+	w.lock.Lock()
+	if w.closeCode == 0 {
+		w.closeCode = code
+		w.closeReason = reason
+	}
+	w.lock.Unlock()
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// closeRequest returns the requested close code and reason, or 0 if the websocket should stay open
+func (w *websocketConn) closeRequest() (int, string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.closeCode, w.closeReason
+}
+
+// lastActivity returns the time of the last data frame on either side of the websocket
+func (w *websocketConn) lastActivity() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&w.activity))
+}
+
+// lastPong returns the time of the last pong of the client
+func (w *websocketConn) lastPong() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&w.pong))
+}
+
+// websocketFrames follows the frames sent by a node, to find the boundaries between them
+type websocketFrames struct {
+	header    []byte // header of the current frame read so far
+	remaining uint64 // payload bytes of the current frame not read yet
+	data      bool   // a data frame started since the last call to seen
+	pong      bool   // a pong frame started since the last call to seen
+}
+
+// seen returns if a data frame and if a pong frame started since the last call
+func (f *websocketFrames) seen() (data bool, pong bool) {
+	data, pong = f.data, f.pong
+	f.data, f.pong = false, false
+	return data, pong
+}
+
+// boundary returns true if no frame is partially read
+func (f *websocketFrames) boundary() bool {
+	return len(f.header) == 0 && f.remaining == 0
+}
+
+// advance follows the frames in b, and returns the number of bytes up to the end of the first frame that ends in b
+func (f *websocketFrames) advance(b []byte) int {
+	i := 0
+	for i < len(b) {
+		if f.remaining > 0 {
+			n := f.remaining
+			if rest := uint64(len(b) - i); rest < n {
+				n = rest
+			}
+			i += int(n)
+			f.remaining -= n
+			if f.remaining == 0 {
+				return i
+			}
+			continue
+		}
+
+		f.header = append(f.header, b[i])
+		i++
+		length, ok := websocketPayloadLength(f.header)
+		if !ok {
+			continue
+		}
+
+		switch opcode := f.header[0] & 0x0f; {
+		case opcode < websocketOpClose:
+			f.data = true
+		case opcode == websocketOpPong:
+			f.pong = true
+		}
+
+		f.header = f.header[:0]
+		f.remaining = length
+		if length == 0 {
+			return i
+		}
+	}
+
+	return i
+}
+
+// websocketPayloadLength returns the payload length of a frame, if its header is complete
+func websocketPayloadLength(header []byte) (uint64, bool) {
+	if len(header) < 2 {
+		return 0, false
+	}
+
+	size := 2
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		size += 2
+	case 127:
+		size += 8
+	}
+
+	// masked frames have a 4 byte masking key
+	if header[1]&0x80 != 0 {
+		size += 4
+	}
+
+	if len(header) < size {
+		return 0, false
+	}
+
+	switch length {
+	case 126:
+		length = uint64(binary.BigEndian.Uint16(header[2:4]))
+	case 127:
+		length = binary.BigEndian.Uint64(header[2:10])
+	}
+
+	return length, true
+}
+
+// websocketFrame returns an unmasked control frame, as sent by a server
+func websocketFrame(opcode byte, payload []byte) []byte {
+	if len(payload) > websocketMaxFrameSize {
+		payload = payload[:websocketMaxFrameSize]
+	}
+
+	return append([]byte{0x80 | opcode, byte(len(payload))}, payload...)
+}
+
+// websocketCloseFrame returns a close frame with the code and reason
+func websocketCloseFrame(code int, reason string) []byte {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	return websocketFrame(websocketOpClose, append(payload, reason...))
+}
@@ -7,6 +7,7 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"strconv"
 	"testing"
 	"time"
@@ -169,3 +170,164 @@ func client(url string) error {
 	c.Close(websocket.StatusNormalClosure, "")
 	return nil
 }
+
+func TestWebsocketFrames(t *testing.T) {
+	// a masked frame with a 3 byte payload, an empty ping, and a frame with a 16 bit length
+	data := []byte{0x81, 0x83, 1, 2, 3, 4, 'a', 'b', 'c', 0x89, 0x00, 0x82, 126, 0x01, 0x00}
+	data = append(data, make([]byte, 256)...)
+
+	f := websocketFrames{}
+	assert.True(t, f.boundary())
+	assert.Equal(t, 9, f.advance(data))
+	assert.True(t, f.boundary())
+	isData, isPong := f.seen()
+	assert.True(t, isData)
+	assert.False(t, isPong)
+	assert.Equal(t, 2, f.advance(data[9:]))
+	isData, isPong = f.seen()
+	assert.False(t, isData)
+	assert.False(t, isPong)
+
+	// pongs are not data
+	assert.Equal(t, 6, f.advance([]byte{0x8a, 0x80, 1, 2, 3, 4}))
+	isData, isPong = f.seen()
+	assert.False(t, isData)
+	assert.True(t, isPong)
+
+	// headers and payloads split over reads
+	assert.Equal(t, 3, f.advance(data[11:14]))
+	assert.False(t, f.boundary())
+	assert.Equal(t, 100, f.advance(data[14:114]))
+	assert.False(t, f.boundary())
+	assert.Equal(t, 157, f.advance(data[114:]))
+	assert.True(t, f.boundary())
+
+	assert.Equal(t, []byte{0x88, 0x0c, 0x03, 0xe9, 'g', 'o', 'i', 'n', 'g', ' ', 'a', 'w', 'a', 'y'}, websocketCloseFrame(websocketGoingAway, "going away"))
+	assert.NotNil(t, WebsocketConfig{IdleTimeout: -1}.Validate())
+	assert.NotNil(t, WebsocketConfig{PingTimeout: 1}.Validate())
+	assert.Nil(t, WebsocketConfig{PingInterval: 1, PingTimeout: 1}.Validate())
+}
+
+func TestWebsocketLimits(t *testing.T) {
+	logging.Configure("stdout", "error")
+
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close(websocket.StatusInternalError, "")
+
+		for {
+			typ, msg, err := c.Read(context.Background())
+			if err != nil {
+				return
+			}
+			if err := c.Write(context.Background(), typ, msg); err != nil {
+				return
+			}
+		}
+	}))
+	defer node.Close()
+
+	nodeHost, nodePortStr, _ := net.SplitHostPort(node.Listener.Addr().String())
+	nodePort, _ := strconv.Atoi(nodePortStr)
+
+	l := New("listener-id", "Listener", 999)
+	l.HTTPProto = 1
+	l.AddBackend("backend-id", "backend", "leastconnected", "http", []string{"default"}, 999, ErrorPage{}, ErrorPage{})
+	backend := l.Backends["backend"]
+	backend.AddBackendNode(NewBackendNode("node-id", nodeHost, "localhost", nodePort, 10, []string{}, 0, 0, healthcheck.Online))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.Nil(t, err) {
+		return
+	}
+	l.socket = limitListenerConnections(listener.(*net.TCPListener), 10)
+	srv := &http.Server{Handler: l.NewHTTPProxy()}
+	go srv.Serve(l.socket)
+	defer srv.Close()
+	defer listener.Close()
+
+	url := "ws://" + listener.Addr().String()
+	closeError := func(c *websocket.Conn) websocket.CloseError {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		var cerr websocket.CloseError
+		_, _, err := c.Read(ctx)
+		xerrors.As(err, &cerr)
+		return cerr
+	}
+
+	// the second websocket is refused, and the first is closed when idle
+	assert.Nil(t, backend.SetWebsocket(WebsocketConfig{MaxConnections: 1, IdleTimeout: 1}))
+	c, _, err := websocket.Dial(context.Background(), url, nil)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Nil(t, c.Write(context.Background(), websocket.MessageText, []byte("hello")))
+	_, msg, err := c.Read(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(msg))
+
+	_, res, err := websocket.Dial(context.Background(), url, nil)
+	if assert.NotNil(t, err) && assert.NotNil(t, res) {
+		assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+	}
+
+	cerr := closeError(c)
+	assert.Equal(t, websocket.StatusNormalClosure, cerr.Code)
+	assert.Equal(t, "idle timeout", cerr.Reason)
+	for i := 0; i < 10 && backend.WebsocketStatistics().Connected > 0; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// clients answering pings are idle, when no data is sent
+	assert.Nil(t, backend.SetWebsocket(WebsocketConfig{MaxConnections: 1, IdleTimeout: 2, PingInterval: 1, PingTimeout: 1}))
+	c, _, err = websocket.Dial(context.Background(), url, nil)
+	if !assert.Nil(t, err) {
+		return
+	}
+	start := time.Now()
+	cerr = closeError(c)
+	assert.Equal(t, websocket.StatusNormalClosure, cerr.Code)
+	assert.Equal(t, "idle timeout", cerr.Reason)
+	assert.True(t, time.Since(start) < 2500*time.Millisecond, "closed after %s", time.Since(start))
+	for i := 0; i < 10 && backend.WebsocketStatistics().Connected > 0; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// clients not answering pings are closed
+	c, _, err = websocket.Dial(context.Background(), url, nil)
+	if !assert.Nil(t, err) {
+		return
+	}
+	start = time.Now()
+	for i := 0; i < 50 && backend.WebsocketStatistics().Connected > 0; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.Equal(t, int64(0), backend.WebsocketStatistics().Connected)
+	assert.True(t, time.Since(start) > 1500*time.Millisecond && time.Since(start) < 2500*time.Millisecond, "closed after %s", time.Since(start))
+	c.Close(websocket.StatusNormalClosure, "")
+
+	// clients are pinged, and the websocket is closed with a close frame on a drain or reload
+	assert.Nil(t, backend.SetWebsocket(WebsocketConfig{MaxConnections: 1, PingInterval: 1, PingTimeout: 1}))
+	c, _, err = websocket.Dial(context.Background(), url, nil)
+	if !assert.Nil(t, err) {
+		return
+	}
+	time.AfterFunc(3*time.Second, func() {
+		l.closeWebsockets(websocketGoingAway, "going away")
+	})
+	start = time.Now()
+	cerr = closeError(c)
+	assert.Equal(t, websocket.StatusGoingAway, cerr.Code)
+	assert.True(t, time.Since(start) > 2500*time.Millisecond, "closed after %s", time.Since(start))
+
+	time.Sleep(100 * time.Millisecond)
+	stats := backend.WebsocketStatistics()
+	assert.Equal(t, int64(0), stats.Connected)
+	assert.Equal(t, int64(4), stats.Connections)
+	assert.Equal(t, int64(1), stats.Rejected)
+	assert.True(t, stats.BytesIn > 0 && stats.BytesOut > 0)
+}